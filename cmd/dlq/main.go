@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/queue"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Command dlq inspects, replays or purges the dead-letter queue of a worker
+// queue.
+//
+//	go run ./cmd/dlq -queue go.logger -limit 10 inspect
+//	go run ./cmd/dlq -queue go.logger replay
+//	go run ./cmd/dlq -queue go.logger purge
+
+func main() {
+	queueName := flag.String("queue", config.DefaultQueueName, "work queue whose DLQ to manage")
+	limit := flag.Int("limit", 0, "maximum number of messages to inspect or replay (0 = all)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] inspect|replay|purge\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	action := flag.Arg(0)
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Use the worker's definition of the queue so custom DLX/DLQ names apply
+	qc := findQueue(cfg, *queueName)
+
+	conn, err := amqp.Dial(cfg.GetRabbitMQURL())
+	if err != nil {
+		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Fatalf("Failed to open channel: %v", err)
+	}
+	defer ch.Close()
+
+	mgr, err := queue.NewDeadLetterManager(ch, qc)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	switch action {
+	case "inspect":
+		letters, err := mgr.Inspect(*limit)
+		if err != nil {
+			log.Fatalf("Failed to inspect %s: %v", qc.DeadLetterQueue, err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(letters); err != nil {
+			log.Fatalf("Failed to encode output: %v", err)
+		}
+	case "replay":
+		n, err := mgr.Replay(*limit)
+		if err != nil {
+			log.Fatalf("Replayed %d message(s) from %s before failing: %v", n, qc.DeadLetterQueue, err)
+		}
+		log.Printf("Replayed %d message(s) from %s to %s", n, qc.DeadLetterQueue, qc.Name)
+	case "purge":
+		n, err := mgr.Purge()
+		if err != nil {
+			log.Fatalf("Failed to purge %s: %v", qc.DeadLetterQueue, err)
+		}
+		log.Printf("Purged %d message(s) from %s", n, qc.DeadLetterQueue)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func findQueue(cfg *config.Config, name string) config.QueueConfig {
+	for _, q := range cfg.GetQueues() {
+		if q.Name == name {
+			return q
+		}
+	}
+	return (&config.Config{Queues: []config.QueueConfig{{Name: name}}}).GetQueues()[0]
+}
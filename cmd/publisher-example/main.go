@@ -6,6 +6,7 @@ import (
 
 	"base-go-app/internal/config"
 	"base-go-app/internal/publisher"
+	"base-go-app/internal/tasks"
 )
 
 // Example demonstrating how to publish tasks to RabbitMQ
@@ -85,8 +86,8 @@ func main() {
 	maxAttempts := 3
 	options := &publisher.TaskOptions{
 		TimeoutSeconds: &timeout,
-		Notify: map[string]string{
-			"webhook": "http://example.com/webhook/callback",
+		Notify: &tasks.NotifyConfig{
+			Webhook: &tasks.WebhookConfig{URL: "http://example.com/webhook/callback"},
 		},
 		MaxAttempts: &maxAttempts,
 	}
@@ -105,7 +106,7 @@ func main() {
 		log.Printf("✓ Notification task submitted with ID: %s", taskID)
 		log.Printf("  - Timeout: %d seconds", timeout)
 		log.Printf("  - Max Attempts: %d", maxAttempts)
-		log.Printf("  - Webhook: %s", options.Notify["webhook"])
+		log.Printf("  - Webhook: %s", options.Notify.Webhook.URL)
 	}
 
 	log.Println("\n=== All examples completed! ===")
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	// Schedule time zones must resolve in minimal images without tzdata
+	_ "time/tzdata"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/database"
+	"base-go-app/internal/logging"
+	"base-go-app/internal/publisher"
+	"base-go-app/internal/scheduler"
+	"base-go-app/internal/tracing"
+)
+
+// Command scheduler publishes periodic tasks from a JSON schedule, like
+// Celery beat. Run several replicas with SCHEDULER_LOCK=database so that
+// only one of them publishes each tick.
+//
+//	go run ./cmd/scheduler -schedule schedule.json
+
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(1)
+}
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+	if _, err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+
+	path := flag.String("schedule", cfg.ScheduleFile, "JSON schedule file (default $SCHEDULE_FILE)")
+	flag.Parse()
+	if *path == "" {
+		fatal("No schedule", errors.New("set SCHEDULE_FILE or -schedule"))
+	}
+
+	entries, err := scheduler.LoadFile(*path)
+	if err != nil {
+		fatal("Failed to load schedule", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.TracesExporter, "go-scheduler")
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	var locker scheduler.Locker
+	if cfg.SchedulerLock == config.SchedulerLockDatabase {
+		// Connects in the background; no replica publishes until it is up
+		if err := database.Connect(cfg); err != nil {
+			slog.Warn("Failed to start database connection", logging.Err(err))
+		}
+		defer database.Close()
+		locker = scheduler.NewDBLocker()
+	}
+
+	pub, err := publisher.NewPublisher(cfg)
+	if err != nil {
+		fatal("Failed to create publisher", err)
+	}
+	defer pub.Close()
+
+	s, err := scheduler.New(entries, pub, locker, time.Duration(cfg.SchedulerLockTTLSeconds)*time.Second)
+	if err != nil {
+		fatal("Failed to create scheduler", err)
+	}
+
+	slog.Info("Scheduler started", "entries", len(entries))
+	s.Run(ctx)
+	slog.Info("Scheduler stopped")
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
-	"context"
 
+	"base-go-app/internal/admin"
+	"base-go-app/internal/config"
 	"base-go-app/internal/database"
 	"base-go-app/internal/queue"
 
@@ -13,26 +17,78 @@ import (
 	"gorm.io/gorm"
 )
 
-func TestHealthHandlerDown(t *testing.T) {
+// probe requests path from the health server and decodes the JSON body.
+func probe(t *testing.T, mux *http.ServeMux, path string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequestWithContext(context.Background(), "GET", path, nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var body map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	return w.Code, body
+}
+
+// check returns the result of a check in a probe body.
+func check(t *testing.T, body map[string]interface{}, name string) map[string]interface{} {
+	t.Helper()
+	checks, _ := body["checks"].(map[string]interface{})
+	res, ok := checks[name].(map[string]interface{})
+	if !ok {
+		t.Fatalf("missing check %s in %v", name, body)
+	}
+	return res
+}
+
+func TestHealthDown(t *testing.T) {
 	// Ensure DB and Rabbit are down
-	// Clear DB
-	// Database Clear function is in database package
-	// Using fully-qualified package name to modify state
 	database.ClearDBForTests()
 	queue.SetRabbitConnectedForTests(false)
+	mux := healthMux(newChecker(&config.Config{}), nil)
 
-	req := httptest.NewRequest("GET", "/healthcheck", nil)
-	w := httptest.NewRecorder()
-	h := healthHandler()
-	h.ServeHTTP(w, req)
-	resp := w.Result()
-	// We now expect 200 even if down, but with status=degraded in body
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("expected 200 (degraded) when db and rabbit down, got %d", resp.StatusCode)
+	// Broker down: not ready, but alive
+	code, body := probe(t, mux, "/readyz")
+	if code != http.StatusServiceUnavailable || body["status"] != "unavailable" {
+		t.Fatalf("expected 503 unavailable, got %d %v", code, body)
+	}
+	if res := check(t, body, "rabbitmq"); res["ok"] != false || res["critical"] != true {
+		t.Fatalf("unexpected rabbitmq check: %v", res)
+	}
+	if res := check(t, body, "database"); res["ok"] != false || res["error"] == nil || res["last_success"] != nil {
+		t.Fatalf("unexpected database check: %v", res)
+	}
+	if code, _ := probe(t, mux, "/livez"); code != http.StatusOK {
+		t.Fatalf("expected live, got %d", code)
+	}
+
+	code, body = probe(t, mux, "/healthcheck")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 from /healthcheck, got %d", code)
+	}
+	if body["in_flight"] != float64(0) {
+		t.Fatalf("unexpected body: %v", body)
+	}
+}
+
+func TestHealthDegraded(t *testing.T) {
+	database.ClearDBForTests()
+	queue.SetRabbitConnectedForTests(true)
+	defer queue.SetRabbitConnectedForTests(false)
+
+	// Without database stores the database is optional
+	code, body := probe(t, healthMux(newChecker(&config.Config{}), nil), "/readyz")
+	if code != http.StatusOK || body["status"] != "degraded" {
+		t.Fatalf("expected 200 degraded, got %d %v", code, body)
+	}
+
+	code, body = probe(t, healthMux(newChecker(&config.Config{ResultBackend: config.ResultBackendDatabase}), nil), "/readyz")
+	if code != http.StatusServiceUnavailable || check(t, body, "database")["critical"] != true {
+		t.Fatalf("expected 503 with a critical database check, got %d %v", code, body)
 	}
 }
 
-func TestHealthHandlerOK(t *testing.T) {
+func TestHealthOK(t *testing.T) {
 	// Set DB and rabbit as up via test helpers
 	sqliteDB := setupSQLiteForTest(t)
 	database.SetDBForTests(sqliteDB)
@@ -40,13 +96,23 @@ func TestHealthHandlerOK(t *testing.T) {
 	defer database.ClearDBForTests()
 	defer queue.SetRabbitConnectedForTests(false)
 
-	req := httptest.NewRequestWithContext(context.Background(), "GET", "/healthcheck", nil)
-	w := httptest.NewRecorder()
-	h := healthHandler()
-	h.ServeHTTP(w, req)
-	resp := w.Result()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	mux := healthMux(newChecker(&config.Config{}), nil)
+	for _, path := range []string{"/livez", "/readyz", "/healthz"} {
+		code, body := probe(t, mux, path)
+		if code != http.StatusOK || body["status"] != "ok" {
+			t.Fatalf("%s: expected 200 ok, got %d %v", path, code, body)
+		}
+	}
+
+	_, body := probe(t, mux, "/healthz")
+	if check(t, body, "database")["last_success"] == nil {
+		t.Fatalf("expected a last success, got %v", body)
+	}
+	if check(t, body, "workers")["ok"] != true {
+		t.Fatalf("expected the liveness checks in /healthz, got %v", body)
+	}
+	if _, ok := body["draining"]; ok {
+		t.Fatalf("not draining, got %v", body)
 	}
 }
 
@@ -59,3 +125,59 @@ func setupSQLiteForTest(t *testing.T) *gorm.DB {
 	}
 	return db
 }
+
+func TestMetricsEndpoint(t *testing.T) {
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	healthMux(newChecker(&config.Config{}), nil).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	// Labeled metrics only appear once they have a value
+	for _, name := range []string{"worker_tasks_in_flight", "go_goroutines"} {
+		if !strings.Contains(w.Body.String(), "# TYPE "+name+" ") {
+			t.Fatalf("missing %s in:\n%s", name, w.Body.String())
+		}
+	}
+}
+
+func TestAdminEndpoint(t *testing.T) {
+	req := httptest.NewRequest("GET", "/admin/tasks", nil)
+	w := httptest.NewRecorder()
+	healthMux(newChecker(&config.Config{}), nil).ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected the admin API to be off without a token, got %d", w.Code)
+	}
+
+	mux := healthMux(newChecker(&config.Config{}), admin.Handler("s3cret", worker{}))
+	defer queue.ResumeAll()
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/admin/drain", nil))
+	if w.Code != http.StatusUnauthorized || queue.Drained() {
+		t.Fatalf("expected an unauthenticated drain to be refused, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/admin/drain", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted || !queue.Drained() {
+		t.Fatalf("expected the worker to be drained, got %d", w.Code)
+	}
+
+	// Drained workers stay up but are taken out of service
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "worker is drained") {
+		t.Fatalf("expected a drained worker not to be ready, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/admin/resume", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || queue.Drained() {
+		t.Fatalf("expected the drain to end, got %d", w.Code)
+	}
+}
@@ -2,116 +2,185 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"base-go-app/internal/admin"
 	"base-go-app/internal/config"
 	"base-go-app/internal/database"
+	"base-go-app/internal/health"
+	"base-go-app/internal/logging"
+	"base-go-app/internal/metrics"
 	"base-go-app/internal/queue"
+	"base-go-app/internal/tracing"
 )
 
-// healthHandler returns an http.HandlerFunc for /healthcheck
-func healthHandler() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
-		defer cancel()
-
-		status := http.StatusOK
-		body := map[string]interface{}{"status": "ok"}
-
-		// Check database
-		if ok, err := database.Ping(ctx); !ok {
-			body["database"] = false
-			if err != nil {
-				body["database_error"] = err.Error()
-			}
-			// DB is optional, so we don't fail the healthcheck
-			// status = http.StatusInternalServerError
-			body["status"] = "degraded"
-		} else {
-			body["database"] = true
-		}
+// newChecker registers the worker's health checks. Call it after
+// queue.StartConsumer, so that every queue gets a consumer check.
+func newChecker(cfg *config.Config) *health.Checker {
+	checker := health.NewChecker()
 
-		// Check rabbit
-		rabbitOk := queue.RabbitConnected()
-		body["rabbitmq"] = rabbitOk
-		if !rabbitOk {
-			// prefer to mark degraded
-			if status == http.StatusOK {
-				// RabbitMQ is critical? If so, keep 500. If optional, use 200.
-				// User asked for resilience when RabbitMQ is not up too.
-				// Let's keep it 200 but degraded for now to prevent restart loops.
-				// status = http.StatusInternalServerError
-				body["status"] = "degraded"
-			}
-		}
+	// Liveness: only what a restart can fix
+	checker.AddLiveness(queue.StallCheck(cfg.GetHealthStall()))
+
+	// Readiness
+	checker.AddReadiness(queue.BrokerCheck())
+	checker.AddReadiness(queue.DrainCheck())
+	for _, c := range queue.ConsumerChecks() {
+		checker.AddReadiness(c)
+	}
+	checker.AddReadiness(queue.SaturationCheck())
+	// The database is optional unless a store lives in it
+	checker.AddReadiness(health.Check{Name: "database", Critical: cfg.UsesDatabase(), Run: pingDatabase})
+
+	// Notification targets, checked at most every 30 seconds
+	client := &http.Client{Timeout: health.DefaultTimeout}
+	if url := os.Getenv("SOCKUDO_URL"); url != "" {
+		checker.AddReadiness(health.Check{Name: "sockudo", Interval: 30 * time.Second, Run: health.Reachable(client, url)})
+	}
+	if url := os.Getenv("WEBHOOK_OAUTH_TOKEN_URL"); url != "" {
+		checker.AddReadiness(health.Check{Name: "oauth_token", Interval: 30 * time.Second, Run: health.Reachable(client, url)})
+	}
+	return checker
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(status)
-		_ = json.NewEncoder(w).Encode(body)
+func pingDatabase(ctx context.Context) error {
+	ok, err := database.Ping(ctx)
+	if !ok && err == nil {
+		err = errors.New("not connected to the database")
 	}
+	return err
 }
 
-func startHealthServer() {
+// healthDetails adds the shutdown progress and the queues to /healthz.
+func healthDetails() map[string]interface{} {
+	stats := queue.Stats()
+	body := map[string]interface{}{
+		"in_flight": stats.InFlight,
+		"queues":    queue.Queues(),
+	}
+	if stats.Draining {
+		body["draining"] = true
+		body["requeued"] = stats.Requeued
+	}
+	if queue.Drained() {
+		body["drained"] = true
+	}
+	return body
+}
+
+// worker exposes the consumer to the admin API.
+type worker struct{}
+
+func (worker) Queues() []queue.QueueStatus             { return queue.Queues() }
+func (worker) InFlight() []queue.InFlightTask          { return queue.InFlight() }
+func (worker) Pause(name string) error                 { return queue.Pause(name) }
+func (worker) Resume(name string) error                { return queue.Resume(name) }
+func (worker) SetConcurrency(name string, n int) error { return queue.SetConcurrency(name, n) }
+func (worker) Drain()                                  { queue.Drain() }
+func (worker) ResumeAll()                              { queue.ResumeAll() }
+
+// healthMux routes the probes: /livez and /readyz for Kubernetes, /healthz
+// (and the legacy /healthcheck) with every check, and /metrics. adminAPI,
+// when set, is served under /admin/.
+func healthMux(checker *health.Checker, adminAPI http.Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	if adminAPI != nil {
+		mux.Handle("/admin/", adminAPI)
+	}
+	mux.HandleFunc("/livez", health.Handler(checker.Live, nil))
+	mux.HandleFunc("/readyz", health.Handler(checker.Ready, nil))
+	mux.HandleFunc("/healthz", health.Handler(checker.All, healthDetails))
+	mux.HandleFunc("/healthcheck", health.Handler(checker.All, healthDetails))
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+func startHealthServer(checker *health.Checker, adminAPI http.Handler) {
 	port := os.Getenv("HEALTH_PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	http.HandleFunc("/healthcheck", healthHandler())
-
 	addr := fmt.Sprintf(":%s", port)
+	mux := healthMux(checker, adminAPI)
 	go func() {
-		log.Printf("Health server listening on %s", addr)
-		if err := http.ListenAndServe(addr, nil); err != nil {
-			log.Fatalf("Health server failed: %v", err)
+		slog.Info("Health server listening", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fatal("Health server failed", err)
 		}
 	}()
 }
 
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(1)
+}
+
 func main() {
 	// Load Configuration
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		fatal("Failed to load config", err)
+	}
+	if _, err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		fatal("Failed to set up logging", err)
 	}
 
 	// Create a context that is canceled on SIGINT or SIGTERM
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	// Start Health Server early so readiness is visible
-	startHealthServer()
+	shutdownTracing, err := tracing.Setup(ctx, cfg.TracesExporter, "go-worker")
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
 
 	// Start Queue Consumer (prioritized) and get the done channel
 	done := queue.StartConsumer(ctx, cfg)
 
+	// Start Health Server early so readiness is visible
+	var adminAPI http.Handler
+	if cfg.AdminToken != "" {
+		adminAPI = admin.Handler(cfg.AdminToken, worker{})
+	}
+	startHealthServer(newChecker(cfg), adminAPI)
+
 	// Connect to Database (manages its own background reconnects)
 	if err := database.Connect(cfg); err != nil {
-		log.Printf("Failed to start database connection: %v", err)
+		slog.Warn("Failed to start database connection", logging.Err(err))
 	}
 	// Wait for termination signal
 	<-ctx.Done()
-	log.Println("Shutting down...")
+	slog.Info("Shutting down")
 
-	// Attempt graceful shutdown: wait for consumer to stop with timeout
+	// Attempt graceful shutdown: the consumer drains within the grace
+	// period, plus a little time to cancel what is left
 	select {
 	case <-done:
-		log.Println("Consumer stopped")
-	case <-time.After(10 * time.Second):
-		log.Println("Timeout waiting for consumer shutdown")
+		slog.Info("Consumer stopped")
+	case <-time.After(cfg.GetShutdownGrace() + 10*time.Second):
+		slog.Warn("Timeout waiting for consumer shutdown")
 	}
 
 	// Close DB connection
 	if err := database.Close(); err != nil {
-		log.Printf("Error closing database: %v", err)
+		slog.Error("Error closing database", logging.Err(err))
+	}
+
+	// Flush the spans of the last tasks
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Error flushing traces", logging.Err(err))
 	}
 
-	log.Println("Shutdown complete")
+	slog.Info("Shutdown complete")
 }
@@ -0,0 +1,147 @@
+// Package admin serves the worker's admin API: the registered tasks, the
+// queues and the tasks in flight, and controls to pause or resume a queue,
+// resize its worker pool, or drain the whole worker and resume it.
+//
+// Every request must carry the configured token as a bearer token.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"base-go-app/internal/queue"
+	"base-go-app/internal/tasks"
+)
+
+// Worker is what the admin API controls.
+type Worker interface {
+	Queues() []queue.QueueStatus
+	InFlight() []queue.InFlightTask
+	Pause(queue string) error
+	Resume(queue string) error
+	SetConcurrency(queue string, n int) error
+	// Drain pauses every queue and takes the worker out of service,
+	// without stopping it.
+	Drain()
+	// ResumeAll resumes every queue and ends a drain.
+	ResumeAll()
+}
+
+// inFlightTask is an InFlightTask with its age.
+type inFlightTask struct {
+	queue.InFlightTask
+	AgeSeconds float64 `json:"age_seconds"`
+}
+
+// Handler serves the admin API under /admin/:
+//
+//	GET  /admin/tasks                        registered task names
+//	GET  /admin/queues                       queue status
+//	GET  /admin/inflight                     tasks being executed
+//	POST /admin/queues/{name}/pause          stop consuming from a queue
+//	POST /admin/queues/{name}/resume         consume from it again
+//	PUT  /admin/queues/{name}/concurrency    resize its pool: {"concurrency": n}
+//	POST /admin/drain                        pause every queue, fail readiness
+//	POST /admin/resume                       resume every queue, end a drain
+func Handler(token string, w Worker) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/tasks", func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"tasks": tasks.RegisteredTasks()})
+	})
+	mux.HandleFunc("GET /admin/queues", func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"queues": w.Queues()})
+	})
+	mux.HandleFunc("GET /admin/inflight", func(rw http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		running := []inFlightTask{}
+		for _, t := range w.InFlight() {
+			running = append(running, inFlightTask{InFlightTask: t, AgeSeconds: now.Sub(t.Started).Seconds()})
+		}
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"tasks": running})
+	})
+	mux.HandleFunc("POST /admin/queues/{name}/pause", func(rw http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		respond(rw, w, name, w.Pause(name))
+	})
+	mux.HandleFunc("POST /admin/queues/{name}/resume", func(rw http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		respond(rw, w, name, w.Resume(name))
+	})
+	mux.HandleFunc("PUT /admin/queues/{name}/concurrency", func(rw http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Concurrency int `json:"concurrency"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(rw, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+		name := r.PathValue("name")
+		respond(rw, w, name, w.SetConcurrency(name, body.Concurrency))
+	})
+	mux.HandleFunc("POST /admin/drain", func(rw http.ResponseWriter, r *http.Request) {
+		slog.Warn("Drain requested through the admin API", "remote_addr", r.RemoteAddr)
+		w.Drain()
+		// Tasks in flight still finish; /admin/inflight shows them
+		writeJSON(rw, http.StatusAccepted, map[string]interface{}{"status": "draining", "in_flight": len(w.InFlight())})
+	})
+	mux.HandleFunc("POST /admin/resume", func(rw http.ResponseWriter, r *http.Request) {
+		slog.Info("Resume requested through the admin API", "remote_addr", r.RemoteAddr)
+		w.ResumeAll()
+		writeJSON(rw, http.StatusOK, map[string]interface{}{"status": "consuming"})
+	})
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(rw, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		mux.ServeHTTP(rw, r)
+	})
+}
+
+// authorized reports whether r carries token as its bearer token. An empty
+// token rejects everything.
+func authorized(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// respond answers a queue control request with the queue's new status, or
+// with err.
+func respond(rw http.ResponseWriter, w Worker, name string, err error) {
+	switch {
+	case errors.Is(err, queue.ErrUnknownQueue):
+		writeError(rw, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, queue.ErrInvalidConcurrency):
+		writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		writeError(rw, http.StatusConflict, err.Error())
+		return
+	}
+	for _, s := range w.Queues() {
+		if s.Name == name {
+			writeJSON(rw, http.StatusOK, s)
+			return
+		}
+	}
+	writeError(rw, http.StatusNotFound, "unknown queue: "+name)
+}
+
+func writeError(rw http.ResponseWriter, status int, msg string) {
+	writeJSON(rw, status, map[string]interface{}{"error": msg})
+}
+
+func writeJSON(rw http.ResponseWriter, status int, body interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(body)
+}
@@ -0,0 +1,181 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"base-go-app/internal/queue"
+	"base-go-app/internal/tasks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const token = "s3cret"
+
+// fakeWorker is a single queue named "q".
+type fakeWorker struct {
+	status  queue.QueueStatus
+	running []queue.InFlightTask
+	drained bool
+}
+
+func (f *fakeWorker) Queues() []queue.QueueStatus    { return []queue.QueueStatus{f.status} }
+func (f *fakeWorker) InFlight() []queue.InFlightTask { return f.running }
+
+func (f *fakeWorker) Drain() {
+	f.drained = true
+	f.status.Paused = true
+}
+
+func (f *fakeWorker) ResumeAll() {
+	f.drained = false
+	f.status.Paused = false
+}
+
+func (f *fakeWorker) Pause(name string) error {
+	if name != f.status.Name {
+		return fmt.Errorf("%w: %s", queue.ErrUnknownQueue, name)
+	}
+	f.status.Paused = true
+	return nil
+}
+
+func (f *fakeWorker) Resume(name string) error {
+	if name != f.status.Name {
+		return fmt.Errorf("%w: %s", queue.ErrUnknownQueue, name)
+	}
+	f.status.Paused = false
+	return nil
+}
+
+func (f *fakeWorker) SetConcurrency(name string, n int) error {
+	if name != f.status.Name {
+		return fmt.Errorf("%w: %s", queue.ErrUnknownQueue, name)
+	}
+	if n < 1 {
+		return queue.ErrInvalidConcurrency
+	}
+	f.status.Concurrency = n
+	return nil
+}
+
+type noopHandler struct{}
+
+func (noopHandler) Handle(context.Context, json.RawMessage) error { return nil }
+
+func call(t *testing.T, h http.Handler, method, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var out map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	return rec.Code, out
+}
+
+func TestAuthentication(t *testing.T) {
+	h := Handler(token, &fakeWorker{})
+	for _, auth := range []string{"", "Bearer wrong", "Basic " + token, token} {
+		req := httptest.NewRequest("GET", "/admin/tasks", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, auth)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+	}
+
+	// An empty token never authenticates
+	req := httptest.NewRequest("GET", "/admin/tasks", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	Handler("", &fakeWorker{}).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestListing(t *testing.T) {
+	tasks.ClearRegistry()
+	defer tasks.ClearRegistry()
+	tasks.RegisterTask("logger", noopHandler{})
+
+	w := &fakeWorker{
+		status:  queue.QueueStatus{Name: "q", Concurrency: 2},
+		running: []queue.InFlightTask{{Queue: "q", TaskID: "1", Task: "logger", Started: time.Now().Add(-time.Minute)}},
+	}
+	h := Handler(token, w)
+
+	code, body := call(t, h, "GET", "/admin/tasks", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []interface{}{"logger"}, body["tasks"])
+
+	code, body = call(t, h, "GET", "/admin/queues", "")
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, body["queues"], 1)
+	assert.Equal(t, "q", body["queues"].([]interface{})[0].(map[string]interface{})["name"])
+
+	code, body = call(t, h, "GET", "/admin/inflight", "")
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, body["tasks"], 1)
+	running := body["tasks"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "logger", running["task"])
+	assert.Equal(t, "1", running["task_id"])
+	assert.InDelta(t, 60, running["age_seconds"], 5)
+
+	w.running = nil
+	_, body = call(t, h, "GET", "/admin/inflight", "")
+	assert.Equal(t, []interface{}{}, body["tasks"])
+}
+
+func TestQueueControls(t *testing.T) {
+	w := &fakeWorker{status: queue.QueueStatus{Name: "q", Concurrency: 2}}
+	h := Handler(token, w)
+
+	code, body := call(t, h, "POST", "/admin/queues/q/pause", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["paused"])
+
+	code, body = call(t, h, "POST", "/admin/queues/q/resume", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, body["paused"])
+
+	code, _ = call(t, h, "POST", "/admin/queues/other/pause", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, body = call(t, h, "PUT", "/admin/queues/q/concurrency", `{"concurrency": 5}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(5), body["concurrency"])
+
+	code, _ = call(t, h, "PUT", "/admin/queues/q/concurrency", `{"concurrency": 0}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = call(t, h, "PUT", "/admin/queues/q/concurrency", `five`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestDrain(t *testing.T) {
+	w := &fakeWorker{
+		status:  queue.QueueStatus{Name: "q"},
+		running: []queue.InFlightTask{{Queue: "q", TaskID: "1", Started: time.Now()}},
+	}
+	h := Handler(token, w)
+	code, body := call(t, h, "POST", "/admin/drain", "")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, "draining", body["status"])
+	assert.Equal(t, float64(1), body["in_flight"])
+	assert.True(t, w.drained)
+	assert.True(t, w.status.Paused)
+
+	code, body = call(t, h, "POST", "/admin/resume", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "consuming", body["status"])
+	assert.False(t, w.drained)
+	assert.False(t, w.status.Paused)
+}
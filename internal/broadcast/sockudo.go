@@ -8,6 +8,9 @@ import (
 	"net/http"
 	"os"
 	"time"
+
+	"base-go-app/internal/logging"
+	"base-go-app/internal/tracing"
 )
 
 type SockudoBroadcaster struct {
@@ -28,6 +31,7 @@ func NewSockudoBroadcaster() *SockudoBroadcaster {
 
 func (s *SockudoBroadcaster) Broadcast(ctx context.Context, channel, event string, payload interface{}) error {
 	if s.BaseURL == "" {
+		logging.FromContext(ctx).Debug("Sockudo is not configured, skipping broadcast", "channel", channel)
 		return nil // Not configured
 	}
 
@@ -53,7 +57,7 @@ func (s *SockudoBroadcaster) Broadcast(ctx context.Context, channel, event strin
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.APIKey))
 	req.Header.Set("X-App-Key", s.APIKey) // Support both styles
 
-	resp, err := s.HTTPClient.Do(req)
+	resp, err := tracing.Do(s.HTTPClient, req)
 	if err != nil {
 		return err
 	}
@@ -63,5 +67,6 @@ func (s *SockudoBroadcaster) Broadcast(ctx context.Context, channel, event strin
 		return fmt.Errorf("sockudo broadcast failed with status: %d", resp.StatusCode)
 	}
 
+	logging.FromContext(ctx).Debug("Broadcast sent", "channel", channel, "event", event)
 	return nil
 }
@@ -1,13 +1,96 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"base-go-app/internal/logging"
+	"base-go-app/internal/tracing"
 
 	"github.com/joho/godotenv"
 )
 
+const (
+	DefaultQueueName         = "logger"
+	DefaultExchange          = "celery"
+	DefaultWorkerConcurrency = 10
+	DefaultTaskChannelBuffer = 100
+	// DefaultShutdownGraceSeconds is how long in-flight tasks may run after
+	// a shutdown signal.
+	DefaultShutdownGraceSeconds = 30
+	// DefaultHealthStallSeconds is how long a queue's workers may all be
+	// busy without finishing a task before the worker is reported dead.
+	DefaultHealthStallSeconds = 600
+
+	// MaxWorkerConcurrency bounds the worker pool of a queue.
+	MaxWorkerConcurrency = 1000
+	// DefaultAutoscaleIntervalSeconds is how often autoscaled worker
+	// pools are resized.
+	DefaultAutoscaleIntervalSeconds = 10
+
+	DefaultPublisherConfirmTimeoutSeconds = 5
+	DefaultPublisherConnections           = 1
+	DefaultPublisherChannels              = 8
+)
+
+// Result backends (RESULT_BACKEND).
+const (
+	ResultBackendDatabase = "database"
+	ResultBackendMemory   = "memory"
+
+	DefaultResultExpiresSeconds = 86400
+)
+
+// Idempotency stores (IDEMPOTENCY_STORE).
+const (
+	IdempotencyStoreDatabase = "database"
+	IdempotencyStoreMemory   = "memory"
+
+	DefaultIdempotencyTTLSeconds = 86400
+)
+
+// Workflow stores (WORKFLOW_STORE).
+const (
+	WorkflowStoreDatabase = "database"
+	WorkflowStoreMemory   = "memory"
+)
+
+// Rate limiters (RATE_LIMIT_STORE).
+const (
+	RateLimitStoreDatabase = "database"
+	RateLimitStoreMemory   = "memory"
+)
+
+// Scheduler locks (SCHEDULER_LOCK).
+const (
+	SchedulerLockDatabase = "database"
+
+	DefaultSchedulerLockTTLSeconds = 30
+)
+
+// Retry delay modes (RETRY_DELAY_MODE).
+const (
+	// RetryDelayTTL parks retries in per-delay TTL queues that dead-letter
+	// back to the work queue. Works on stock RabbitMQ.
+	RetryDelayTTL = "ttl"
+	// RetryDelayPlugin publishes retries to an x-delayed-message exchange
+	// and requires the rabbitmq_delayed_message_exchange plugin.
+	RetryDelayPlugin = "delayed_exchange"
+)
+
 type Config struct {
+	// LogLevel is debug, info (default), warn or error.
+	LogLevel string
+	// LogFormat is json (default) or text.
+	LogFormat string
+	// TracesExporter is otlp, or none (default) to only propagate trace
+	// context.
+	TracesExporter string
+
 	RabbitMQUser     string
 	RabbitMQPassword string
 	RabbitMQHost     string
@@ -19,6 +102,136 @@ type Config struct {
 	DBHost     string
 	DBPort     string
 	DBDatabase string
+
+	// WorkerConcurrency is the default worker pool size for each queue.
+	WorkerConcurrency int
+	// WorkerMinConcurrency and WorkerMaxConcurrency are the default pool
+	// bounds of each queue (see QueueConfig.PoolBounds).
+	WorkerMinConcurrency int
+	WorkerMaxConcurrency int
+	// WorkerAutoscale enables autoscaling for every queue.
+	WorkerAutoscale bool
+	// AutoscaleIntervalSeconds is how often autoscaled pools are resized.
+	AutoscaleIntervalSeconds int
+	// TaskChannelBuffer is the size of the in-memory buffer between the
+	// AMQP consumer and the worker pool of each queue.
+	TaskChannelBuffer int
+	// Queues lists the queues the worker subscribes to. When empty the
+	// legacy "logger" queue on the "celery" exchange is used.
+	Queues []QueueConfig
+	// ShutdownGraceSeconds is how long in-flight tasks may finish after a
+	// shutdown signal before they are canceled.
+	ShutdownGraceSeconds int
+	// HealthStallSeconds is how long every worker of a queue may be busy
+	// without a task finishing before /livez fails.
+	HealthStallSeconds int
+	// AdminToken is the bearer token of the admin API on the health
+	// server. The API is disabled when it is empty.
+	AdminToken string
+	// RetryDelayMode selects how delayed retries are implemented
+	// (RetryDelayTTL or RetryDelayPlugin).
+	RetryDelayMode string
+
+	// ResultBackend selects where task states and results are recorded
+	// ("database", "memory" or empty to disable).
+	ResultBackend string
+	// ResultExpiresSeconds is how long finished results are kept.
+	ResultExpiresSeconds int
+
+	// IdempotencyStore selects where idempotency keys are tracked
+	// ("database", "memory" or empty to disable).
+	IdempotencyStore string
+	// IdempotencyTTLSeconds is how long a completed key is remembered.
+	IdempotencyTTLSeconds int
+
+	// WorkflowStore selects where group and chord state is tracked
+	// ("database", "memory" or empty to disable groups).
+	WorkflowStore string
+
+	// RateLimitStore selects where the token buckets of rate-limited tasks
+	// are kept ("database" to share them across pods, or "memory", the
+	// default).
+	RateLimitStore string
+
+	// PublisherBufferSize is how many messages the publisher holds in
+	// memory while disconnected from RabbitMQ (0 disables buffering).
+	PublisherBufferSize int
+	// PublisherConfirmTimeoutSeconds is how long the publisher waits for
+	// the broker to confirm a message.
+	PublisherConfirmTimeoutSeconds int
+	// PublisherConnections and PublisherChannels size the publisher's
+	// connection and channel pool; PublisherChannels bounds how many
+	// messages are published concurrently.
+	PublisherConnections int
+	PublisherChannels    int
+
+	// ScheduleFile is the JSON schedule of the periodic task scheduler.
+	ScheduleFile string
+	// SchedulerLock selects how scheduler replicas elect the one that
+	// publishes ("database", or empty when a single replica runs).
+	SchedulerLock string
+	// SchedulerLockTTLSeconds is how long the leader's lock lasts without
+	// being renewed.
+	SchedulerLockTTLSeconds int
+}
+
+// QueueConfig describes a single queue binding consumed by the worker.
+// Zero values are filled in by Config.GetQueues.
+type QueueConfig struct {
+	Name        string `json:"name"`
+	Exchange    string `json:"exchange,omitempty"`
+	RoutingKey  string `json:"routing_key,omitempty"`
+	Prefetch    int    `json:"prefetch,omitempty"`
+	Concurrency int    `json:"concurrency,omitempty"`
+
+	// MinConcurrency and MaxConcurrency bound the worker pool when it is
+	// resized at runtime, through the admin API or by autoscaling, which
+	// Autoscale enables.
+	MinConcurrency int  `json:"min_concurrency,omitempty"`
+	MaxConcurrency int  `json:"max_concurrency,omitempty"`
+	Autoscale      bool `json:"autoscale,omitempty"`
+
+	// DeadLetterExchange and DeadLetterQueue receive messages that failed
+	// fatally or exhausted their retries. They default to "<exchange>.dlx"
+	// and "<name>.dlq"; set DisableDeadLetter to drop such messages instead.
+	DeadLetterExchange string `json:"dead_letter_exchange,omitempty"`
+	DeadLetterQueue    string `json:"dead_letter_queue,omitempty"`
+	DisableDeadLetter  bool   `json:"disable_dead_letter,omitempty"`
+}
+
+// PoolBounds returns the range the worker pool may be resized within:
+// MinConcurrency (default 1) to MaxConcurrency (default four times
+// Concurrency), at most MaxWorkerConcurrency.
+func (q QueueConfig) PoolBounds() (min, max int) {
+	min, max = q.MinConcurrency, q.MaxConcurrency
+	if min <= 0 {
+		min = 1
+	}
+	if max <= 0 {
+		max = q.Concurrency * 4
+	}
+	if max > MaxWorkerConcurrency {
+		max = MaxWorkerConcurrency
+	}
+	return min, max
+}
+
+// Arguments returns the arguments the queue is declared with. Every
+// declaration of a queue must use them: RabbitMQ refuses to redeclare a
+// queue with different arguments.
+func (q QueueConfig) Arguments() map[string]interface{} {
+	if !q.DeadLetterEnabled() {
+		return nil
+	}
+	return map[string]interface{}{
+		"x-dead-letter-exchange":    q.DeadLetterExchange,
+		"x-dead-letter-routing-key": q.Name,
+	}
+}
+
+// DeadLetterEnabled reports whether failed messages are routed to a DLQ.
+func (q QueueConfig) DeadLetterEnabled() bool {
+	return !q.DisableDeadLetter && q.DeadLetterExchange != "" && q.DeadLetterQueue != ""
 }
 
 func Load() (*Config, error) {
@@ -29,6 +242,11 @@ func Load() (*Config, error) {
 	}
 
 	cfg := &Config{
+		LogLevel:  os.Getenv("LOG_LEVEL"),
+		LogFormat: os.Getenv("LOG_FORMAT"),
+
+		TracesExporter: os.Getenv("OTEL_TRACES_EXPORTER"),
+
 		RabbitMQUser:     os.Getenv("RABBITMQ_USER"),
 		RabbitMQPassword: os.Getenv("RABBITMQ_PASSWORD"),
 		RabbitMQHost:     os.Getenv("RABBITMQ_HOST"),
@@ -40,11 +258,350 @@ func Load() (*Config, error) {
 		DBHost:     os.Getenv("DB_HOST"),
 		DBPort:     os.Getenv("DB_PORT"),
 		DBDatabase: os.Getenv("DB_DATABASE"),
+
+		WorkerConcurrency: envInt("WORKER_CONCURRENCY", DefaultWorkerConcurrency),
+		TaskChannelBuffer: envInt("TASK_CHANNEL_BUFFER", DefaultTaskChannelBuffer),
+		RetryDelayMode:    os.Getenv("RETRY_DELAY_MODE"),
+
+		WorkerMinConcurrency:     envInt("WORKER_MIN_CONCURRENCY", 0),
+		WorkerMaxConcurrency:     envInt("WORKER_MAX_CONCURRENCY", 0),
+		WorkerAutoscale:          os.Getenv("WORKER_AUTOSCALE") == "true",
+		AutoscaleIntervalSeconds: envInt("WORKER_AUTOSCALE_INTERVAL_SECONDS", DefaultAutoscaleIntervalSeconds),
+
+		ShutdownGraceSeconds: envInt("SHUTDOWN_GRACE_SECONDS", DefaultShutdownGraceSeconds),
+		HealthStallSeconds:   envInt("HEALTH_STALL_SECONDS", DefaultHealthStallSeconds),
+		AdminToken:           os.Getenv("ADMIN_TOKEN"),
+
+		ResultBackend:        os.Getenv("RESULT_BACKEND"),
+		ResultExpiresSeconds: envInt("RESULT_EXPIRES_SECONDS", DefaultResultExpiresSeconds),
+
+		IdempotencyStore:      os.Getenv("IDEMPOTENCY_STORE"),
+		IdempotencyTTLSeconds: envInt("IDEMPOTENCY_TTL_SECONDS", DefaultIdempotencyTTLSeconds),
+
+		WorkflowStore: os.Getenv("WORKFLOW_STORE"),
+
+		RateLimitStore: os.Getenv("RATE_LIMIT_STORE"),
+
+		PublisherBufferSize:            envInt("PUBLISHER_BUFFER_SIZE", 0),
+		PublisherConfirmTimeoutSeconds: envInt("PUBLISHER_CONFIRM_TIMEOUT_SECONDS", DefaultPublisherConfirmTimeoutSeconds),
+		PublisherConnections:           envInt("PUBLISHER_CONNECTIONS", DefaultPublisherConnections),
+		PublisherChannels:              envInt("PUBLISHER_CHANNELS", DefaultPublisherChannels),
+
+		ScheduleFile:            os.Getenv("SCHEDULE_FILE"),
+		SchedulerLock:           os.Getenv("SCHEDULER_LOCK"),
+		SchedulerLockTTLSeconds: envInt("SCHEDULER_LOCK_TTL_SECONDS", DefaultSchedulerLockTTLSeconds),
+	}
+
+	if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q", cfg.LogLevel)
+	}
+	switch cfg.LogFormat {
+	case "", logging.FormatJSON, logging.FormatText:
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q", cfg.LogFormat)
+	}
+
+	switch cfg.TracesExporter {
+	case "", tracing.ExporterNone, tracing.ExporterOTLP:
+	default:
+		return nil, fmt.Errorf("invalid OTEL_TRACES_EXPORTER %q", cfg.TracesExporter)
+	}
+
+	switch cfg.ResultBackend {
+	case "", ResultBackendDatabase, ResultBackendMemory:
+	default:
+		return nil, fmt.Errorf("invalid RESULT_BACKEND %q", cfg.ResultBackend)
+	}
+	switch cfg.IdempotencyStore {
+	case "", IdempotencyStoreDatabase, IdempotencyStoreMemory:
+	default:
+		return nil, fmt.Errorf("invalid IDEMPOTENCY_STORE %q", cfg.IdempotencyStore)
+	}
+
+	switch cfg.WorkflowStore {
+	case "", WorkflowStoreDatabase, WorkflowStoreMemory:
+	default:
+		return nil, fmt.Errorf("invalid WORKFLOW_STORE %q", cfg.WorkflowStore)
+	}
+	switch cfg.RateLimitStore {
+	case "", RateLimitStoreDatabase, RateLimitStoreMemory:
+	default:
+		return nil, fmt.Errorf("invalid RATE_LIMIT_STORE %q", cfg.RateLimitStore)
+	}
+	switch cfg.SchedulerLock {
+	case "", SchedulerLockDatabase:
+	default:
+		return nil, fmt.Errorf("invalid SCHEDULER_LOCK %q", cfg.SchedulerLock)
+	}
+
+	switch cfg.RetryDelayMode {
+	case "", RetryDelayTTL, RetryDelayPlugin:
+	default:
+		return nil, fmt.Errorf("invalid RETRY_DELAY_MODE %q", cfg.RetryDelayMode)
+	}
+
+	queues, err := loadQueues()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Queues = queues
+	for _, q := range cfg.GetQueues() {
+		if min, max := q.PoolBounds(); min > max {
+			return nil, fmt.Errorf("queue %s: min concurrency %d exceeds max concurrency %d", q.Name, min, max)
+		}
 	}
 
 	return cfg, nil
 }
 
+// loadQueues reads the queue subscriptions from WORKER_QUEUES_FILE (a JSON
+// array of QueueConfig), WORKER_QUEUES (see ParseQueueSpec) or the single
+// RABBITMQ_QUEUE name, in that order of precedence.
+func loadQueues() ([]QueueConfig, error) {
+	if path := os.Getenv("WORKER_QUEUES_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read WORKER_QUEUES_FILE: %w", err)
+		}
+		var queues []QueueConfig
+		if err := json.Unmarshal(data, &queues); err != nil {
+			return nil, fmt.Errorf("failed to parse WORKER_QUEUES_FILE: %w", err)
+		}
+		for i, q := range queues {
+			if q.Name == "" {
+				return nil, fmt.Errorf("WORKER_QUEUES_FILE: queue %d has no name", i)
+			}
+		}
+		return queues, nil
+	}
+	if spec := os.Getenv("WORKER_QUEUES"); spec != "" {
+		queues, err := ParseQueueSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse WORKER_QUEUES: %w", err)
+		}
+		return queues, nil
+	}
+	if name := os.Getenv("RABBITMQ_QUEUE"); name != "" {
+		return []QueueConfig{{Name: name}}, nil
+	}
+	return nil, nil
+}
+
+// ParseQueueSpec parses a comma-separated list of queue definitions. Each
+// definition is a queue name optionally followed by semicolon-separated
+// key=value options, e.g.
+//
+//	go.logger;concurrency=10,go.email;exchange=celery;routing_key=email;prefetch=4
+//
+// Supported keys are exchange, routing_key, concurrency, prefetch,
+// min_concurrency, max_concurrency, autoscale (true/false),
+// dead_letter_exchange, dead_letter_queue and dead_letter (true/false).
+func ParseQueueSpec(spec string) ([]QueueConfig, error) {
+	var queues []QueueConfig
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ";")
+		q := QueueConfig{Name: strings.TrimSpace(parts[0])}
+		if q.Name == "" {
+			return nil, fmt.Errorf("queue definition %q has no name", entry)
+		}
+		for _, opt := range parts[1:] {
+			key, value, ok := strings.Cut(opt, "=")
+			if !ok {
+				return nil, fmt.Errorf("queue %s: invalid option %q", q.Name, opt)
+			}
+			key = strings.TrimSpace(key)
+			value = strings.TrimSpace(value)
+			switch key {
+			case "exchange":
+				q.Exchange = value
+			case "routing_key":
+				q.RoutingKey = value
+			case "dead_letter_exchange":
+				q.DeadLetterExchange = value
+			case "dead_letter_queue":
+				q.DeadLetterQueue = value
+			case "dead_letter":
+				enabled, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("queue %s: dead_letter must be true or false", q.Name)
+				}
+				q.DisableDeadLetter = !enabled
+			case "autoscale":
+				enabled, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("queue %s: autoscale must be true or false", q.Name)
+				}
+				q.Autoscale = enabled
+			case "concurrency", "prefetch", "min_concurrency", "max_concurrency":
+				n, err := strconv.Atoi(value)
+				if err != nil || n <= 0 {
+					return nil, fmt.Errorf("queue %s: %s must be a positive integer", q.Name, key)
+				}
+				switch key {
+				case "concurrency":
+					q.Concurrency = n
+				case "prefetch":
+					q.Prefetch = n
+				case "min_concurrency":
+					q.MinConcurrency = n
+				default:
+					q.MaxConcurrency = n
+				}
+			default:
+				return nil, fmt.Errorf("queue %s: unknown option %q", q.Name, key)
+			}
+		}
+		queues = append(queues, q)
+	}
+	if len(queues) == 0 {
+		return nil, fmt.Errorf("no queues defined")
+	}
+	return queues, nil
+}
+
+// GetQueues returns the configured queues with defaults applied. When no
+// queues are configured it returns the legacy "logger" binding.
+func (c *Config) GetQueues() []QueueConfig {
+	queues := c.Queues
+	if len(queues) == 0 {
+		queues = []QueueConfig{{Name: DefaultQueueName}}
+	}
+
+	out := make([]QueueConfig, len(queues))
+	for i, q := range queues {
+		out[i] = c.withDefaults(q)
+	}
+	return out
+}
+
+// ConsumedQueue returns the queue named name with defaults applied, and
+// whether the worker consumes it. Publishers declare the worker's queues
+// with its arguments, so that whichever side creates them first, the other
+// can still declare them.
+func (c *Config) ConsumedQueue(name string) (QueueConfig, bool) {
+	for _, q := range c.GetQueues() {
+		if q.Name == name {
+			return q, true
+		}
+	}
+	return QueueConfig{}, false
+}
+
+// withDefaults fills in the zero values of q.
+func (c *Config) withDefaults(q QueueConfig) QueueConfig {
+	if q.Exchange == "" {
+		q.Exchange = DefaultExchange
+	}
+	if q.RoutingKey == "" {
+		q.RoutingKey = q.Name
+	}
+	if q.MinConcurrency <= 0 {
+		q.MinConcurrency = c.WorkerMinConcurrency
+	}
+	if q.MaxConcurrency <= 0 {
+		q.MaxConcurrency = c.WorkerMaxConcurrency
+	}
+	q.Autoscale = q.Autoscale || c.WorkerAutoscale
+	if q.Concurrency <= 0 {
+		q.Concurrency = c.WorkerConcurrency
+	}
+	if q.Concurrency <= 0 {
+		q.Concurrency = DefaultWorkerConcurrency
+	}
+	// Start within the pool bounds, when they are set
+	if q.MaxConcurrency > 0 && q.Concurrency > q.MaxConcurrency {
+		q.Concurrency = q.MaxConcurrency
+	}
+	if q.Concurrency < q.MinConcurrency {
+		q.Concurrency = q.MinConcurrency
+	}
+	if q.Prefetch <= 0 {
+		q.Prefetch = q.Concurrency * 2
+	}
+	if !q.DisableDeadLetter {
+		if q.DeadLetterExchange == "" {
+			q.DeadLetterExchange = q.Exchange + ".dlx"
+		}
+		if q.DeadLetterQueue == "" {
+			q.DeadLetterQueue = q.Name + ".dlq"
+		}
+	}
+	return q
+}
+
+// GetRetryDelayMode returns the retry delay mode, defaulting to RetryDelayTTL.
+func (c *Config) GetRetryDelayMode() string {
+	if c.RetryDelayMode == "" {
+		return RetryDelayTTL
+	}
+	return c.RetryDelayMode
+}
+
+// GetTaskChannelBuffer returns the per-queue task buffer size.
+func (c *Config) GetTaskChannelBuffer() int {
+	if c.TaskChannelBuffer <= 0 {
+		return DefaultTaskChannelBuffer
+	}
+	return c.TaskChannelBuffer
+}
+
+// GetShutdownGrace returns how long in-flight tasks may finish on shutdown.
+func (c *Config) GetShutdownGrace() time.Duration {
+	if c.ShutdownGraceSeconds <= 0 {
+		return DefaultShutdownGraceSeconds * time.Second
+	}
+	return time.Duration(c.ShutdownGraceSeconds) * time.Second
+}
+
+// GetAutoscaleInterval returns how often autoscaled pools are resized.
+func (c *Config) GetAutoscaleInterval() time.Duration {
+	if c.AutoscaleIntervalSeconds <= 0 {
+		return DefaultAutoscaleIntervalSeconds * time.Second
+	}
+	return time.Duration(c.AutoscaleIntervalSeconds) * time.Second
+}
+
+// GetHealthStall returns how long a stuck worker pool is tolerated.
+func (c *Config) GetHealthStall() time.Duration {
+	if c.HealthStallSeconds <= 0 {
+		return DefaultHealthStallSeconds * time.Second
+	}
+	return time.Duration(c.HealthStallSeconds) * time.Second
+}
+
+// UsesDatabase reports whether a result, idempotency or workflow store is
+// kept in the database, which the worker then cannot do without.
+func (c *Config) UsesDatabase() bool {
+	return c.ResultBackend == ResultBackendDatabase ||
+		c.IdempotencyStore == IdempotencyStoreDatabase ||
+		c.WorkflowStore == WorkflowStoreDatabase
+}
+
+// GetPublisherConnections returns the number of publisher connections.
+func (c *Config) GetPublisherConnections() int {
+	if c.PublisherConnections <= 0 {
+		return DefaultPublisherConnections
+	}
+	return c.PublisherConnections
+}
+
+// GetPublisherChannels returns the size of the publisher's channel pool,
+// which is at least one channel per connection.
+func (c *Config) GetPublisherChannels() int {
+	n := c.PublisherChannels
+	if n <= 0 {
+		n = DefaultPublisherChannels
+	}
+	if conns := c.GetPublisherConnections(); n < conns {
+		n = conns
+	}
+	return n
+}
+
 func (c *Config) GetRabbitMQURL() string {
 	return fmt.Sprintf("amqp://%s:%s@%s:%s/%s",
 		c.RabbitMQUser,
@@ -71,3 +628,14 @@ func (c *Config) GetDSN() string {
 		port,
 	)
 }
+
+// envInt reads a positive integer from the environment, returning def when
+// the variable is unset or invalid.
+func envInt(key string, def int) int {
+	if s := os.Getenv(key); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 {
+			return v
+		}
+	}
+	return def
+}
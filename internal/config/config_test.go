@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -75,3 +77,291 @@ func TestGetDSNDefaultPort(t *testing.T) {
 	expected := "host=dbhost user=dbuser password=dbpass dbname=dbname port=5432 sslmode=disable TimeZone=UTC"
 	assert.Equal(t, expected, cfg.GetDSN())
 }
+
+func TestParseQueueSpec(t *testing.T) {
+	queues, err := ParseQueueSpec("go.logger;concurrency=10;dead_letter=false, go.email;exchange=tasks;routing_key=email;prefetch=4;dead_letter_queue=email.failed")
+	assert.NoError(t, err)
+	assert.Equal(t, []QueueConfig{
+		{Name: "go.logger", Concurrency: 10, DisableDeadLetter: true},
+		{Name: "go.email", Exchange: "tasks", RoutingKey: "email", Prefetch: 4, DeadLetterQueue: "email.failed"},
+	}, queues)
+}
+
+func TestParseQueueSpecErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		";concurrency=1",
+		"q;concurrency=0",
+		"q;prefetch=abc",
+		"q;unknown=1",
+		"q;missing",
+		"q;dead_letter=maybe",
+	} {
+		_, err := ParseQueueSpec(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestGetQueuesDefaults(t *testing.T) {
+	cfg := &Config{}
+	assert.Equal(t, []QueueConfig{
+		{Name: "logger", Exchange: "celery", RoutingKey: "logger", Concurrency: 10, Prefetch: 20,
+			DeadLetterExchange: "celery.dlx", DeadLetterQueue: "logger.dlq"},
+	}, cfg.GetQueues())
+	assert.Equal(t, 100, cfg.GetTaskChannelBuffer())
+
+	cfg = &Config{
+		WorkerConcurrency: 4,
+		Queues: []QueueConfig{
+			{Name: "go.logger"},
+			{Name: "go.email", Concurrency: 1, Prefetch: 1, DisableDeadLetter: true},
+		},
+	}
+	queues := cfg.GetQueues()
+	assert.Equal(t, QueueConfig{Name: "go.logger", Exchange: "celery", RoutingKey: "go.logger", Concurrency: 4, Prefetch: 8,
+		DeadLetterExchange: "celery.dlx", DeadLetterQueue: "go.logger.dlq"}, queues[0])
+	assert.True(t, queues[0].DeadLetterEnabled())
+	assert.Equal(t, QueueConfig{Name: "go.email", Exchange: "celery", RoutingKey: "go.email", Concurrency: 1, Prefetch: 1,
+		DisableDeadLetter: true}, queues[1])
+	assert.False(t, queues[1].DeadLetterEnabled())
+}
+
+func TestConsumedQueue(t *testing.T) {
+	cfg := &Config{Queues: []QueueConfig{{Name: "go.email", Exchange: "tasks", DeadLetterExchange: "mail.dlx"}}}
+
+	q, ok := cfg.ConsumedQueue("go.email")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"x-dead-letter-exchange": "mail.dlx", "x-dead-letter-routing-key": "go.email"}, q.Arguments())
+
+	_, ok = cfg.ConsumedQueue("go.other")
+	assert.False(t, ok)
+
+	cfg.Queues[0].DisableDeadLetter = true
+	q, _ = cfg.ConsumedQueue("go.email")
+	assert.Nil(t, q.Arguments())
+}
+
+func TestLoadQueuesFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queues.json")
+	err := os.WriteFile(path, []byte(`[{"name":"go.logger","concurrency":3},{"name":"go.email","routing_key":"email"}]`), 0o600)
+	assert.NoError(t, err)
+
+	t.Setenv("WORKER_QUEUES_FILE", path)
+	t.Setenv("WORKER_QUEUES", "ignored")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []QueueConfig{
+		{Name: "go.logger", Concurrency: 3},
+		{Name: "go.email", RoutingKey: "email"},
+	}, cfg.Queues)
+}
+
+func TestLoadQueuesFromEnv(t *testing.T) {
+	t.Setenv("WORKER_QUEUES_FILE", "")
+	t.Setenv("WORKER_QUEUES", "go.logger,go.email;concurrency=2")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Queues, 2)
+	assert.Equal(t, 2, cfg.Queues[1].Concurrency)
+
+	t.Setenv("WORKER_QUEUES", "go.logger;bogus")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+func TestRetryDelayMode(t *testing.T) {
+	assert.Equal(t, RetryDelayTTL, (&Config{}).GetRetryDelayMode())
+
+	t.Setenv("RETRY_DELAY_MODE", RetryDelayPlugin)
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, RetryDelayPlugin, cfg.GetRetryDelayMode())
+
+	t.Setenv("RETRY_DELAY_MODE", "sometimes")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+func TestResultBackend(t *testing.T) {
+	t.Setenv("RESULT_BACKEND", "database")
+	t.Setenv("RESULT_EXPIRES_SECONDS", "60")
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, ResultBackendDatabase, cfg.ResultBackend)
+	assert.Equal(t, 60, cfg.ResultExpiresSeconds)
+
+	t.Setenv("RESULT_BACKEND", "redis")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_STORE", "memory")
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, IdempotencyStoreMemory, cfg.IdempotencyStore)
+	assert.Equal(t, DefaultIdempotencyTTLSeconds, cfg.IdempotencyTTLSeconds)
+
+	t.Setenv("IDEMPOTENCY_STORE", "redis")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+func TestPublisherSettings(t *testing.T) {
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cfg.PublisherBufferSize)
+	assert.Equal(t, DefaultPublisherConfirmTimeoutSeconds, cfg.PublisherConfirmTimeoutSeconds)
+	assert.Equal(t, DefaultPublisherConnections, cfg.GetPublisherConnections())
+	assert.Equal(t, DefaultPublisherChannels, cfg.GetPublisherChannels())
+
+	t.Setenv("PUBLISHER_BUFFER_SIZE", "500")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 500, cfg.PublisherBufferSize)
+}
+
+func TestGetPublisherPool(t *testing.T) {
+	cfg := &Config{}
+	assert.Equal(t, 1, cfg.GetPublisherConnections())
+	assert.Equal(t, DefaultPublisherChannels, cfg.GetPublisherChannels())
+
+	// Every connection gets at least one channel
+	cfg = &Config{PublisherConnections: 4, PublisherChannels: 2}
+	assert.Equal(t, 4, cfg.GetPublisherConnections())
+	assert.Equal(t, 4, cfg.GetPublisherChannels())
+}
+
+func TestSchedulerSettings(t *testing.T) {
+	t.Setenv("SCHEDULE_FILE", "/etc/worker/schedule.json")
+	t.Setenv("SCHEDULER_LOCK", "database")
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/worker/schedule.json", cfg.ScheduleFile)
+	assert.Equal(t, SchedulerLockDatabase, cfg.SchedulerLock)
+	assert.Equal(t, DefaultSchedulerLockTTLSeconds, cfg.SchedulerLockTTLSeconds)
+
+	t.Setenv("SCHEDULER_LOCK", "redis")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+func TestWorkflowStore(t *testing.T) {
+	t.Setenv("WORKFLOW_STORE", "database")
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, WorkflowStoreDatabase, cfg.WorkflowStore)
+
+	t.Setenv("WORKFLOW_STORE", "redis")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+func TestRateLimitStore(t *testing.T) {
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Empty(t, cfg.RateLimitStore)
+
+	t.Setenv("RATE_LIMIT_STORE", "database")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, RateLimitStoreDatabase, cfg.RateLimitStore)
+
+	t.Setenv("RATE_LIMIT_STORE", "redis")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+func TestShutdownGrace(t *testing.T) {
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultShutdownGraceSeconds*time.Second, cfg.GetShutdownGrace())
+
+	t.Setenv("SHUTDOWN_GRACE_SECONDS", "90")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, cfg.GetShutdownGrace())
+
+	assert.Equal(t, DefaultShutdownGraceSeconds*time.Second, (&Config{}).GetShutdownGrace())
+}
+
+func TestLogSettings(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("LOG_FORMAT", "text")
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "debug", cfg.LogLevel)
+	assert.Equal(t, "text", cfg.LogFormat)
+
+	t.Setenv("LOG_LEVEL", "verbose")
+	_, err = Load()
+	assert.Error(t, err)
+
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("LOG_FORMAT", "xml")
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+func TestHealthSettings(t *testing.T) {
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultHealthStallSeconds*time.Second, cfg.GetHealthStall())
+	assert.False(t, cfg.UsesDatabase())
+	assert.Empty(t, cfg.AdminToken)
+
+	t.Setenv("HEALTH_STALL_SECONDS", "120")
+	t.Setenv("IDEMPOTENCY_STORE", "database")
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, cfg.GetHealthStall())
+	assert.True(t, cfg.UsesDatabase())
+	assert.Equal(t, "s3cret", cfg.AdminToken)
+}
+
+func TestPoolBounds(t *testing.T) {
+	queues, err := ParseQueueSpec("go.logger;concurrency=4;min_concurrency=2;max_concurrency=16;autoscale=true")
+	assert.NoError(t, err)
+	assert.Equal(t, QueueConfig{Name: "go.logger", Concurrency: 4, MinConcurrency: 2, MaxConcurrency: 16, Autoscale: true}, queues[0])
+	_, err = ParseQueueSpec("q;autoscale=sometimes")
+	assert.Error(t, err)
+	_, err = ParseQueueSpec("q;max_concurrency=0")
+	assert.Error(t, err)
+
+	// Defaults: 1 to four times the concurrency, capped
+	min, max := (&Config{}).GetQueues()[0].PoolBounds()
+	assert.Equal(t, 1, min)
+	assert.Equal(t, 40, max)
+	min, max = (&Config{WorkerConcurrency: 500}).GetQueues()[0].PoolBounds()
+	assert.Equal(t, 1, min)
+	assert.Equal(t, MaxWorkerConcurrency, max)
+
+	// Worker-wide bounds apply to every queue and clamp the concurrency
+	cfg := &Config{WorkerMinConcurrency: 2, WorkerMaxConcurrency: 6, WorkerAutoscale: true,
+		Queues: []QueueConfig{{Name: "a"}, {Name: "b", Concurrency: 1, MaxConcurrency: 3}}}
+	queues = cfg.GetQueues()
+	assert.Equal(t, 6, queues[0].Concurrency)
+	assert.True(t, queues[0].Autoscale)
+	min, max = queues[1].PoolBounds()
+	assert.Equal(t, 2, min)
+	assert.Equal(t, 3, max)
+	assert.Equal(t, 2, queues[1].Concurrency)
+
+	assert.Equal(t, DefaultAutoscaleIntervalSeconds*time.Second, (&Config{}).GetAutoscaleInterval())
+
+	t.Setenv("WORKER_AUTOSCALE", "true")
+	t.Setenv("WORKER_AUTOSCALE_INTERVAL_SECONDS", "30")
+	t.Setenv("WORKER_MAX_CONCURRENCY", "20")
+	cfg, err = Load()
+	assert.NoError(t, err)
+	assert.True(t, cfg.WorkerAutoscale)
+	assert.Equal(t, 30*time.Second, cfg.GetAutoscaleInterval())
+	assert.Equal(t, 20, cfg.WorkerMaxConcurrency)
+
+	t.Setenv("WORKER_MIN_CONCURRENCY", "30")
+	_, err = Load()
+	assert.Error(t, err)
+}
@@ -2,11 +2,12 @@ package database
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"sync/atomic"
 	"time"
 
 	"base-go-app/internal/config"
+	"base-go-app/internal/logging"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -25,11 +26,11 @@ func Connect(cfg *config.Config) error {
 	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err == nil {
 		atomic.StoreInt32(&dbConnected, 1)
-		log.Println("Connected to database")
+		slog.Info("Connected to database")
 		return nil
 	}
 
-	log.Printf("Initial DB connection failed: %v. Will retry in background...", err)
+	slog.Warn("Initial DB connection failed, will retry in background", logging.Err(err))
 
 	// Start background reconnect loop
 	go func() {
@@ -37,15 +38,15 @@ func Connect(cfg *config.Config) error {
 		for {
 			// If we've been asked to stop the process, don't continue reconnecting
 			// (this package has a Close method which callers should use on shutdown)
-			log.Printf("Attempting DB reconnect...")
+			slog.Debug("Attempting DB reconnect")
 			conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 			if err == nil {
 				DB = conn
 				atomic.StoreInt32(&dbConnected, 1)
-				log.Println("Reconnected to database")
+				slog.Info("Reconnected to database")
 				return
 			}
-			log.Printf("DB reconnect failed: %v", err)
+			slog.Warn("DB reconnect failed", logging.Err(err), "retry_in", delay)
 			// exponential backoff with cap
 			select {
 			case <-time.After(delay):
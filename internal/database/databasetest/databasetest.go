@@ -0,0 +1,31 @@
+// Package databasetest provides an in-memory database for tests of the
+// stores built on the shared connection.
+package databasetest
+
+import (
+	"testing"
+
+	"base-go-app/internal/database"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Use connects the database package to an empty in-memory SQLite database
+// for the rest of t, and returns it.
+func Use(t testing.TB) *gorm.DB {
+	t.Helper()
+	// A shared cache lets concurrent connections see the same database
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	database.SetDBForTests(db)
+	t.Cleanup(database.ClearDBForTests)
+	return db
+}
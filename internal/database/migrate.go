@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotConnected is returned while the database is not connected.
+var ErrNotConnected = errors.New("database not connected")
+
+var (
+	migrateMu sync.Mutex
+	// migratedDB is the connection the models in migrated were migrated on
+	migratedDB *gorm.DB
+	migrated   = map[reflect.Type]bool{}
+)
+
+// Migrated returns the connected database for ctx, migrating the schema of
+// models the first time each is used. Stores that create their tables on
+// first use call it before every query.
+func Migrated(ctx context.Context, models ...interface{}) (*gorm.DB, error) {
+	db := DB
+	if !Connected() || db == nil {
+		return nil, ErrNotConnected
+	}
+
+	migrateMu.Lock()
+	defer migrateMu.Unlock()
+	if migratedDB != db {
+		// A new connection may point to another database
+		migratedDB = db
+		migrated = map[reflect.Type]bool{}
+	}
+	for _, m := range models {
+		t := reflect.TypeOf(m)
+		if migrated[t] {
+			continue
+		}
+		if err := db.WithContext(ctx).AutoMigrate(m); err != nil {
+			return nil, fmt.Errorf("failed to migrate %s: %w", tableName(db, m), err)
+		}
+		migrated[t] = true
+	}
+	return db.WithContext(ctx), nil
+}
+
+// tableName returns the table of model, or its type when it cannot be parsed.
+func tableName(db *gorm.DB, model interface{}) string {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return fmt.Sprintf("%T", model)
+	}
+	return stmt.Schema.Table
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type widget struct {
+	ID   uint
+	Name string
+}
+
+func TestMigrated(t *testing.T) {
+	ClearDBForTests()
+	ctx := context.Background()
+	if _, err := Migrated(ctx, &widget{}); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	}
+
+	// Every new connection is migrated again
+	for i := 0; i < 2; i++ {
+		sqliteDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatalf("failed to open sqlite: %v", err)
+		}
+		SetDBForTests(sqliteDB)
+		db, err := Migrated(ctx, &widget{})
+		if err != nil {
+			t.Fatalf("Migrated returned error: %v", err)
+		}
+		if err := db.Create(&widget{Name: "w"}).Error; err != nil {
+			t.Fatalf("expected the widgets table, got %v", err)
+		}
+	}
+	ClearDBForTests()
+}
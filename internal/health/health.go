@@ -0,0 +1,248 @@
+// Package health runs the worker's liveness and readiness checks and serves
+// them on /livez, /readyz and /healthz.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Overall statuses of a Report.
+const (
+	StatusOK = "ok"
+	// StatusDegraded means a non-critical check failed.
+	StatusDegraded = "degraded"
+	// StatusUnavailable means a critical check failed; the probe fails.
+	StatusUnavailable = "unavailable"
+)
+
+// DefaultTimeout bounds how long a single check may run.
+const DefaultTimeout = 2 * time.Second
+
+// Check is a named probe. Run returns nil when healthy.
+type Check struct {
+	Name string
+	// Critical checks fail the probe they belong to; the others only
+	// degrade the reported status.
+	Critical bool
+	// Interval, when set, reuses a result younger than that instead of
+	// running the check again, for checks that call other services.
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Result is the latest outcome of a check.
+type Result struct {
+	OK       bool   `json:"ok"`
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+	// LastSuccess is when the check last passed (nil if it never did).
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastChecked time.Time  `json:"last_checked"`
+	DurationMS  float64    `json:"duration_ms"`
+}
+
+// Report is the outcome of a set of checks.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// OK reports whether every critical check passed.
+func (r Report) OK() bool {
+	return r.Status != StatusUnavailable
+}
+
+// Checker holds the liveness and readiness checks of the process and
+// remembers when each last succeeded.
+type Checker struct {
+	// Timeout bounds each check; zero means DefaultTimeout.
+	Timeout time.Duration
+
+	mu        sync.Mutex
+	liveness  []*state
+	readiness []*state
+}
+
+// state is a check with its latest result.
+type state struct {
+	Check
+
+	mu     sync.Mutex
+	result Result
+}
+
+// NewChecker creates a checker without checks.
+func NewChecker() *Checker {
+	return &Checker{Timeout: DefaultTimeout}
+}
+
+// AddLiveness adds a check telling whether the process must be restarted.
+func (c *Checker) AddLiveness(check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liveness = append(c.liveness, &state{Check: check})
+}
+
+// AddReadiness adds a check telling whether the process can do its work.
+func (c *Checker) AddReadiness(check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readiness = append(c.readiness, &state{Check: check})
+}
+
+// Live runs the liveness checks.
+func (c *Checker) Live(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := append([]*state(nil), c.liveness...)
+	c.mu.Unlock()
+	return c.run(ctx, checks)
+}
+
+// Ready runs the readiness checks.
+func (c *Checker) Ready(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := append([]*state(nil), c.readiness...)
+	c.mu.Unlock()
+	return c.run(ctx, checks)
+}
+
+// All runs every check.
+func (c *Checker) All(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := append(append([]*state(nil), c.liveness...), c.readiness...)
+	c.mu.Unlock()
+	return c.run(ctx, checks)
+}
+
+// run runs checks concurrently and summarizes them.
+func (c *Checker) run(ctx context.Context, checks []*state) Report {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range checks {
+		wg.Add(1)
+		go func(s *state) {
+			defer wg.Done()
+			s.run(ctx, timeout)
+		}(s)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	for _, s := range checks {
+		s.mu.Lock()
+		res := s.result
+		s.mu.Unlock()
+		report.Checks[s.Name] = res
+		switch {
+		case res.OK:
+		case s.Critical:
+			report.Status = StatusUnavailable
+		case report.Status == StatusOK:
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// run runs the check and records its result. A check that ignores the
+// timeout is abandoned and reported as timed out.
+func (s *state) run(ctx context.Context, timeout time.Duration) {
+	if s.Interval > 0 {
+		s.mu.Lock()
+		fresh := !s.result.LastChecked.IsZero() && time.Since(s.result.LastChecked) < s.Interval
+		s.mu.Unlock()
+		if fresh {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("check panicked: %v", r)
+			}
+		}()
+		done <- s.Run(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("check timed out: %w", ctx.Err())
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.result.OK = err == nil
+	s.result.Critical = s.Critical
+	s.result.Error = ""
+	if err != nil {
+		s.result.Error = err.Error()
+	} else {
+		s.result.LastSuccess = &now
+	}
+	s.result.LastChecked = now
+	s.result.DurationMS = float64(now.Sub(start).Microseconds()) / 1000
+}
+
+// Handler serves the report of probe as JSON: 200 when every critical
+// check passed, 503 otherwise. extra, when set, adds fields to the body.
+func Handler(probe func(context.Context) Report, extra func() map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := probe(r.Context())
+
+		body := map[string]interface{}{}
+		if extra != nil {
+			for k, v := range extra() {
+				body[k] = v
+			}
+		}
+		body["status"] = report.Status
+		body["checks"] = report.Checks
+
+		status := http.StatusOK
+		if !report.OK() {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+// Reachable returns a check that url answers HTTP requests. Any response
+// below 500 counts, since endpoints such as OAuth token URLs reject a bare
+// GET.
+func Reachable(client *http.Client, url string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,130 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ok(context.Context) error { return nil }
+
+func fail(context.Context) error { return errors.New("down") }
+
+func TestCheckerStatus(t *testing.T) {
+	c := NewChecker()
+	c.AddLiveness(Check{Name: "live", Critical: true, Run: ok})
+	c.AddReadiness(Check{Name: "broker", Critical: true, Run: ok})
+	c.AddReadiness(Check{Name: "optional", Run: fail})
+
+	live := c.Live(context.Background())
+	assert.Equal(t, StatusOK, live.Status)
+	assert.Len(t, live.Checks, 1)
+
+	ready := c.Ready(context.Background())
+	assert.Equal(t, StatusDegraded, ready.Status)
+	assert.True(t, ready.OK())
+	assert.Equal(t, "down", ready.Checks["optional"].Error)
+	assert.Nil(t, ready.Checks["optional"].LastSuccess)
+	assert.NotNil(t, ready.Checks["broker"].LastSuccess)
+
+	c.AddReadiness(Check{Name: "db", Critical: true, Run: fail})
+	all := c.All(context.Background())
+	assert.Equal(t, StatusUnavailable, all.Status)
+	assert.False(t, all.OK())
+	assert.Len(t, all.Checks, 4)
+}
+
+func TestLastSuccessKept(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	c := NewChecker()
+	c.AddReadiness(Check{Name: "flaky", Critical: true, Run: func(context.Context) error {
+		if healthy.Load() {
+			return nil
+		}
+		return errors.New("down")
+	}})
+
+	first := c.Ready(context.Background()).Checks["flaky"]
+	require.NotNil(t, first.LastSuccess)
+
+	healthy.Store(false)
+	second := c.Ready(context.Background()).Checks["flaky"]
+	assert.False(t, second.OK)
+	require.NotNil(t, second.LastSuccess)
+	assert.Equal(t, *first.LastSuccess, *second.LastSuccess)
+	assert.True(t, second.LastChecked.After(*first.LastSuccess) || second.LastChecked.Equal(*first.LastSuccess))
+}
+
+func TestCheckTimeoutAndPanic(t *testing.T) {
+	c := &Checker{Timeout: 20 * time.Millisecond}
+	block := make(chan struct{})
+	defer close(block)
+	c.AddReadiness(Check{Name: "slow", Critical: true, Run: func(context.Context) error {
+		<-block
+		return nil
+	}})
+	c.AddReadiness(Check{Name: "panics", Run: func(context.Context) error { panic("boom") }})
+
+	r := c.Ready(context.Background())
+	assert.Contains(t, r.Checks["slow"].Error, "timed out")
+	assert.Contains(t, r.Checks["panics"].Error, "panicked")
+	assert.Equal(t, StatusUnavailable, r.Status)
+}
+
+func TestCheckInterval(t *testing.T) {
+	var runs atomic.Int32
+	c := NewChecker()
+	c.AddReadiness(Check{Name: "remote", Interval: time.Hour, Run: func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}})
+	c.Ready(context.Background())
+	c.Ready(context.Background())
+	assert.Equal(t, int32(1), runs.Load())
+}
+
+func TestHandler(t *testing.T) {
+	c := NewChecker()
+	c.AddReadiness(Check{Name: "broker", Critical: true, Run: fail})
+	h := Handler(c.Ready, func() map[string]interface{} {
+		return map[string]interface{}{"in_flight": 3, "status": "ignored"}
+	})
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, "unavailable", body["status"])
+	assert.Equal(t, float64(3), body["in_flight"])
+	broker := body["checks"].(map[string]interface{})["broker"].(map[string]interface{})
+	assert.Equal(t, "down", broker["error"])
+}
+
+func TestReachable(t *testing.T) {
+	status := http.StatusMethodNotAllowed
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	check := Reachable(srv.Client(), srv.URL)
+	assert.NoError(t, check(context.Background()))
+
+	status = http.StatusBadGateway
+	assert.ErrorContains(t, check(context.Background()), "502")
+
+	srv.Close()
+	assert.Error(t, check(context.Background()))
+}
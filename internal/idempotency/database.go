@@ -0,0 +1,123 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"base-go-app/internal/database"
+	"base-go-app/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DBStore keeps idempotency keys in the task_idempotency_keys table so
+// duplicate tasks are detected across every worker pod. The table is created
+// on first use.
+type DBStore struct {
+	ttl time.Duration
+}
+
+// NewDBStore creates a database store remembering completed keys for ttl.
+func NewDBStore(ttl time.Duration) *DBStore {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &DBStore{ttl: ttl}
+}
+
+func (s *DBStore) Acquire(ctx context.Context, key, owner string, lease time.Duration) (State, error) {
+	db, err := database.Migrated(ctx, &models.IdempotencyKey{})
+	if err != nil {
+		return InProgress, err
+	}
+
+	now := time.Now()
+	row := models.IdempotencyKey{
+		Key:         key,
+		Owner:       owner,
+		Status:      statusInProgress,
+		LockedUntil: now.Add(lease),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	// Fast path: nobody has seen the key yet
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+	if res.Error != nil {
+		return InProgress, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return Acquired, nil
+	}
+
+	// Take over a stale claim or an expired completion. The conditions make
+	// the update atomic, so only one worker can win a race for the key.
+	res = db.Model(&models.IdempotencyKey{}).
+		Where("key = ?", key).
+		Where("(status = ? AND locked_until <= ?) OR (status = ? AND expires_at <= ?)",
+			statusInProgress, now, statusCompleted, now).
+		Updates(map[string]interface{}{
+			"owner":        owner,
+			"status":       statusInProgress,
+			"locked_until": now.Add(lease),
+			"expires_at":   nil,
+			"updated_at":   now,
+		})
+	if res.Error != nil {
+		return InProgress, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return Acquired, nil
+	}
+
+	var existing models.IdempotencyKey
+	if err := db.Where("key = ?", key).Take(&existing).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Released between our statements; report busy and let the
+			// caller try again later
+			return InProgress, nil
+		}
+		return InProgress, err
+	}
+	if existing.Status == statusCompleted {
+		return Completed, nil
+	}
+	return InProgress, nil
+}
+
+func (s *DBStore) Complete(ctx context.Context, key, owner string) error {
+	db, err := database.Migrated(ctx, &models.IdempotencyKey{})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return db.Model(&models.IdempotencyKey{}).
+		Where("key = ? AND owner = ?", key, owner).
+		Updates(map[string]interface{}{
+			"status":     statusCompleted,
+			"expires_at": now.Add(s.ttl),
+			"updated_at": now,
+		}).Error
+}
+
+func (s *DBStore) Release(ctx context.Context, key, owner string) error {
+	db, err := database.Migrated(ctx, &models.IdempotencyKey{})
+	if err != nil {
+		return err
+	}
+	return db.Where("key = ? AND owner = ? AND status = ?", key, owner, statusInProgress).
+		Delete(&models.IdempotencyKey{}).Error
+}
+
+// DeleteExpired removes completed keys whose retention has passed.
+func (s *DBStore) DeleteExpired(ctx context.Context) (int64, error) {
+	db, err := database.Migrated(ctx, &models.IdempotencyKey{})
+	if err != nil {
+		return 0, err
+	}
+	res := db.Where("status = ? AND expires_at <= ?", statusCompleted, time.Now()).
+		Delete(&models.IdempotencyKey{})
+	return res.RowsAffected, res.Error
+}
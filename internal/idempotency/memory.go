@@ -0,0 +1,84 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	owner       string
+	status      string
+	lockedUntil time.Time
+	expiresAt   time.Time
+}
+
+// MemoryStore is an in-process Store. It only deduplicates within one
+// worker process; use DBStore when running several pods.
+type MemoryStore struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+// NewMemoryStore creates an in-memory store remembering completed keys for ttl.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &MemoryStore{ttl: ttl, entries: make(map[string]memoryEntry)}
+}
+
+func (m *MemoryStore) Acquire(ctx context.Context, key, owner string, lease time.Duration) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := m.entries[key]; ok {
+		if e.status == statusCompleted && now.Before(e.expiresAt) {
+			return Completed, nil
+		}
+		if e.status == statusInProgress && now.Before(e.lockedUntil) {
+			return InProgress, nil
+		}
+	}
+	m.entries[key] = memoryEntry{owner: owner, status: statusInProgress, lockedUntil: now.Add(lease)}
+	return Acquired, nil
+}
+
+func (m *MemoryStore) Complete(ctx context.Context, key, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok && e.owner == owner {
+		e.status = statusCompleted
+		e.expiresAt = time.Now().Add(m.ttl)
+		m.entries[key] = e
+	}
+	return nil
+}
+
+func (m *MemoryStore) Release(ctx context.Context, key, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok && e.owner == owner && e.status == statusInProgress {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteExpired(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var n int64
+	for key, e := range m.entries {
+		if e.status == statusCompleted && !now.Before(e.expiresAt) {
+			delete(m.entries, key)
+			n++
+		}
+	}
+	return n, nil
+}
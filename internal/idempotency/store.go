@@ -0,0 +1,77 @@
+package idempotency
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"base-go-app/internal/logging"
+)
+
+// State is the outcome of claiming an idempotency key.
+type State int
+
+const (
+	// Acquired means the caller holds the key and should execute the task.
+	Acquired State = iota
+	// Completed means a task with the key already succeeded.
+	Completed
+	// InProgress means another worker currently holds the key.
+	InProgress
+)
+
+func (s State) String() string {
+	switch s {
+	case Acquired:
+		return "acquired"
+	case Completed:
+		return "completed"
+	case InProgress:
+		return "in_progress"
+	}
+	return "unknown"
+}
+
+const (
+	// DefaultTTL is how long a completed key is remembered.
+	DefaultTTL = 24 * time.Hour
+	// DefaultLease is how long a claim is held when the task has no timeout.
+	// A worker that dies mid-task blocks the key for at most this long.
+	DefaultLease = 10 * time.Minute
+)
+
+const (
+	statusInProgress = "in_progress"
+	statusCompleted  = "completed"
+)
+
+// Store deduplicates task executions by idempotency key.
+type Store interface {
+	// Acquire claims key for owner for the duration of lease.
+	Acquire(ctx context.Context, key, owner string, lease time.Duration) (State, error)
+	// Complete marks key as succeeded so later executions are skipped.
+	Complete(ctx context.Context, key, owner string) error
+	// Release gives up owner's claim on key after a failure so a retry can
+	// acquire it again.
+	Release(ctx context.Context, key, owner string) error
+	// DeleteExpired forgets completed keys whose retention has passed.
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// StartJanitor deletes expired keys every interval until ctx is canceled.
+func StartJanitor(ctx context.Context, s Store, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.DeleteExpired(ctx); err != nil {
+					slog.Warn("Failed to delete expired idempotency keys", logging.Err(err))
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,119 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"base-go-app/internal/database/databasetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStores runs fn against every Store implementation.
+func testStores(t *testing.T, ttl time.Duration, fn func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemoryStore(ttl))
+	})
+	t.Run("database", func(t *testing.T) {
+		databasetest.Use(t)
+		fn(t, NewDBStore(ttl))
+	})
+}
+
+func TestStoreLifecycle(t *testing.T) {
+	testStores(t, time.Hour, func(t *testing.T, s Store) {
+		ctx := context.Background()
+
+		state, err := s.Acquire(ctx, "k", "a", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Acquired, state)
+
+		state, err = s.Acquire(ctx, "k", "b", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, InProgress, state)
+
+		// Only the owner can release
+		require.NoError(t, s.Release(ctx, "k", "b"))
+		state, err = s.Acquire(ctx, "k", "b", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, InProgress, state)
+
+		require.NoError(t, s.Release(ctx, "k", "a"))
+		state, err = s.Acquire(ctx, "k", "b", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Acquired, state)
+
+		require.NoError(t, s.Complete(ctx, "k", "b"))
+		state, err = s.Acquire(ctx, "k", "c", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Completed, state)
+	})
+}
+
+func TestStoreStaleLease(t *testing.T) {
+	testStores(t, time.Hour, func(t *testing.T, s Store) {
+		ctx := context.Background()
+
+		state, err := s.Acquire(ctx, "k", "a", time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, Acquired, state)
+		time.Sleep(5 * time.Millisecond)
+
+		state, err = s.Acquire(ctx, "k", "b", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Acquired, state)
+
+		// The stale owner can no longer complete the key
+		require.NoError(t, s.Complete(ctx, "k", "a"))
+		state, err = s.Acquire(ctx, "k", "c", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, InProgress, state)
+	})
+}
+
+func TestStoreExpiry(t *testing.T) {
+	testStores(t, time.Millisecond, func(t *testing.T, s Store) {
+		ctx := context.Background()
+
+		_, err := s.Acquire(ctx, "k", "a", time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, s.Complete(ctx, "k", "a"))
+		time.Sleep(5 * time.Millisecond)
+
+		n, err := s.DeleteExpired(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+
+		state, err := s.Acquire(ctx, "k", "b", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, Acquired, state)
+	})
+}
+
+func TestStoreConcurrentAcquire(t *testing.T) {
+	testStores(t, time.Hour, func(t *testing.T, s Store) {
+		ctx := context.Background()
+
+		var mu sync.Mutex
+		acquired := 0
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				state, err := s.Acquire(ctx, "k", string(rune('a'+i)), time.Minute)
+				assert.NoError(t, err)
+				if state == Acquired {
+					mu.Lock()
+					acquired++
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.Equal(t, 1, acquired)
+	})
+}
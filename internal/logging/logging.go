@@ -0,0 +1,105 @@
+// Package logging configures the worker's structured logger (log/slog) and
+// carries request-scoped loggers in contexts.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Output formats (LOG_FORMAT).
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Attribute keys shared by every package, so logs can be queried by them.
+const (
+	KeyWorkerID = "worker_id"
+	KeyQueue    = "queue"
+	KeyTaskID   = "task_id"
+	KeyTask     = "task"
+	KeyAttempt  = "attempt"
+	KeyError    = "error"
+	// KeyTraceID is the OpenTelemetry trace ID of the task.
+	KeyTraceID = "trace_id"
+	// KeyComponent names the part of the process that logged, for the
+	// logs not tied to a queue or task.
+	KeyComponent = "component"
+)
+
+// ParseLevel parses debug, info, warn (or warning) and error; empty is
+// info.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// New creates a logger writing to w in format (json or text; empty is
+// json) at level.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "", FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// Setup makes a logger writing to stderr the default for slog and for the
+// standard log package, and returns it. Every record carries the worker ID.
+func Setup(level, format string) (*slog.Logger, error) {
+	logger, err := New(os.Stderr, level, format)
+	if err != nil {
+		return nil, err
+	}
+	logger = logger.With(KeyWorkerID, WorkerID())
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// WorkerID identifies this process: its host name and PID.
+func WorkerID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Err returns the attribute for an error.
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger.
+// Task handlers get a logger with the task's attributes.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
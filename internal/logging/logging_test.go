@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevel(t *testing.T) {
+	for in, want := range map[string]slog.Level{
+		"":        slog.LevelInfo,
+		"info":    slog.LevelInfo,
+		"DEBUG":   slog.LevelDebug,
+		"warn":    slog.LevelWarn,
+		"warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+	} {
+		got, err := ParseLevel(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := ParseLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestNewJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "warn", "")
+	require.NoError(t, err)
+
+	logger.Info("dropped")
+	logger.With(KeyTaskID, "t1").Warn("kept", Err(errors.New("boom")))
+
+	var rec map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec), buf.String())
+	assert.Equal(t, "kept", rec[slog.MessageKey])
+	assert.Equal(t, "WARN", rec[slog.LevelKey])
+	assert.Equal(t, "t1", rec[KeyTaskID])
+	assert.Equal(t, "boom", rec[KeyError])
+}
+
+func TestNewText(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "debug", FormatText)
+	require.NoError(t, err)
+
+	logger.Debug("hello", KeyQueue, "q1")
+	assert.True(t, strings.Contains(buf.String(), "msg=hello queue=q1"), buf.String())
+
+	_, err = New(&buf, "info", "xml")
+	assert.Error(t, err)
+}
+
+func TestContext(t *testing.T) {
+	assert.Same(t, slog.Default(), FromContext(context.Background()))
+
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	assert.Same(t, logger, FromContext(NewContext(context.Background(), logger)))
+}
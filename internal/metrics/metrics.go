@@ -0,0 +1,122 @@
+// Package metrics defines the Prometheus metrics exposed on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefBuckets are histogram buckets in seconds suited to task durations.
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Registry is served on the worker's /metrics endpoint, along with the Go
+// runtime and process metrics.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+func init() {
+	Registry.MustRegister(TasksInFlight, TaskBuffer, WorkerPoolSize,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Worker metrics. Task metrics are labeled with the task name and the
+// queue the task was consumed from.
+var (
+	TasksReceived = factory.NewCounterVec(prometheus.CounterOpts{Name: "worker_tasks_received_total",
+		Help: "Tasks received from RabbitMQ, not counting the deliveries put back to be received again."}, []string{"task", "queue"})
+	TasksSucceeded = factory.NewCounterVec(prometheus.CounterOpts{Name: "worker_tasks_succeeded_total",
+		Help: "Tasks that succeeded."}, []string{"task", "queue"})
+	TasksFailed = factory.NewCounterVec(prometheus.CounterOpts{Name: "worker_tasks_failed_total",
+		Help: "Task executions that failed, including the ones retried."}, []string{"task", "queue"})
+	TasksRetried = factory.NewCounterVec(prometheus.CounterOpts{Name: "worker_tasks_retried_total",
+		Help: "Tasks scheduled for another attempt."}, []string{"task", "queue"})
+	TasksThrottled = factory.NewCounterVec(prometheus.CounterOpts{Name: "worker_tasks_throttled_total",
+		Help: "Tasks re-delayed because their type was at its concurrency or rate limit."}, []string{"task", "queue"})
+	TasksDeadLettered = factory.NewCounterVec(prometheus.CounterOpts{Name: "worker_tasks_dead_lettered_total",
+		Help: "Tasks routed to a dead-letter queue (or rejected without one)."}, []string{"task", "queue"})
+	TaskDuration = factory.NewHistogramVec(prometheus.HistogramOpts{Name: "worker_task_duration_seconds",
+		Help: "Handler execution time.", Buckets: DefBuckets}, []string{"task", "queue"})
+
+	TasksInFlight = newGaugeFuncVec(prometheus.GaugeOpts{Name: "worker_tasks_in_flight",
+		Help: "Tasks being executed."})
+	TaskBuffer = newGaugeFuncVec(prometheus.GaugeOpts{Name: "worker_task_buffer_occupancy",
+		Help: "Deliveries waiting in a queue's in-memory task buffer."}, "queue")
+	TaskBufferCapacity = factory.NewGaugeVec(prometheus.GaugeOpts{Name: "worker_task_buffer_capacity",
+		Help: "Size of a queue's in-memory task buffer."}, []string{"queue"})
+	WorkerPoolSize = newGaugeFuncVec(prometheus.GaugeOpts{Name: "worker_pool_size",
+		Help: "Workers in a queue's pool."}, "queue")
+
+	NotificationFailures = factory.NewCounterVec(prometheus.CounterOpts{Name: "worker_notification_failures_total",
+		Help: "Completion notifications that could not be delivered."}, []string{"channel"})
+
+	RabbitMQReconnects = factory.NewCounterVec(prometheus.CounterOpts{Name: "worker_rabbitmq_reconnects_total",
+		Help: "Connections re-established to RabbitMQ after losing one."}, []string{"component"})
+
+	LoggerDBWriteDuration = factory.NewHistogramVec(prometheus.HistogramOpts{Name: "worker_logger_db_write_seconds",
+		Help:    "Time the logger task takes to insert a log into the database.",
+		Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}}, []string{"result"})
+)
+
+// GaugeFuncVec is a gauge computed at scrape time, with one function per
+// set of label values.
+type GaugeFuncVec struct {
+	opts   prometheus.GaugeOpts
+	labels []string
+
+	mu    sync.Mutex
+	funcs map[string]prometheus.GaugeFunc
+}
+
+func newGaugeFuncVec(opts prometheus.GaugeOpts, labels ...string) *GaugeFuncVec {
+	return &GaugeFuncVec{opts: opts, labels: labels, funcs: map[string]prometheus.GaugeFunc{}}
+}
+
+// Set computes the gauge for values with fn, replacing the function set
+// before for the same values.
+func (v *GaugeFuncVec) Set(fn func() float64, values ...string) {
+	if len(values) != len(v.labels) {
+		panic("metrics: " + v.opts.Name + " takes label values for " + strings.Join(v.labels, ", "))
+	}
+	opts := v.opts
+	opts.ConstLabels = prometheus.Labels{}
+	for i, name := range v.labels {
+		opts.ConstLabels[name] = values[i]
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.funcs[strings.Join(values, "\xff")] = prometheus.NewGaugeFunc(opts, fn)
+}
+
+// With returns the gauge set for values, or nil.
+func (v *GaugeFuncVec) With(values ...string) prometheus.Collector {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if g, ok := v.funcs[strings.Join(values, "\xff")]; ok {
+		return g
+	}
+	return nil
+}
+
+// Describe sends no descriptions: the label values are only known once
+// set, which makes the vector an unchecked collector.
+func (v *GaugeFuncVec) Describe(chan<- *prometheus.Desc) {}
+
+func (v *GaugeFuncVec) Collect(ch chan<- prometheus.Metric) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, g := range v.funcs {
+		g.Collect(ch)
+	}
+}
+
+// Handler serves the Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
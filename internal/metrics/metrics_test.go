@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGaugeFuncVec(t *testing.T) {
+	v := newGaugeFuncVec(prometheus.GaugeOpts{Name: "buffer", Help: "Buffered."}, "queue")
+	n := 7
+	v.Set(func() float64 { return float64(n) }, "a")
+	v.Set(func() float64 { return 2 }, "b")
+
+	assert.NoError(t, testutil.CollectAndCompare(v, strings.NewReader(`# HELP buffer Buffered.
+# TYPE buffer gauge
+buffer{queue="a"} 7
+buffer{queue="b"} 2
+`)))
+
+	// Computed at scrape time
+	n = 9
+	assert.Equal(t, 9.0, testutil.ToFloat64(v.With("a")))
+
+	// Setting the same values again replaces the function
+	v.Set(func() float64 { return 1 }, "a")
+	assert.Equal(t, 1.0, testutil.ToFloat64(v.With("a")))
+	assert.Equal(t, 2, testutil.CollectAndCount(v))
+
+	assert.Nil(t, v.With("c"))
+	assert.Panics(t, func() { v.Set(func() float64 { return 0 }) })
+}
+
+func TestRegistry(t *testing.T) {
+	TasksReceived.WithLabelValues("registry_task", "registry.q").Inc()
+	assert.NoError(t, testutil.GatherAndCompare(Registry, strings.NewReader(`# HELP worker_tasks_received_total Tasks received from RabbitMQ, not counting the deliveries put back to be received again.
+# TYPE worker_tasks_received_total counter
+worker_tasks_received_total{queue="registry.q",task="registry_task"} 1
+`), "worker_tasks_received_total"))
+}
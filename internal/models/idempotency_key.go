@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// IdempotencyKey tracks the execution of tasks sharing an idempotency key.
+type IdempotencyKey struct {
+	Key         string     `gorm:"primary_key"`
+	Owner       string     `gorm:"not null"`
+	Status      string     `gorm:"not null"`
+	LockedUntil time.Time  `gorm:"not null"`
+	ExpiresAt   *time.Time `gorm:"index"`
+	CreatedAt   time.Time  `gorm:"not null"`
+	UpdatedAt   time.Time  `gorm:"not null"`
+}
+
+func (IdempotencyKey) TableName() string {
+	return "task_idempotency_keys"
+}
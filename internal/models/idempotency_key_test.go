@@ -0,0 +1,12 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKey_TableName(t *testing.T) {
+	s := IdempotencyKey{}
+	assert.Equal(t, "task_idempotency_keys", s.TableName())
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// RateLimitBucket is the token bucket of a rate-limited task type, shared
+// by every worker pod.
+type RateLimitBucket struct {
+	Key       string    `gorm:"primary_key"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+func (RateLimitBucket) TableName() string {
+	return "task_rate_limits"
+}
@@ -0,0 +1,12 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitBucket_TableName(t *testing.T) {
+	s := RateLimitBucket{}
+	assert.Equal(t, "task_rate_limits", s.TableName())
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// SchedulerLock is a lease held by the scheduler replica allowed to fire
+// periodic tasks.
+type SchedulerLock struct {
+	Name        string    `gorm:"primary_key"`
+	Owner       string    `gorm:"not null"`
+	LockedUntil time.Time `gorm:"not null"`
+	UpdatedAt   time.Time `gorm:"not null"`
+}
+
+func (SchedulerLock) TableName() string {
+	return "scheduler_locks"
+}
@@ -0,0 +1,12 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerLock_TableName(t *testing.T) {
+	s := SchedulerLock{}
+	assert.Equal(t, "scheduler_locks", s.TableName())
+}
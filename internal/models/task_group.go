@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TaskGroup tracks a group of tasks running in parallel and, for a chord,
+// the callback to publish once all of them succeeded.
+type TaskGroup struct {
+	ID        string          `gorm:"primary_key" json:"id"`
+	Size      int             `gorm:"not null" json:"size"`
+	Succeeded int             `gorm:"not null" json:"succeeded"`
+	Failed    int             `gorm:"not null" json:"failed"`
+	Status    string          `gorm:"not null;index" json:"status"`
+	Callback  json.RawMessage `gorm:"type:text;serializer:json" json:"callback,omitempty"`
+	// FinishedBy is the index of the member whose completion finished the
+	// group
+	FinishedBy *int              `json:"finished_by,omitempty"`
+	Members    []TaskGroupMember `gorm:"foreignKey:GroupID" json:"members,omitempty"`
+	CreatedAt  time.Time         `gorm:"not null" json:"created_at"`
+	UpdatedAt  time.Time         `gorm:"not null" json:"updated_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	ExpiresAt  *time.Time        `gorm:"index" json:"expires_at,omitempty"`
+}
+
+func (TaskGroup) TableName() string {
+	return "task_groups"
+}
+
+// TaskGroupMember is the state of one task of a TaskGroup.
+type TaskGroupMember struct {
+	GroupID string          `gorm:"primary_key" json:"group_id"`
+	Index   int             `gorm:"primary_key;autoIncrement:false" json:"index"`
+	TaskID  string          `gorm:"not null;index" json:"task_id"`
+	Status  string          `gorm:"not null" json:"status"`
+	Result  json.RawMessage `gorm:"type:text;serializer:json" json:"result,omitempty"`
+	Error   string          `gorm:"type:text" json:"error,omitempty"`
+}
+
+func (TaskGroupMember) TableName() string {
+	return "task_group_members"
+}
@@ -0,0 +1,12 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskGroup_TableName(t *testing.T) {
+	assert.Equal(t, "task_groups", TaskGroup{}.TableName())
+	assert.Equal(t, "task_group_members", TaskGroupMember{}.TableName())
+}
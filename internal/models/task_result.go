@@ -0,0 +1,25 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TaskResult records the state and outcome of a task, keyed by task ID.
+type TaskResult struct {
+	TaskID     string          `gorm:"primary_key" json:"task_id"`
+	Task       string          `gorm:"not null;index" json:"task"`
+	Status     string          `gorm:"not null;index" json:"status"`
+	Attempts   int             `gorm:"not null" json:"attempts"`
+	Error      string          `gorm:"type:text" json:"error,omitempty"`
+	Result     json.RawMessage `gorm:"type:text;serializer:json" json:"result,omitempty"`
+	CreatedAt  time.Time       `gorm:"not null" json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	UpdatedAt  time.Time       `gorm:"not null" json:"updated_at"`
+	ExpiresAt  *time.Time      `gorm:"index" json:"expires_at,omitempty"`
+}
+
+func (TaskResult) TableName() string {
+	return "task_results"
+}
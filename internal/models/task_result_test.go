@@ -0,0 +1,12 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskResult_TableName(t *testing.T) {
+	s := TaskResult{}
+	assert.Equal(t, "task_results", s.TableName())
+}
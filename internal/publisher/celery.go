@@ -0,0 +1,270 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// CeleryTaskOptions contains optional parameters for Celery tasks. They map
+// to the arguments of Celery's apply_async.
+type CeleryTaskOptions struct {
+	// Kwargs are the keyword arguments of the task.
+	Kwargs map[string]interface{}
+	// Countdown delays execution by the given duration. Ignored if ETA is set.
+	Countdown time.Duration
+	// ETA is the earliest time the task may run.
+	ETA *time.Time
+	// Expires is the time after which the task is discarded unexecuted.
+	Expires *time.Time
+	// Retries is the current retry count (normally 0).
+	Retries int
+	// Priority is the AMQP message priority (0-255, requires a priority queue).
+	Priority uint8
+	// SoftTimeLimit and TimeLimit are in seconds; zero means no limit.
+	SoftTimeLimit int
+	TimeLimit     int
+	// Link and LinkError are called with the result or the error of the task.
+	Link      []CelerySignature
+	LinkError []CelerySignature
+	// Chain lists the tasks to run after this one, in execution order.
+	Chain []CelerySignature
+	// Chord is the callback of the chord this task is a header member of.
+	Chord *CelerySignature
+	// ParentID, RootID and GroupID place the task in a workflow. RootID
+	// defaults to the task ID.
+	ParentID   string
+	RootID     string
+	GroupID    string
+	GroupIndex *int
+	// ReplyTo is the queue Celery's rpc:// result backend replies to.
+	ReplyTo string
+	// IgnoreResult tells the worker not to store the result.
+	IgnoreResult bool
+	// TaskID overrides the generated task ID.
+	TaskID string
+	// Context carries the caller's trace, propagated in the traceparent
+	// header.
+	Context context.Context
+}
+
+// CelerySignature is a serialized Celery signature, as used in callbacks
+// and chains.
+type CelerySignature struct {
+	Task    string                 `json:"task"`
+	Args    []interface{}          `json:"args"`
+	Kwargs  map[string]interface{} `json:"kwargs"`
+	Options map[string]interface{} `json:"options"`
+	// SubtaskType is e.g. "chain", "group" or "chord" for composite signatures.
+	SubtaskType *string `json:"subtask_type"`
+	Immutable   bool    `json:"immutable"`
+}
+
+// NewCelerySignature creates a signature for task with positional arguments.
+func NewCelerySignature(task string, args ...interface{}) CelerySignature {
+	return CelerySignature{Task: task, Args: args}
+}
+
+// MarshalJSON fills in the empty collections Celery expects.
+func (s CelerySignature) MarshalJSON() ([]byte, error) {
+	type signature CelerySignature
+	out := signature(s)
+	if out.Args == nil {
+		out.Args = []interface{}{}
+	}
+	if out.Kwargs == nil {
+		out.Kwargs = map[string]interface{}{}
+	}
+	if out.Options == nil {
+		out.Options = map[string]interface{}{}
+	}
+	return json.Marshal(out)
+}
+
+// celeryTimeFormat matches Python's datetime.isoformat() for aware datetimes.
+const celeryTimeFormat = "2006-01-02T15:04:05.000000-07:00"
+
+// buildCeleryMessage builds a Celery protocol v2 message and returns it with
+// its task ID.
+func buildCeleryMessage(task string, args []interface{}, opts *CeleryTaskOptions) (string, amqp.Publishing, error) {
+	if opts == nil {
+		opts = &CeleryTaskOptions{}
+	}
+	if args == nil {
+		args = []interface{}{}
+	}
+	kwargs := opts.Kwargs
+	if kwargs == nil {
+		kwargs = map[string]interface{}{}
+	}
+
+	taskID := opts.TaskID
+	if taskID == "" {
+		taskID = uuid.New().String()
+	}
+	rootID := opts.RootID
+	if rootID == "" {
+		rootID = taskID
+	}
+
+	// Celery pops the next task off the end of the chain
+	var chain []CelerySignature
+	if len(opts.Chain) > 0 {
+		chain = make([]CelerySignature, len(opts.Chain))
+		for i, sig := range opts.Chain {
+			chain[len(chain)-1-i] = sig
+		}
+	}
+
+	// Generate Celery Payload Message Protocol v2
+	// Format: [[args...], {kwargs}, {metadata}]
+	body := []interface{}{
+		args,
+		kwargs,
+		map[string]interface{}{ // metadata
+			"callbacks": nilIfEmpty(opts.Link),
+			"errbacks":  nilIfEmpty(opts.LinkError),
+			"chain":     nilIfEmpty(chain),
+			"chord":     opts.Chord,
+		},
+	}
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return "", amqp.Publishing{}, fmt.Errorf("failed to marshal message body: %w", err)
+	}
+
+	eta := opts.ETA
+	if eta == nil && opts.Countdown > 0 {
+		t := time.Now().Add(opts.Countdown)
+		eta = &t
+	}
+
+	headers := amqp.Table{
+		"lang":          "py",
+		"task":          task,
+		"id":            taskID,
+		"shadow":        nil,
+		"eta":           formatCeleryTime(eta),
+		"expires":       formatCeleryTime(opts.Expires),
+		"group":         nilIfBlank(opts.GroupID),
+		"group_index":   nil,
+		"retries":       int32(opts.Retries),
+		"timelimit":     []interface{}{nilIfZero(opts.SoftTimeLimit), nilIfZero(opts.TimeLimit)},
+		"root_id":       rootID,
+		"parent_id":     nilIfBlank(opts.ParentID),
+		"argsrepr":      pyRepr(args),
+		"kwargsrepr":    pyRepr(kwargs),
+		"origin":        celeryOrigin(),
+		"ignore_result": opts.IgnoreResult,
+	}
+	if opts.GroupIndex != nil {
+		headers["group_index"] = int32(*opts.GroupIndex)
+	}
+
+	msg := amqp.Publishing{
+		ContentType:     "application/json",
+		ContentEncoding: "utf-8",
+		DeliveryMode:    amqp.Persistent,
+		Priority:        opts.Priority,
+		Body:            bodyBytes,
+		Headers:         headers,
+		CorrelationId:   taskID,
+		MessageId:       taskID,
+		ReplyTo:         opts.ReplyTo,
+	}
+	return taskID, msg, nil
+}
+
+// celeryOrigin mimics Celery's default origin ("gen<pid>@<hostname>").
+func celeryOrigin() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("gen%d@%s", os.Getpid(), host)
+}
+
+func formatCeleryTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(celeryTimeFormat)
+}
+
+func nilIfEmpty(sigs []CelerySignature) interface{} {
+	if len(sigs) == 0 {
+		return nil
+	}
+	return sigs
+}
+
+func nilIfBlank(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nilIfZero(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return int32(n)
+}
+
+// pyRepr renders v like Python's repr(), for the argsrepr and kwargsrepr
+// headers shown by Celery tooling.
+func pyRepr(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "None"
+	case bool:
+		if x {
+			return "True"
+		}
+		return "False"
+	case string:
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`).Replace(x) + "'"
+	case int:
+		return strconv.Itoa(x)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case []interface{}:
+		parts := make([]string, len(x))
+		for i, e := range x {
+			parts[i] = pyRepr(e)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = pyRepr(k) + ": " + pyRepr(x[k])
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	}
+	// Anything else: go through JSON to get plain values
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	var plain interface{}
+	if err := json.Unmarshal(b, &plain); err != nil {
+		return string(b)
+	}
+	return pyRepr(plain)
+}
@@ -0,0 +1,156 @@
+package publisher
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeCeleryBody(t *testing.T, body []byte) ([]interface{}, map[string]interface{}, map[string]interface{}) {
+	t.Helper()
+	var parts []json.RawMessage
+	require.NoError(t, json.Unmarshal(body, &parts))
+	require.Len(t, parts, 3)
+
+	var args []interface{}
+	var kwargs, embed map[string]interface{}
+	require.NoError(t, json.Unmarshal(parts[0], &args))
+	require.NoError(t, json.Unmarshal(parts[1], &kwargs))
+	require.NoError(t, json.Unmarshal(parts[2], &embed))
+	return args, kwargs, embed
+}
+
+func TestBuildCeleryMessage_Defaults(t *testing.T) {
+	taskID, msg, err := buildCeleryMessage("tasks.add", []interface{}{1, 2}, nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, taskID)
+	require.NoError(t, msg.Headers.Validate())
+
+	assert.Equal(t, taskID, msg.CorrelationId)
+	assert.Equal(t, "application/json", msg.ContentType)
+	assert.Equal(t, "py", msg.Headers["lang"])
+	assert.Equal(t, "tasks.add", msg.Headers["task"])
+	assert.Equal(t, taskID, msg.Headers["id"])
+	assert.Equal(t, taskID, msg.Headers["root_id"])
+	assert.Nil(t, msg.Headers["parent_id"])
+	assert.Nil(t, msg.Headers["group"])
+	assert.Nil(t, msg.Headers["eta"])
+	assert.Nil(t, msg.Headers["expires"])
+	assert.Equal(t, int32(0), msg.Headers["retries"])
+	assert.Equal(t, []interface{}{nil, nil}, msg.Headers["timelimit"])
+	assert.Equal(t, "[1, 2]", msg.Headers["argsrepr"])
+	assert.Equal(t, "{}", msg.Headers["kwargsrepr"])
+	assert.Contains(t, msg.Headers["origin"], "gen")
+
+	args, kwargs, embed := decodeCeleryBody(t, msg.Body)
+	assert.Equal(t, []interface{}{float64(1), float64(2)}, args)
+	assert.Empty(t, kwargs)
+	for _, k := range []string{"callbacks", "errbacks", "chain", "chord"} {
+		v, ok := embed[k]
+		assert.True(t, ok, k)
+		assert.Nil(t, v, k)
+	}
+}
+
+func TestBuildCeleryMessage_Options(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	groupIndex := 2
+	opts := &CeleryTaskOptions{
+		Kwargs:        map[string]interface{}{"name": "it's", "force": true},
+		Expires:       &expires,
+		Retries:       3,
+		Priority:      7,
+		SoftTimeLimit: 30,
+		TimeLimit:     60,
+		Link:          []CelerySignature{NewCelerySignature("tasks.on_success")},
+		LinkError:     []CelerySignature{NewCelerySignature("tasks.on_error", "x")},
+		Chain: []CelerySignature{
+			NewCelerySignature("tasks.second"),
+			NewCelerySignature("tasks.third"),
+		},
+		ParentID:   "parent-1",
+		RootID:     "root-1",
+		GroupID:    "group-1",
+		GroupIndex: &groupIndex,
+		ReplyTo:    "reply-queue",
+		TaskID:     "fixed-id",
+	}
+
+	taskID, msg, err := buildCeleryMessage("tasks.first", nil, opts)
+	require.NoError(t, err)
+	require.NoError(t, msg.Headers.Validate())
+
+	assert.Equal(t, "fixed-id", taskID)
+	assert.Equal(t, uint8(7), msg.Priority)
+	assert.Equal(t, "reply-queue", msg.ReplyTo)
+	assert.Equal(t, "root-1", msg.Headers["root_id"])
+	assert.Equal(t, "parent-1", msg.Headers["parent_id"])
+	assert.Equal(t, "group-1", msg.Headers["group"])
+	assert.Equal(t, int32(2), msg.Headers["group_index"])
+	assert.Equal(t, int32(3), msg.Headers["retries"])
+	assert.Equal(t, []interface{}{int32(30), int32(60)}, msg.Headers["timelimit"])
+	assert.Equal(t, "2030-01-02T03:04:05.000000+00:00", msg.Headers["expires"])
+	assert.Equal(t, "[]", msg.Headers["argsrepr"])
+	assert.Equal(t, `{'force': True, 'name': 'it\'s'}`, msg.Headers["kwargsrepr"])
+
+	args, kwargs, embed := decodeCeleryBody(t, msg.Body)
+	assert.Empty(t, args)
+	assert.Equal(t, "it's", kwargs["name"])
+
+	callbacks := embed["callbacks"].([]interface{})
+	require.Len(t, callbacks, 1)
+	cb := callbacks[0].(map[string]interface{})
+	assert.Equal(t, "tasks.on_success", cb["task"])
+	assert.Equal(t, []interface{}{}, cb["args"])
+	assert.Equal(t, map[string]interface{}{}, cb["kwargs"])
+	assert.Equal(t, map[string]interface{}{}, cb["options"])
+	assert.Nil(t, cb["subtask_type"])
+	assert.Equal(t, false, cb["immutable"])
+
+	errbacks := embed["errbacks"].([]interface{})
+	require.Len(t, errbacks, 1)
+	assert.Equal(t, []interface{}{"x"}, errbacks[0].(map[string]interface{})["args"])
+
+	// Chains are stored in reverse: the next task is last
+	chain := embed["chain"].([]interface{})
+	require.Len(t, chain, 2)
+	assert.Equal(t, "tasks.third", chain[0].(map[string]interface{})["task"])
+	assert.Equal(t, "tasks.second", chain[1].(map[string]interface{})["task"])
+}
+
+func TestBuildCeleryMessage_Countdown(t *testing.T) {
+	before := time.Now()
+	_, msg, err := buildCeleryMessage("tasks.later", nil, &CeleryTaskOptions{Countdown: time.Minute})
+	require.NoError(t, err)
+
+	eta, err := time.Parse(celeryTimeFormat, msg.Headers["eta"].(string))
+	require.NoError(t, err)
+	assert.WithinDuration(t, before.Add(time.Minute), eta, 5*time.Second)
+
+	// An explicit ETA wins over the countdown
+	at := time.Date(2030, 6, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	_, msg, err = buildCeleryMessage("tasks.later", nil, &CeleryTaskOptions{Countdown: time.Minute, ETA: &at})
+	require.NoError(t, err)
+	assert.Equal(t, "2030-06-01T10:00:00.000000+00:00", msg.Headers["eta"])
+}
+
+func TestPyRepr(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, "None"},
+		{true, "True"},
+		{"a\nb", `'a\nb'`},
+		{1.5, "1.5"},
+		{[]interface{}{"a", 1, nil}, "['a', 1, None]"},
+		{map[string]interface{}{"b": false, "a": []interface{}{}}, "{'a': [], 'b': False}"},
+		{[]string{"x", "y"}, "['x', 'y']"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, pyRepr(tt.in))
+	}
+}
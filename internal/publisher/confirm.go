@@ -0,0 +1,128 @@
+package publisher
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// ErrNacked is returned when the broker refused to take responsibility
+	// for a message (basic.nack), e.g. because a queue limit was reached.
+	ErrNacked = errors.New("message was nacked by the broker")
+	// ErrUnroutable is returned when a message matched no queue and was
+	// returned by the broker (basic.return).
+	ErrUnroutable = errors.New("message is unroutable")
+	// ErrConfirmTimeout is returned when the broker did not confirm a
+	// message in time. The message may or may not have been enqueued.
+	ErrConfirmTimeout = errors.New("timed out waiting for publisher confirm")
+)
+
+// DefaultConfirmTimeout is used when config.PublisherConfirmTimeoutSeconds
+// is not set.
+const DefaultConfirmTimeout = 5 * time.Second
+
+// confirmBuffer bounds the confirmations and returns queued by the AMQP
+// reader before they are consumed.
+const confirmBuffer = 128
+
+// confirmChannel is a channel in confirm mode with its confirmation and
+// return listeners. Delivery tags are counted locally: the broker numbers
+// them 1, 2, ... per channel in publish order.
+type confirmChannel struct {
+	conn     amqpConnection
+	ch       amqpChannel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	nextTag  uint64
+}
+
+// openConfirmChannel opens a channel on conn and puts it in confirm mode.
+func openConfirmChannel(conn amqpConnection) (*confirmChannel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	return &confirmChannel{
+		conn:     conn,
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, confirmBuffer)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, confirmBuffer)),
+	}, nil
+}
+
+// publish sends msg and returns its delivery tag. Mandatory messages that
+// cannot be routed come back as basic.return, see wait.
+func (c *confirmChannel) publish(exchange, key string, mandatory bool, msg amqp.Publishing) (uint64, error) {
+	if err := c.ch.Publish(exchange, key, mandatory, false, msg); err != nil {
+		return 0, err
+	}
+	c.nextTag++
+	return c.nextTag, nil
+}
+
+// wait blocks until the broker confirms every tag in pending, which maps
+// delivery tags to message IDs, and returns an error per tag (nil entries
+// were confirmed). The broker sends basic.return before the ack of the same
+// message, so a return is always queued by the time its confirmation
+// arrives.
+func (c *confirmChannel) wait(pending map[uint64]string, timeout time.Duration) map[uint64]error {
+	errs := make(map[uint64]error, len(pending))
+	returned := make(map[string]*amqp.Return)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for len(pending) > 0 {
+		select {
+		case conf, ok := <-c.confirms:
+			if !ok {
+				for tag := range pending {
+					errs[tag] = fmt.Errorf("%w: channel closed before confirm", amqp.ErrClosed)
+				}
+				return errs
+			}
+			id, ok := pending[conf.DeliveryTag]
+			if !ok {
+				continue
+			}
+			delete(pending, conf.DeliveryTag)
+			if !conf.Ack {
+				errs[conf.DeliveryTag] = ErrNacked
+				continue
+			}
+			c.takeReturns(returned)
+			if r := returned[id]; r != nil {
+				errs[conf.DeliveryTag] = fmt.Errorf("%w: %s (exchange=%q routing_key=%q)", ErrUnroutable, r.ReplyText, r.Exchange, r.RoutingKey)
+				continue
+			}
+			errs[conf.DeliveryTag] = nil
+		case <-timer.C:
+			for tag := range pending {
+				errs[tag] = fmt.Errorf("%w after %v", ErrConfirmTimeout, timeout)
+			}
+			return errs
+		}
+	}
+	return errs
+}
+
+// takeReturns moves queued returns into returned, keyed by message ID.
+func (c *confirmChannel) takeReturns(returned map[string]*amqp.Return) {
+	for {
+		select {
+		case r, ok := <-c.returns:
+			if !ok {
+				return
+			}
+			returned[r.MessageId] = &r
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,613 @@
+package publisher
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/logging"
+	"base-go-app/internal/metrics"
+	"base-go-app/internal/tracing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrNotConnected is returned when RabbitMQ is unreachable and the
+	// message could not be buffered.
+	ErrNotConnected = errors.New("publisher is not connected to RabbitMQ")
+	// ErrBufferFull is returned when the outage buffer has no room left.
+	ErrBufferFull = errors.New("publisher buffer is full")
+	// ErrPublisherClosed is returned after Close has been called.
+	ErrPublisherClosed = errors.New("publisher is closed")
+	// ErrChannelClosed is returned when a channel closed while its
+	// connection stayed up, even after a retry on a fresh channel. Such
+	// messages are not buffered: no reconnect would flush them.
+	ErrChannelClosed = errors.New("publisher channel closed")
+)
+
+// Reconnect backoff, matching the consumer
+const (
+	initialReconnectDelay = 2 * time.Second
+	maxReconnectDelay     = 30 * time.Second
+)
+
+// amqpChannel is the subset of *amqp.Channel used by the publisher.
+type amqpChannel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	Close() error
+}
+
+// amqpConnection is the subset of *amqp.Connection used by the publisher.
+type amqpConnection interface {
+	Channel() (amqpChannel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// dialedConnection adapts *amqp.Connection to amqpConnection.
+type dialedConnection struct {
+	*amqp.Connection
+}
+
+func (c dialedConnection) Channel() (amqpChannel, error) {
+	return c.Connection.Channel()
+}
+
+func dialAMQP(url string) (amqpConnection, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return dialedConnection{conn}, nil
+}
+
+// outgoing is a message waiting to be published.
+type outgoing struct {
+	queue    string
+	exchange string
+	msg      amqp.Publishing
+	// eta, when set, is when the message is due; see withDelay
+	eta time.Time
+
+	// waitQueue and waitArgs hold a delayed message in a TTL wait queue;
+	// delayed sends it through the delayed-message exchange (see withDelay)
+	waitQueue string
+	waitArgs  amqp.Table
+	delayed   bool
+
+	// celery marks a Celery message, consumed by Python workers
+	celery bool
+
+	// span is the producer span of the message, if traced
+	span trace.Span
+}
+
+// messageID identifies m in basic.return frames.
+func (m outgoing) messageID() string {
+	return m.msg.MessageId
+}
+
+// baseRoute identifies the declarations of m's queue and exchange.
+func (m outgoing) baseRoute() string {
+	return m.exchange + "/" + m.queue
+}
+
+// route identifies all the declarations m needs.
+func (m outgoing) route() string {
+	switch {
+	case m.waitQueue != "":
+		return m.baseRoute() + "|" + m.waitQueue
+	case m.delayed:
+		return m.baseRoute() + "|" + delayedExchange
+	}
+	return m.baseRoute()
+}
+
+// target returns where m is published. The delayed-message exchange returns
+// every mandatory message as unroutable, so those are not mandatory.
+func (m outgoing) target() (exchange, key string, mandatory bool) {
+	switch {
+	case m.waitQueue != "":
+		return "", m.waitQueue, true
+	case m.delayed:
+		return delayedExchange, m.queue, false
+	}
+	return m.exchange, m.queue, true
+}
+
+// watch reconnects pc whenever its connection is closed by the broker or
+// the network, until the publisher is closed. notifyClose belongs to conn.
+func (p *RabbitMQPublisher) watch(pc *pooledConnection, conn amqpConnection, notifyClose chan *amqp.Error) {
+	for {
+		select {
+		case <-p.done:
+			return
+		case err := <-notifyClose:
+			select {
+			case <-p.done:
+				return
+			default:
+			}
+			slog.Warn("RabbitMQ connection closed", logging.KeyComponent, "publisher", logging.Err(err))
+		}
+
+		// Idle channels of the dead connection are discarded on next use
+		p.mu.Lock()
+		if pc.conn == conn {
+			pc.conn = nil
+		}
+		p.mu.Unlock()
+
+		if conn, notifyClose = p.reconnect(pc); conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials with exponential backoff until it succeeds, then flushes
+// the outage buffer. It returns nil if the publisher is closed meanwhile.
+func (p *RabbitMQPublisher) reconnect(pc *pooledConnection) (amqpConnection, chan *amqp.Error) {
+	delay := p.reconnectDelay
+	if delay <= 0 {
+		delay = initialReconnectDelay
+	}
+	for {
+		select {
+		case <-p.done:
+			return nil, nil
+		case <-time.After(delay):
+		}
+
+		conn, err := p.dial(p.config.GetRabbitMQURL())
+		if err != nil {
+			slog.Warn("RabbitMQ reconnect failed", logging.KeyComponent, "publisher", logging.Err(err), "retry_in", delay)
+			if delay < maxReconnectDelay {
+				delay *= 2
+				if delay > maxReconnectDelay {
+					delay = maxReconnectDelay
+				}
+			}
+			continue
+		}
+		notifyClose := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return nil, nil
+		}
+		pc.conn = conn
+		// The broker may have lost its state, e.g. after a restart
+		p.routes = nil
+		// Sends from now on wait for the backlog, so they cannot overtake it
+		flushing := p.startFlushLocked()
+		p.mu.Unlock()
+
+		metrics.RabbitMQReconnects.WithLabelValues("publisher").Inc()
+		flushed := 0
+		if flushing {
+			flushed = p.flush()
+		}
+		slog.Info("Reconnected to RabbitMQ", logging.KeyComponent, "publisher", "flushed", flushed, "buffered", p.Buffered())
+		return conn, notifyClose
+	}
+}
+
+// send declares the route for m and publishes it, waiting for the broker
+// to confirm it. During an outage the message is buffered when buffering is
+// enabled.
+func (p *RabbitMQPublisher) send(m outgoing) error {
+	return p.sendAll([]outgoing{m})[0]
+}
+
+// sendAll publishes msgs like send and returns an error per message.
+func (p *RabbitMQPublisher) sendAll(msgs []outgoing) []error {
+	p.waitFlush()
+	errs := p.publishAll(msgs)
+	defer func() {
+		for i, m := range msgs {
+			if m.span != nil {
+				tracing.End(m.span, errs[i])
+			}
+		}
+	}()
+	if p.bufferSize <= 0 {
+		return errs
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, err := range errs {
+		if err == nil || !isConnectionError(err) {
+			continue
+		}
+		switch {
+		case p.closed:
+			errs[i] = ErrPublisherClosed
+		case len(p.buffer) >= p.bufferSize:
+			errs[i] = fmt.Errorf("%w (%d messages): %v", ErrBufferFull, len(p.buffer), err)
+		default:
+			p.buffer = append(p.buffer, msgs[i])
+			errs[i] = nil
+		}
+	}
+	return errs
+}
+
+// publish publishes a single message and waits for its confirm.
+func (p *RabbitMQPublisher) publish(m outgoing) error {
+	return p.publishAll([]outgoing{m})[0]
+}
+
+// publishAll publishes msgs in order on one pooled channel, pipelining up
+// to confirmBuffer messages between waits for confirms, and returns an
+// error per message.
+func (p *RabbitMQPublisher) publishAll(msgs []outgoing) []error {
+	resolved := make([]outgoing, len(msgs))
+	for i, m := range msgs {
+		resolved[i] = p.withDelay(m)
+	}
+	return p.publishOn(resolved, true)
+}
+
+// publishOn publishes resolved msgs for publishAll. When the channel breaks
+// while its connection stays up, the messages not yet confirmed are
+// published again on a fresh channel if retry is set; otherwise they fail
+// with ErrChannelClosed.
+func (p *RabbitMQPublisher) publishOn(msgs []outgoing, retry bool) []error {
+	errs := make([]error, len(msgs))
+	ch, err := p.acquire()
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	// Declare every route first; a failed declaration replaces the channel,
+	// which must not happen while confirms are outstanding on it
+	routeErrs := make(map[string]error)
+	for _, m := range msgs {
+		key := m.route()
+		if _, done := routeErrs[key]; done || ch == nil {
+			continue
+		}
+		ch, routeErrs[key] = p.ensureRoute(ch, m)
+	}
+	if ch == nil {
+		for i, m := range msgs {
+			if errs[i] = routeErrs[m.route()]; errs[i] == nil {
+				errs[i] = ErrNotConnected
+			}
+		}
+		return errs
+	}
+
+	healthy := true
+	pending := make(map[uint64]string)
+	index := make(map[uint64]int)
+	collect := func() {
+		for tag, err := range ch.wait(pending, p.confirmTimeout()) {
+			i := index[tag]
+			errs[i] = err
+			if errors.Is(err, ErrUnroutable) {
+				p.forgetRoute(msgs[i].baseRoute())
+				p.forgetRoute(msgs[i].route())
+			}
+			if err != nil && channelBroken(err) {
+				healthy = false
+			}
+		}
+		pending = make(map[uint64]string)
+		index = make(map[uint64]int)
+	}
+
+	// unsent are the messages that never reached the broker because the
+	// channel was closed
+	var unsent []int
+	for i, m := range msgs {
+		if !healthy {
+			errs[i] = fmt.Errorf("%w: channel closed before publish", amqp.ErrClosed)
+			unsent = append(unsent, i)
+			continue
+		}
+		if errs[i] = routeErrs[m.route()]; errs[i] != nil {
+			continue
+		}
+		exchange, key, mandatory := m.target()
+		tag, err := ch.publish(exchange, key, mandatory, m.msg)
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to publish message: %w", err)
+			if errors.Is(err, amqp.ErrClosed) {
+				unsent = append(unsent, i)
+			}
+			healthy = false
+			continue
+		}
+		pending[tag] = m.messageID()
+		index[tag] = i
+		if len(pending) >= confirmBuffer {
+			collect()
+		}
+	}
+	if len(pending) > 0 {
+		collect()
+	}
+
+	p.release(ch, healthy)
+	if healthy || !p.connLive(ch.conn) {
+		// Connection errors are buffered until the reconnect flushes them
+		return errs
+	}
+
+	// Only the channel broke: its connection errors must not be buffered
+	for i, err := range errs {
+		if err != nil && isConnectionError(err) {
+			errs[i] = fmt.Errorf("%w: %v", ErrChannelClosed, err)
+		}
+	}
+	if retry && len(unsent) > 0 {
+		again := make([]outgoing, len(unsent))
+		for j, i := range unsent {
+			again[j] = msgs[i]
+		}
+		for j, err := range p.publishOn(again, false) {
+			errs[unsent[j]] = err
+		}
+	}
+	return errs
+}
+
+// ensureRoute declares m's route unless it is already known to exist. A
+// failed declaration closes the channel, so it is replaced by a fresh one;
+// the returned channel is nil if none could be acquired.
+func (p *RabbitMQPublisher) ensureRoute(ch *confirmChannel, m outgoing) (*confirmChannel, error) {
+	var err error
+	if !p.routeKnown(m.baseRoute()) {
+		ch, err = p.declare(ch, m.baseRoute(), func(c amqpChannel) error {
+			return declareRoute(c, m.queue, m.exchange, p.queueArguments(m))
+		})
+		if ch == nil || err != nil {
+			return ch, err
+		}
+	}
+
+	switch {
+	case m.waitQueue != "":
+		// Not cached: redeclaring on every publish keeps the wait queue
+		// from expiring
+		return p.declare(ch, "", func(c amqpChannel) error { return declareDelay(c, m) })
+	case m.delayed && !p.routeKnown(m.route()):
+		return p.declare(ch, m.route(), func(c amqpChannel) error { return declareDelay(c, m) })
+	}
+	return ch, nil
+}
+
+// declare runs fn on ch and, once it succeeded, remembers the route key
+// (unless empty).
+func (p *RabbitMQPublisher) declare(ch *confirmChannel, key string, fn func(amqpChannel) error) (*confirmChannel, error) {
+	err := fn(ch.ch)
+	if err == nil {
+		if key != "" {
+			p.rememberRoute(key)
+		}
+		return ch, nil
+	}
+	if isPreconditionFailed(err) {
+		// The queue or exchange already exists with other arguments (e.g.
+		// the worker's dead-letter settings), so it is there to receive the
+		// message
+		if key != "" {
+			p.rememberRoute(key)
+		}
+		err = nil
+	}
+
+	p.release(ch, false)
+	next, acqErr := p.acquire()
+	if acqErr != nil {
+		if err == nil {
+			err = acqErr
+		}
+		return nil, err
+	}
+	return next, err
+}
+
+func (p *RabbitMQPublisher) routeKnown(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.routes[key]
+}
+
+func (p *RabbitMQPublisher) rememberRoute(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.routes == nil {
+		p.routes = make(map[string]bool)
+	}
+	p.routes[key] = true
+}
+
+// forgetRoute makes the next publish redeclare the route, e.g. after the
+// queue was deleted.
+func (p *RabbitMQPublisher) forgetRoute(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.routes, key)
+}
+
+// startFlushLocked marks a flush of the buffer as running, unless one
+// already is or the buffer is empty, and reports whether the caller must
+// run it. p.mu must be held.
+func (p *RabbitMQPublisher) startFlushLocked() bool {
+	if p.flushed != nil || len(p.buffer) == 0 {
+		return false
+	}
+	p.flushed = make(chan struct{})
+	return true
+}
+
+// waitFlush blocks while the buffer is being flushed, so that a send made
+// meanwhile goes out after the messages buffered before it.
+func (p *RabbitMQPublisher) waitFlush() {
+	p.mu.Lock()
+	flushed := p.flushed
+	p.mu.Unlock()
+	if flushed == nil {
+		return
+	}
+	select {
+	case <-flushed:
+	case <-p.done:
+	}
+}
+
+// flush publishes buffered messages in order and returns how many were
+// sent. It stops at the first connection error, keeping the rest; messages
+// the broker rejects are logged and dropped. It runs the flush marked by
+// startFlushLocked.
+func (p *RabbitMQPublisher) flush() int {
+	defer func() {
+		p.mu.Lock()
+		close(p.flushed)
+		p.flushed = nil
+		p.mu.Unlock()
+	}()
+
+	n := 0
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.buffer) == 0 {
+			p.mu.Unlock()
+			return n
+		}
+		m := p.buffer[0]
+		p.mu.Unlock()
+
+		err := p.publish(m)
+		if err != nil && isConnectionError(err) {
+			slog.Warn("Failed to flush buffered message", logging.KeyComponent, "publisher", logging.KeyQueue, m.queue, logging.Err(err))
+			return n
+		}
+
+		p.mu.Lock()
+		if !p.closed && len(p.buffer) > 0 {
+			p.buffer[0] = outgoing{}
+			p.buffer = p.buffer[1:]
+		}
+		p.mu.Unlock()
+
+		if err != nil {
+			slog.Error("Dropping buffered message", logging.KeyComponent, "publisher", logging.KeyQueue, m.queue, logging.Err(err))
+			continue
+		}
+		n++
+	}
+}
+
+// declareRoute declares queue (durable, with args) and, for a named
+// exchange, the direct exchange and the binding with routing key = queue,
+// as Celery's kombu does, so published tasks always have somewhere to go.
+func declareRoute(ch amqpChannel, queue, exchange string, args amqp.Table) error {
+	if _, err := ch.QueueDeclare(
+		queue, // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		args,  // arguments
+	); err != nil {
+		return fmt.Errorf("failed to declare queue: %w", err)
+	}
+	if exchange == "" {
+		return nil
+	}
+
+	if err := ch.ExchangeDeclare(
+		exchange, // name
+		"direct", // type
+		true,     // durable
+		false,    // auto-deleted
+		false,    // internal
+		false,    // no-wait
+		nil,      // arguments
+	); err != nil {
+		return fmt.Errorf("failed to declare exchange: %w", err)
+	}
+	if err := ch.QueueBind(queue, queue, exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue: %w", err)
+	}
+	return nil
+}
+
+// queueArguments returns the arguments m's queue is declared with: the
+// worker's for a queue it consumes, so that whichever side creates it first,
+// the other can still declare it. Other queues, and every queue of Python
+// workers, are declared without arguments, as kombu does.
+func (p *RabbitMQPublisher) queueArguments(m outgoing) amqp.Table {
+	if m.celery {
+		return nil
+	}
+	cfg := p.config
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+	q, ok := cfg.ConsumedQueue(m.queue)
+	if !ok {
+		return nil
+	}
+	return amqp.Table(q.Arguments())
+}
+
+// confirmTimeout returns how long to wait for a publisher confirm.
+func (p *RabbitMQPublisher) confirmTimeout() time.Duration {
+	if p.config != nil && p.config.PublisherConfirmTimeoutSeconds > 0 {
+		return time.Duration(p.config.PublisherConfirmTimeoutSeconds) * time.Second
+	}
+	return DefaultConfirmTimeout
+}
+
+// channelBroken reports whether err left the channel unusable: the broker
+// closes channels on protocol errors, and after a confirm timeout a late
+// confirm would be mistaken for the next message's.
+func channelBroken(err error) bool {
+	var amqpErr *amqp.Error
+	return errors.Is(err, amqp.ErrClosed) || errors.Is(err, ErrConfirmTimeout) || errors.As(err, &amqpErr)
+}
+
+func isPreconditionFailed(err error) bool {
+	var amqpErr *amqp.Error
+	return errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed
+}
+
+// isConnectionError reports whether err means RabbitMQ is unreachable, as
+// opposed to the broker rejecting the message.
+func isConnectionError(err error) bool {
+	if errors.Is(err, ErrNotConnected) || errors.Is(err, amqp.ErrClosed) {
+		return true
+	}
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		return amqpErr.Code == amqp.ConnectionForced
+	}
+	return false
+}
+
+// Buffered returns the number of messages waiting for a reconnect.
+func (p *RabbitMQPublisher) Buffered() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.buffer)
+}
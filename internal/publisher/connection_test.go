@@ -0,0 +1,691 @@
+package publisher
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"base-go-app/internal/config"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePubChannel struct {
+	mu         sync.Mutex
+	published  []outgoing
+	bindings   []string
+	err        error
+	declareErr error
+	declares   int
+	queues     map[string]amqp.Table
+	closed     bool
+
+	// Broker behaviour for the next publishes
+	nack         bool
+	unroutable   bool
+	// unroutableQueue makes only messages to this queue unroutable
+	unroutableQueue string
+	noConfirm    bool
+	confirmDelay time.Duration
+
+	tag      uint64
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+func (c *fakePubChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+func (c *fakePubChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.declares++
+	if c.queues == nil {
+		c.queues = map[string]amqp.Table{}
+	}
+	c.queues[name] = args
+	return amqp.Queue{Name: name}, c.declareErr
+}
+
+func (c *fakePubChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bindings = append(c.bindings, exchange+"/"+key+"->"+name)
+	return nil
+}
+
+func (c *fakePubChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.tag++
+	if (c.unroutable || key == c.unroutableQueue) && mandatory {
+		c.returns <- amqp.Return{ReplyCode: amqp.NoRoute, ReplyText: "NO_ROUTE", Exchange: exchange, RoutingKey: key, MessageId: msg.MessageId}
+	} else if !c.nack {
+		c.published = append(c.published, outgoing{queue: key, exchange: exchange, msg: msg})
+	}
+	conf := amqp.Confirmation{DeliveryTag: c.tag, Ack: !c.nack}
+	switch {
+	case c.noConfirm:
+	case c.confirmDelay > 0:
+		go func() {
+			time.Sleep(c.confirmDelay)
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if !c.closed {
+				c.confirms <- conf
+			}
+		}()
+	default:
+		c.confirms <- conf
+	}
+	return nil
+}
+
+func (c *fakePubChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (c *fakePubChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.confirms = confirm
+	return confirm
+}
+
+func (c *fakePubChannel) NotifyReturn(ret chan amqp.Return) chan amqp.Return {
+	c.returns = ret
+	return ret
+}
+
+func (c *fakePubChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shutdown()
+	return nil
+}
+
+// shutdown closes the listeners like amqp091 does. c.mu must be held.
+func (c *fakePubChannel) shutdown() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.err = amqp.ErrClosed
+	close(c.confirms)
+	close(c.returns)
+}
+
+func (c *fakePubChannel) set(f func(c *fakePubChannel)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f(c)
+}
+
+func (c *fakePubChannel) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.published)
+}
+
+func (c *fakePubChannel) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+type fakePubConnection struct {
+	mu       sync.Mutex
+	channels []*fakePubChannel
+	notify   []chan *amqp.Error
+	closed   bool
+	// publishErr is the publish error of channels opened from now on
+	publishErr error
+}
+
+func (c *fakePubConnection) Channel() (amqpChannel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &fakePubChannel{err: c.publishErr}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *fakePubConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		close(receiver)
+		return receiver
+	}
+	c.notify = append(c.notify, receiver)
+	return receiver
+}
+
+func (c *fakePubConnection) Close() error {
+	c.drop(nil)
+	return nil
+}
+
+// drop simulates the broker closing the connection.
+func (c *fakePubConnection) drop(err *amqp.Error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for _, ch := range c.channels {
+		ch.mu.Lock()
+		ch.shutdown()
+		ch.mu.Unlock()
+	}
+	for _, n := range c.notify {
+		if err != nil {
+			n <- err
+		}
+		close(n)
+	}
+}
+
+func (c *fakePubConnection) channelCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.channels)
+}
+
+func (c *fakePubConnection) published() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, ch := range c.channels {
+		n += ch.count()
+	}
+	return n
+}
+
+func (c *fakePubConnection) channel(i int) *fakePubChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.channels[i]
+}
+
+// fakeDialer hands out connections and can be switched off to simulate an
+// unreachable broker.
+type fakeDialer struct {
+	mu    sync.Mutex
+	conns []*fakePubConnection
+	down  bool
+}
+
+func (d *fakeDialer) dial(string) (amqpConnection, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.down {
+		return nil, errors.New("connection refused")
+	}
+	c := &fakePubConnection{}
+	d.conns = append(d.conns, c)
+	return c, nil
+}
+
+func (d *fakeDialer) setDown(v bool) {
+	d.mu.Lock()
+	d.down = v
+	d.mu.Unlock()
+}
+
+func (d *fakeDialer) conn(i int) *fakePubConnection {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conns[i]
+}
+
+func (d *fakeDialer) dials() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.conns)
+}
+
+func newTestPublisher(t *testing.T, bufferSize int) (*RabbitMQPublisher, *fakeDialer) {
+	t.Helper()
+	d := &fakeDialer{}
+	p, err := newPublisher(&config.Config{PublisherBufferSize: bufferSize}, d.dial)
+	require.NoError(t, err)
+	p.reconnectDelay = time.Millisecond
+	t.Cleanup(func() { p.Close() })
+	return p, d
+}
+
+var connectionForced = &amqp.Error{Code: amqp.ConnectionForced, Reason: "broker shutdown"}
+
+func TestPublisherReconnects(t *testing.T) {
+	p, d := newTestPublisher(t, 0)
+
+	_, err := p.SendGoTask("logger", nil, "go.logger", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, d.conn(0).channel(0).count())
+
+	d.conn(0).drop(connectionForced)
+	require.Eventually(t, func() bool { return d.dials() == 2 }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		_, err := p.SendGoTask("logger", nil, "go.logger", nil)
+		return err == nil
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 1, d.conn(1).channel(0).count())
+}
+
+func TestPublisherWithoutBufferFailsWhileDisconnected(t *testing.T) {
+	p, d := newTestPublisher(t, 0)
+	d.setDown(true)
+	d.conn(0).drop(connectionForced)
+
+	require.Eventually(t, func() bool {
+		_, err := p.SendGoTask("logger", nil, "go.logger", nil)
+		return errors.Is(err, ErrNotConnected)
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 0, p.Buffered())
+}
+
+func TestPublisherBuffersDuringOutage(t *testing.T) {
+	p, d := newTestPublisher(t, 2)
+	d.setDown(true)
+	d.conn(0).drop(connectionForced)
+
+	// The publish that hits the dead channel is buffered as well
+	id1, err := p.SendGoTask("logger", map[string]interface{}{"n": 1}, "go.logger", nil)
+	require.NoError(t, err)
+	id2, err := p.SendCeleryTask("tasks.add", []interface{}{1, 2}, "celery")
+	require.NoError(t, err)
+	assert.NotEmpty(t, id1)
+	assert.NotEmpty(t, id2)
+	assert.Equal(t, 2, p.Buffered())
+
+	_, err = p.SendGoTask("logger", nil, "go.logger", nil)
+	assert.ErrorIs(t, err, ErrBufferFull)
+
+	d.setDown(false)
+	require.Eventually(t, func() bool { return p.Buffered() == 0 }, time.Second, time.Millisecond)
+
+	ch := d.conn(1).channel(0)
+	require.Equal(t, 2, ch.count())
+	assert.Equal(t, "", ch.published[0].exchange)
+	assert.Equal(t, "go.logger", ch.published[0].queue)
+	assert.Equal(t, "celery", ch.published[1].exchange)
+	assert.Equal(t, id2, ch.published[1].msg.Headers["id"])
+}
+
+func TestPublisherSendsWaitForFlush(t *testing.T) {
+	p, d := newTestPublisher(t, 10)
+
+	// A reconnect starts flushing the backlog
+	p.mu.Lock()
+	p.buffer = append(p.buffer, outgoing{queue: "go.logger", msg: amqp.Publishing{MessageId: "buffered"}})
+	require.True(t, p.startFlushLocked())
+	p.mu.Unlock()
+
+	sent := make(chan error, 1)
+	go func() {
+		_, err := p.SendGoTask("logger", nil, "go.logger", nil)
+		sent <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 0, d.conn(0).published(), "the send must not overtake the backlog")
+
+	assert.Equal(t, 1, p.flush())
+	require.NoError(t, <-sent)
+	ch := d.conn(0).channel(0)
+	require.Equal(t, 2, ch.count())
+	assert.Equal(t, "buffered", ch.published[0].msg.MessageId)
+	assert.Equal(t, 0, p.Buffered())
+}
+
+func TestPublisherReopensClosedChannel(t *testing.T) {
+	p, d := newTestPublisher(t, 10)
+	ch := d.conn(0).channel(0)
+	ch.set(func(c *fakePubChannel) {
+		c.err = &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no exchange 'celery'"}
+	})
+
+	// A channel error is reported, not buffered
+	_, err := p.SendCeleryTask("tasks.add", nil, "celery")
+	require.Error(t, err)
+	assert.Equal(t, 0, p.Buffered())
+	assert.True(t, ch.isClosed())
+
+	_, err = p.SendGoTask("logger", nil, "go.logger", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, d.conn(0).channel(1).count())
+}
+
+func TestPublisherCloseStopsReconnect(t *testing.T) {
+	p, d := newTestPublisher(t, 10)
+	d.setDown(true)
+	require.NoError(t, p.Close())
+	require.NoError(t, p.Close())
+
+	_, err := p.SendGoTask("logger", nil, "go.logger", nil)
+	assert.ErrorIs(t, err, ErrPublisherClosed)
+
+	d.setDown(false)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, d.dials())
+}
+
+func TestPublisherWaitsForConfirms(t *testing.T) {
+	p, d := newTestPublisher(t, 10)
+	ch := d.conn(0).channel(0)
+
+	taskID, err := p.SendCeleryTask("tasks.add", nil, "python.math")
+	require.NoError(t, err)
+	require.Equal(t, 1, ch.count())
+	assert.Equal(t, taskID, ch.published[0].msg.MessageId)
+	assert.Equal(t, []string{"celery/python.math->python.math"}, ch.bindings)
+
+	// Nacks and returns are reported, not buffered
+	ch.set(func(c *fakePubChannel) { c.nack = true })
+	_, err = p.SendGoTask("logger", nil, "go.logger", nil)
+	assert.ErrorIs(t, err, ErrNacked)
+
+	ch.set(func(c *fakePubChannel) { c.nack, c.unroutable = false, true })
+	_, err = p.SendGoTask("logger", nil, "go.logger", nil)
+	assert.ErrorIs(t, err, ErrUnroutable)
+	assert.Contains(t, err.Error(), "NO_ROUTE")
+	assert.Equal(t, 0, p.Buffered())
+
+	ch.set(func(c *fakePubChannel) { c.unroutable = false })
+	_, err = p.SendGoTask("logger", nil, "go.logger", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, ch.count())
+}
+
+func TestPublisherConfirmTimeout(t *testing.T) {
+	d := &fakeDialer{}
+	p, err := newPublisher(&config.Config{PublisherConfirmTimeoutSeconds: 1}, d.dial)
+	require.NoError(t, err)
+	defer p.Close()
+
+	ch := d.conn(0).channel(0)
+	ch.set(func(c *fakePubChannel) { c.noConfirm = true })
+	_, err = p.SendGoTask("logger", nil, "go.logger", nil)
+	assert.ErrorIs(t, err, ErrConfirmTimeout)
+
+	// The channel is replaced so a late confirm cannot be misattributed
+	assert.True(t, ch.isClosed())
+	_, err = p.SendGoTask("logger", nil, "go.logger", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, d.conn(0).channel(1).count())
+}
+
+func TestPublisherRetriesBatchOnFreshChannel(t *testing.T) {
+	d := &fakeDialer{}
+	p, err := newPublisher(&config.Config{PublisherConfirmTimeoutSeconds: 1, PublisherBufferSize: 100}, d.dial)
+	require.NoError(t, err)
+	defer p.Close()
+
+	ch := d.conn(0).channel(0)
+	ch.set(func(c *fakePubChannel) { c.noConfirm = true })
+	tasks := make([]BatchTask, confirmBuffer+10)
+	for i := range tasks {
+		tasks[i] = BatchTask{Task: "logger", Queue: "go.logger"}
+	}
+	results := p.SendGoTasks(tasks)
+
+	// The first window timed out; the rest went out on a fresh channel
+	// instead of waiting in the buffer for a reconnect that never comes
+	for i, r := range results {
+		if i < confirmBuffer {
+			assert.ErrorIs(t, r.Err, ErrConfirmTimeout, i)
+		} else {
+			assert.NoError(t, r.Err, i)
+		}
+	}
+	assert.Equal(t, 0, p.Buffered())
+	assert.Equal(t, 10, d.conn(0).channel(1).count())
+}
+
+func TestPublisherDoesNotBufferWhileConnected(t *testing.T) {
+	p, d := newTestPublisher(t, 10)
+	conn := d.conn(0)
+	conn.mu.Lock()
+	conn.publishErr = amqp.ErrClosed
+	conn.mu.Unlock()
+	conn.channel(0).set(func(c *fakePubChannel) { c.err = amqp.ErrClosed })
+
+	_, err := p.SendGoTask("logger", nil, "go.logger", nil)
+	assert.ErrorIs(t, err, ErrChannelClosed)
+	assert.Equal(t, 0, p.Buffered())
+	assert.Equal(t, 2, conn.channelCount())
+}
+
+func TestPublisherToleratesExistingQueueArguments(t *testing.T) {
+	p, d := newTestPublisher(t, 0)
+	ch := d.conn(0).channel(0)
+	ch.set(func(c *fakePubChannel) {
+		c.declareErr = &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - inequivalent arg 'x-dead-letter-exchange'"}
+	})
+
+	_, err := p.SendGoTask("logger", nil, "go.logger", nil)
+	require.NoError(t, err)
+	assert.True(t, ch.isClosed())
+	assert.Equal(t, 1, d.conn(0).channel(1).count())
+}
+
+func TestPublisherDeclaresQueueArguments(t *testing.T) {
+	d := &fakeDialer{}
+	cfg := &config.Config{Queues: []config.QueueConfig{{Name: "emails", DeadLetterExchange: "mail.dlx"}}}
+	p, err := newPublisher(cfg, d.dial)
+	require.NoError(t, err)
+	t.Cleanup(func() { p.Close() })
+
+	// The worker's arguments for the queues it consumes only
+	_, err = p.SendGoTask("logger", nil, "emails", nil)
+	require.NoError(t, err)
+	_, err = p.SendGoTask("logger", nil, "go.other", nil)
+	require.NoError(t, err)
+	ch := d.conn(0).channel(0)
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	assert.Equal(t, amqp.Table{"x-dead-letter-exchange": "mail.dlx", "x-dead-letter-routing-key": "emails"}, ch.queues["emails"])
+	assert.Contains(t, ch.queues, "go.other")
+	assert.Nil(t, ch.queues["go.other"])
+}
+
+func TestPublisherDeclaresCeleryQueuesWithoutArguments(t *testing.T) {
+	d := &fakeDialer{}
+	// Even a queue the worker consumes: Python workers declare it plainly
+	cfg := &config.Config{Queues: []config.QueueConfig{{Name: "emails"}}}
+	p, err := newPublisher(cfg, d.dial)
+	require.NoError(t, err)
+	t.Cleanup(func() { p.Close() })
+
+	_, err = p.SendCeleryTask("send_email", nil, "emails")
+	require.NoError(t, err)
+	_, err = p.SendCeleryTask("cleanup", nil, "")
+	require.NoError(t, err)
+	ch := d.conn(0).channel(0)
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for _, queue := range []string{"emails", "celery"} {
+		assert.Contains(t, ch.queues, queue)
+		assert.Nil(t, ch.queues[queue], queue)
+	}
+}
+
+func TestPublisherConcurrentSends(t *testing.T) {
+	d := &fakeDialer{}
+	p, err := newPublisher(&config.Config{PublisherConnections: 2, PublisherChannels: 4}, d.dial)
+	require.NoError(t, err)
+	defer p.Close()
+	d.conn(0).channel(0).set(func(c *fakePubChannel) { c.confirmDelay = 5 * time.Millisecond })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := p.SendGoTask("logger", map[string]interface{}{"n": i}, "go.logger", nil)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 50, d.conn(0).published()+d.conn(1).published())
+	channels := d.conn(0).channelCount() + d.conn(1).channelCount()
+	assert.LessOrEqual(t, channels, 4)
+	assert.Greater(t, d.conn(1).channelCount(), 0, "new channels are spread over the connections")
+}
+
+func TestPublisherSurvivesOneConnectionDown(t *testing.T) {
+	d := &fakeDialer{}
+	p, err := newPublisher(&config.Config{PublisherConnections: 2, PublisherBufferSize: 10}, d.dial)
+	require.NoError(t, err)
+	p.reconnectDelay = time.Hour
+	defer p.Close()
+
+	d.conn(0).drop(connectionForced)
+	require.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.conns[0].conn == nil
+	}, time.Second, time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		_, err := p.SendGoTask("logger", nil, "go.logger", nil)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 0, p.Buffered())
+	assert.Equal(t, 3, d.conn(1).published())
+}
+
+func TestSendGoTasks(t *testing.T) {
+	p, d := newTestPublisher(t, 0)
+	ch := d.conn(0).channel(0)
+	ch.set(func(c *fakePubChannel) { c.unroutableQueue = "go.missing" })
+
+	results := p.SendGoTasks([]BatchTask{
+		{Task: "logger", Queue: "go.logger"},
+		{Task: "", Queue: "go.logger"},
+		{Task: "send_email", Queue: "go.missing"},
+		{Task: "logger", Queue: "go.logger", Payload: map[string]interface{}{"n": 2}},
+	})
+	require.Len(t, results, 4)
+
+	assert.NoError(t, results[0].Err)
+	assert.NotEmpty(t, results[0].TaskID)
+	assert.Error(t, results[1].Err)
+	assert.Empty(t, results[1].TaskID)
+	assert.ErrorIs(t, results[2].Err, ErrUnroutable)
+	assert.Empty(t, results[2].TaskID)
+	assert.NoError(t, results[3].Err)
+
+	require.Equal(t, 2, ch.count())
+	assert.Equal(t, results[0].TaskID, ch.published[0].msg.MessageId)
+	assert.Equal(t, results[3].TaskID, ch.published[1].msg.MessageId)
+	// One declaration per queue
+	assert.Equal(t, 2, ch.declares)
+
+	// Declarations are cached, except for the queue whose message was returned
+	results = p.SendGoTasks([]BatchTask{{Task: "logger", Queue: "go.logger"}, {Task: "x", Queue: "go.missing"}})
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[1].Err, ErrUnroutable)
+	assert.Equal(t, 3, ch.declares)
+}
+
+func TestSendGoTasksPipelinesConfirms(t *testing.T) {
+	p, d := newTestPublisher(t, 0)
+	ch := d.conn(0).channel(0)
+
+	// More than one confirm window
+	tasks := make([]BatchTask, 2*confirmBuffer+10)
+	for i := range tasks {
+		tasks[i] = BatchTask{Task: "logger", Queue: "go.logger", Payload: map[string]interface{}{"n": i}}
+	}
+	for i, r := range p.SendGoTasks(tasks) {
+		require.NoError(t, r.Err, i)
+	}
+	assert.Equal(t, len(tasks), ch.count())
+	assert.Equal(t, 1, ch.declares)
+
+	// Confirms are awaited together, not one publish at a time
+	ch.set(func(c *fakePubChannel) { c.confirmDelay = 20 * time.Millisecond })
+	start := time.Now()
+	for _, r := range p.SendGoTasks(tasks[:20]) {
+		require.NoError(t, r.Err)
+	}
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestSendGoTasksBuffersDuringOutage(t *testing.T) {
+	p, d := newTestPublisher(t, 1)
+	d.setDown(true)
+	d.conn(0).drop(connectionForced)
+
+	results := p.SendGoTasks([]BatchTask{{Task: "a", Queue: "q"}, {Task: "b", Queue: "q"}})
+	assert.NoError(t, results[0].Err)
+	assert.NotEmpty(t, results[0].TaskID)
+	assert.ErrorIs(t, results[1].Err, ErrBufferFull)
+	assert.Equal(t, 1, p.Buffered())
+}
+
+func TestSendGoTaskDelayed(t *testing.T) {
+	t.Run("ttl", func(t *testing.T) {
+		p, d := newTestPublisher(t, 0)
+		ch := d.conn(0).channel(0)
+
+		_, err := p.SendGoTask("logger", nil, "go.logger", &TaskOptions{Countdown: 100 * time.Second})
+		require.NoError(t, err)
+		require.Equal(t, 1, ch.count())
+		// ~100s rounded down to a power-of-two wait queue
+		assert.Equal(t, "", ch.published[0].exchange)
+		assert.Equal(t, "go.logger.delay.64000ms", ch.published[0].queue)
+		require.Contains(t, ch.queues, "go.logger")
+		args := ch.queues["go.logger.delay.64000ms"]
+		assert.Equal(t, int64(64000), args["x-message-ttl"])
+		assert.Equal(t, "", args["x-dead-letter-exchange"])
+		assert.Equal(t, "go.logger", args["x-dead-letter-routing-key"])
+
+		// Wait queues are redeclared so they do not expire
+		_, err = p.SendGoTask("logger", nil, "go.logger", &TaskOptions{Countdown: 100 * time.Second})
+		require.NoError(t, err)
+		assert.Equal(t, 3, ch.declares)
+	})
+
+	t.Run("plugin", func(t *testing.T) {
+		d := &fakeDialer{}
+		p, err := newPublisher(&config.Config{RetryDelayMode: config.RetryDelayPlugin}, d.dial)
+		require.NoError(t, err)
+		t.Cleanup(func() { p.Close() })
+		ch := d.conn(0).channel(0)
+		// The delayed-message exchange returns mandatory messages
+		ch.set(func(c *fakePubChannel) { c.unroutableQueue = "go.logger" })
+
+		at := time.Now().Add(90 * time.Second)
+		_, err = p.SendGoTask("logger", nil, "go.logger", &TaskOptions{ETA: &at})
+		require.NoError(t, err)
+		require.Equal(t, 1, ch.count())
+		assert.Equal(t, delayedExchange, ch.published[0].exchange)
+		assert.Equal(t, "go.logger", ch.published[0].queue)
+		assert.InDelta(t, 90000, ch.published[0].msg.Headers["x-delay"], 2000)
+		assert.Contains(t, ch.bindings, "celery.delayed/go.logger->go.logger")
+	})
+
+	t.Run("due", func(t *testing.T) {
+		p, d := newTestPublisher(t, 0)
+		ch := d.conn(0).channel(0)
+
+		at := time.Now().Add(-time.Minute)
+		_, err := p.SendGoTask("logger", nil, "go.logger", &TaskOptions{ETA: &at})
+		require.NoError(t, err)
+		require.Equal(t, 1, ch.count())
+		assert.Equal(t, "go.logger", ch.published[0].queue)
+		assert.NotContains(t, ch.published[0].msg.Headers, "x-delay")
+	})
+}
@@ -0,0 +1,105 @@
+package publisher
+
+import (
+	"fmt"
+	"time"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/tasks"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// delayedExchange is the x-delayed-message exchange used in
+// config.RetryDelayPlugin mode; it is the one the worker declares for the
+// default "celery" exchange, so both sides share it.
+const delayedExchange = "celery.delayed"
+
+// delayQueueName returns the TTL wait queue holding messages for queue
+// that are due in delay.
+func delayQueueName(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.delay.%dms", queue, delay.Milliseconds())
+}
+
+// delayQueueArgs dead-letters expired messages of the wait queue to queue
+// through exchange. Idle wait queues expire so one-off delays do not leave
+// queues behind.
+func delayQueueArgs(queue, exchange string, delay time.Duration) amqp.Table {
+	ttl := delay.Milliseconds()
+	return amqp.Table{
+		"x-message-ttl":             ttl,
+		"x-dead-letter-exchange":    exchange,
+		"x-dead-letter-routing-key": queue,
+		"x-expires":                 2*ttl + time.Minute.Milliseconds(),
+	}
+}
+
+// withDelay makes m reach its queue only at m.eta, using a TTL wait queue or
+// the delayed-message exchange depending on the retry delay mode. It runs
+// right before publishing, so messages buffered during an outage are not
+// delayed for longer than needed. Wait queues only exist for power-of-two
+// seconds, so a message may arrive early; the worker then delays it again
+// until its ETA.
+func (p *RabbitMQPublisher) withDelay(m outgoing) outgoing {
+	m.waitQueue, m.waitArgs, m.delayed = "", nil, false
+	if m.eta.IsZero() {
+		return m
+	}
+	delay := time.Until(m.eta)
+	if delay <= 0 {
+		return m
+	}
+	if p.config != nil && p.config.GetRetryDelayMode() == config.RetryDelayPlugin {
+		headers := amqp.Table{}
+		for k, v := range m.msg.Headers {
+			headers[k] = v
+		}
+		// Round up so the message is never released before its ETA
+		headers["x-delay"] = int64((delay + time.Millisecond - 1) / time.Millisecond)
+		m.msg.Headers = headers
+		m.delayed = true
+		return m
+	}
+
+	step := tasks.DelayStep(delay)
+	m.waitQueue = delayQueueName(m.queue, step)
+	m.waitArgs = delayQueueArgs(m.queue, m.exchange, step)
+	return m
+}
+
+// declareDelay declares what a delayed m needs on top of its route: the
+// wait queue, or the delayed exchange bound to the queue.
+func declareDelay(ch amqpChannel, m outgoing) error {
+	if m.waitQueue != "" {
+		if _, err := ch.QueueDeclare(
+			m.waitQueue, // name
+			true,        // durable
+			false,       // delete when unused
+			false,       // exclusive
+			false,       // no-wait
+			m.waitArgs,  // arguments
+		); err != nil {
+			return fmt.Errorf("failed to declare delay queue %s: %w", m.waitQueue, err)
+		}
+		return nil
+	}
+	if !m.delayed {
+		return nil
+	}
+
+	if err := ch.ExchangeDeclare(
+		delayedExchange,     // name
+		"x-delayed-message", // type
+		true,                // durable
+		false,               // auto-deleted
+		false,               // internal
+		false,               // no-wait
+		amqp.Table{"x-delayed-type": "direct"},
+	); err != nil {
+		return fmt.Errorf("failed to declare delayed exchange (is rabbitmq_delayed_message_exchange enabled?): %w", err)
+	}
+	if err := ch.QueueBind(m.queue, m.queue, delayedExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue to delayed exchange: %w", err)
+	}
+	return nil
+}
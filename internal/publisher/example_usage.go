@@ -8,6 +8,7 @@ import (
     "log"
     "base-go-app/internal/config"
     "base-go-app/internal/publisher"
+    "base-go-app/internal/tasks"
 )
 
 func ExampleUsage() {
@@ -75,9 +76,8 @@ func ExampleUsage() {
     maxAttempts := 3
     options := &publisher.TaskOptions{
         TimeoutSeconds: &timeout,
-        Notify: map[string]string{
-            "webhook": "http://example.com/callback",
-            "email":   "admin@example.com",
+        Notify: &tasks.NotifyConfig{
+            Webhook: &tasks.WebhookConfig{URL: "http://example.com/callback"},
         },
         MaxAttempts: &maxAttempts,
     }
@@ -1,6 +1,16 @@
 package publisher
 
-// Publisher defines the interface for publishing tasks to RabbitMQ
+import (
+	"context"
+	"time"
+
+	"base-go-app/internal/tasks"
+)
+
+// Publisher defines the interface for publishing tasks to RabbitMQ.
+// A send only succeeds once the broker has confirmed the message; failures
+// can be told apart with errors.Is against ErrNacked, ErrUnroutable,
+// ErrConfirmTimeout, ErrNotConnected, ErrBufferFull and ErrPublisherClosed.
 type Publisher interface {
 	// SendCeleryTask sends a task in Celery protocol v2 format (Python workers)
 	// task: task name (e.g., "celery_test_task")
@@ -8,6 +18,11 @@ type Publisher interface {
 	// queue: RabbitMQ queue name (default: "celery")
 	SendCeleryTask(task string, args []interface{}, queue string) (string, error)
 
+	// SendCeleryTaskWithOptions sends a Celery protocol v2 task with
+	// kwargs, countdown/ETA, expiry, callbacks and chains
+	// options: optional Celery options (nil behaves like SendCeleryTask)
+	SendCeleryTaskWithOptions(task string, args []interface{}, queue string, options *CeleryTaskOptions) (string, error)
+
 	// SendGoTask sends a task in Go worker format
 	// task: task name (e.g., "logger")
 	// payload: map of task payload data
@@ -15,13 +30,59 @@ type Publisher interface {
 	// options: optional task options (timeout, notify, etc.)
 	SendGoTask(task string, payload map[string]interface{}, queue string, options *TaskOptions) (string, error)
 
+	// SendGoTasks sends many Go tasks at once with pipelined confirms
+	// tasks: the tasks to send, possibly to different queues
+	// Returns one result (task ID or error) per task, in order
+	SendGoTasks(tasks []BatchTask) []BatchResult
+
+	// SendChain sends steps to run one after another, each receiving the
+	// result of the previous one; returns the ID of the first task
+	SendChain(steps []BatchTask) (string, error)
+
+	// SendGroup sends members to run in parallel as a group tracked in the
+	// workflow store; returns the group ID
+	SendGroup(members []BatchTask) (string, error)
+
+	// SendChord sends a group whose callback runs with the member results
+	// once all members succeeded; returns the group ID
+	SendChord(members []BatchTask, callback BatchTask) (string, error)
+
 	// Close closes the RabbitMQ connection
 	Close() error
 }
 
+// BatchTask is one task of a SendGoTasks batch; the fields match the
+// arguments of SendGoTask.
+type BatchTask struct {
+	Task    string
+	Payload map[string]interface{}
+	Queue   string
+	Options *TaskOptions
+}
+
+// BatchResult is the outcome of one BatchTask: its task ID, or the error
+// that kept it from being enqueued.
+type BatchResult struct {
+	TaskID string
+	Err    error
+}
+
 // TaskOptions contains optional parameters for Go tasks
 type TaskOptions struct {
-	TimeoutSeconds *int               `json:"timeout_seconds,omitempty"`
-	Notify         map[string]string  `json:"notify,omitempty"`
-	MaxAttempts    *int               `json:"max_attempts,omitempty"`
+	TimeoutSeconds *int `json:"timeout_seconds,omitempty"`
+	MaxAttempts    *int `json:"max_attempts,omitempty"`
+	// Notify configures the Sockudo and/or webhook completion notification
+	Notify *tasks.NotifyConfig `json:"notify,omitempty"`
+	// IdempotencyKey makes the worker run the task at most once per key
+	// (requires IDEMPOTENCY_STORE on the worker)
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Meta is any JSON-encodable data carried along with the task
+	Meta interface{} `json:"meta,omitempty"`
+	// Countdown delays execution by the given duration. Ignored if ETA is set.
+	Countdown time.Duration `json:"countdown,omitempty"`
+	// ETA is the earliest time the task may run. The message stays on the
+	// broker until then, and workers re-delay it if it arrives early.
+	ETA *time.Time `json:"eta,omitempty"`
+	// Context carries the caller's trace, which the worker continues
+	Context context.Context `json:"-"`
 }
@@ -0,0 +1,99 @@
+package publisher
+
+import (
+	"fmt"
+)
+
+// pooledConnection is one of the publisher's connections. conn is nil while
+// it is reconnecting.
+type pooledConnection struct {
+	conn amqpConnection
+}
+
+// acquire reserves a channel for exclusive use until release. At most
+// cap(p.slots) channels are in use at a time; callers beyond that wait.
+func (p *RabbitMQPublisher) acquire() (*confirmChannel, error) {
+	if p.slots == nil {
+		return nil, ErrNotConnected
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.done:
+		return nil, ErrPublisherClosed
+	}
+
+	ch, err := p.takeChannel()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return ch, nil
+}
+
+// takeChannel returns an idle channel or opens one on the next live
+// connection, round-robin.
+func (p *RabbitMQPublisher) takeChannel() (*confirmChannel, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrPublisherClosed
+	}
+	for len(p.idle) > 0 {
+		ch := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.liveLocked(ch.conn) {
+			return ch, nil
+		}
+		ch.ch.Close()
+	}
+
+	var lastErr error
+	for range p.conns {
+		pc := p.conns[p.next%len(p.conns)]
+		p.next++
+		if pc.conn == nil {
+			continue
+		}
+		ch, err := openConfirmChannel(pc.conn)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return ch, nil
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotConnected, lastErr)
+	}
+	return nil, ErrNotConnected
+}
+
+// release returns ch to the pool, or closes it if it is no longer usable.
+func (p *RabbitMQPublisher) release(ch *confirmChannel, healthy bool) {
+	p.mu.Lock()
+	if healthy && !p.closed && p.liveLocked(ch.conn) {
+		p.idle = append(p.idle, ch)
+	} else {
+		ch.ch.Close()
+	}
+	p.mu.Unlock()
+	<-p.slots
+}
+
+// connLive reports whether conn is one of the current connections.
+func (p *RabbitMQPublisher) connLive(conn amqpConnection) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.liveLocked(conn)
+}
+
+// liveLocked reports whether conn is one of the current connections.
+// p.mu must be held.
+func (p *RabbitMQPublisher) liveLocked(conn amqpConnection) bool {
+	for _, pc := range p.conns {
+		if pc.conn != nil && pc.conn == conn {
+			return true
+		}
+	}
+	return false
+}
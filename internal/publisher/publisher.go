@@ -1,119 +1,136 @@
 package publisher
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
 	"time"
 
 	"base-go-app/internal/config"
+	"base-go-app/internal/logging"
+	"base-go-app/internal/results"
+	"base-go-app/internal/tasks"
+	"base-go-app/internal/workflow"
 
 	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// RabbitMQPublisher implements the Publisher interface
+// RabbitMQPublisher implements the Publisher interface. It is safe for
+// concurrent use: each send borrows a channel from a pool spread over one or
+// more connections (config.PublisherConnections and PublisherChannels).
+// Connections are re-established with backoff when they drop and, if
+// config.PublisherBufferSize is set, messages are buffered in memory during
+// the outage and flushed once reconnected.
 type RabbitMQPublisher struct {
-	conn   *amqp.Connection
-	ch     *amqp.Channel
-	config *config.Config
+	config  *config.Config
+	results results.Backend
+	dial    func(url string) (amqpConnection, error)
+
+	// reconnectDelay overrides the initial reconnect backoff (tests)
+	reconnectDelay time.Duration
+
+	// slots bounds the channels in use at a time
+	slots chan struct{}
+	done  chan struct{}
+
+	mu         sync.Mutex
+	conns      []*pooledConnection
+	idle       []*confirmChannel
+	next       int
+	closed     bool
+	bufferSize int
+	buffer     []outgoing
+	// flushed is closed once the running flush of the buffer is over; nil
+	// when none is running
+	flushed chan struct{}
+	// routes caches queue and exchange declarations ("exchange/queue")
+	routes map[string]bool
+
+	workflows workflow.Store
 }
 
 // NewPublisher creates a new RabbitMQ publisher
 func NewPublisher(cfg *config.Config) (*RabbitMQPublisher, error) {
+	return newPublisher(cfg, dialAMQP)
+}
+
+func newPublisher(cfg *config.Config, dial func(url string) (amqpConnection, error)) (*RabbitMQPublisher, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("config cannot be nil")
 	}
 
-	conn, err := amqp.Dial(cfg.GetRabbitMQURL())
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	p := &RabbitMQPublisher{
+		config:     cfg,
+		dial:       dial,
+		bufferSize: cfg.PublisherBufferSize,
+		slots:      make(chan struct{}, cfg.GetPublisherChannels()),
+		done:       make(chan struct{}),
 	}
 
-	ch, err := conn.Channel()
+	conns := make([]amqpConnection, 0, cfg.GetPublisherConnections())
+	for i := 0; i < cfg.GetPublisherConnections(); i++ {
+		conn, err := dial(cfg.GetRabbitMQURL())
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		}
+		conns = append(conns, conn)
+		p.conns = append(p.conns, &pooledConnection{conn: conn})
+	}
+
+	// Open the first channel up front so misconfiguration fails fast
+	ch, err := openConfirmChannel(conns[0])
 	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("failed to open channel: %w", err)
+		for _, c := range conns {
+			c.Close()
+		}
+		return nil, err
 	}
+	p.idle = append(p.idle, ch)
+	p.next = 1
 
-	return &RabbitMQPublisher{
-		conn:   conn,
-		ch:     ch,
-		config: cfg,
-	}, nil
+	for i, conn := range conns {
+		go p.watch(p.conns[i], conn, conn.NotifyClose(make(chan *amqp.Error, 1)))
+	}
+
+	return p, nil
 }
 
 // SendCeleryTask sends a task in Celery protocol v2 format (for Python workers)
 // This matches the Laravel CeleryFunction trait behavior
 func (p *RabbitMQPublisher) SendCeleryTask(task string, args []interface{}, queue string) (string, error) {
+	return p.SendCeleryTaskWithOptions(task, args, queue, nil)
+}
+
+// SendCeleryTaskWithOptions sends a Celery protocol v2 task with kwargs,
+// scheduling, expiry, callbacks, chains and the full Celery header set
+func (p *RabbitMQPublisher) SendCeleryTaskWithOptions(task string, args []interface{}, queue string, options *CeleryTaskOptions) (string, error) {
 	if task == "" {
 		return "", fmt.Errorf("task name is required")
 	}
-	if args == nil {
-		args = []interface{}{}
-	}
 	if queue == "" {
 		queue = "celery"
 	}
 
-	// Generate task ID
-	taskID := uuid.New().String()
-
-	// Declare queue (durable)
-	_, err := p.ch.QueueDeclare(
-		queue, // name
-		true,  // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // no-wait
-		nil,   // arguments
-	)
-	if err != nil {
-		return "", fmt.Errorf("failed to declare queue: %w", err)
-	}
-
-	// Generate Celery Payload Message Protocol v2
-	// Format: [[args...], {kwargs}, {metadata}]
-	body := []interface{}{
-		args,
-		map[string]interface{}{}, // empty kwargs
-		map[string]interface{}{   // metadata
-			"callbacks": nil,
-			"errbacks":  nil,
-			"chain":     nil,
-			"chord":     nil,
-		},
-	}
-
-	bodyBytes, err := json.Marshal(body)
+	taskID, msg, err := buildCeleryMessage(task, args, options)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal message body: %w", err)
+		return "", err
 	}
 
-	// Prepare message with Celery headers
-	msg := amqp.Publishing{
-		ContentType:     "application/json",
-		ContentEncoding: "utf-8",
-		DeliveryMode:    amqp.Persistent,
-		Body:            bodyBytes,
-		Headers: amqp.Table{
-			"lang":    "py",
-			"task":    task,
-			"id":      taskID,
-			"root_id": taskID,
-		},
-		CorrelationId: taskID,
+	var ctx context.Context
+	if options != nil {
+		ctx = options.Context
 	}
 
 	// Publish to exchange "celery" with routing key = queue
-	err = p.ch.Publish(
-		"celery", // exchange
-		queue,    // routing key
-		false,    // mandatory
-		false,    // immediate
-		msg,
-	)
-	if err != nil {
-		return "", fmt.Errorf("failed to publish message: %w", err)
+	if err := p.send(traced(ctx, outgoing{queue: queue, exchange: "celery", msg: msg, celery: true}, task)); err != nil {
+		return "", err
 	}
 
 	return taskID, nil
@@ -122,59 +139,138 @@ func (p *RabbitMQPublisher) SendCeleryTask(task string, args []interface{}, queu
 // SendGoTask sends a task in Go worker format
 // This matches the Laravel GoWorkerFunction trait behavior
 func (p *RabbitMQPublisher) SendGoTask(task string, payload map[string]interface{}, queue string, options *TaskOptions) (string, error) {
+	taskID, m, err := buildGoMessage(task, payload, queue, options)
+	if err != nil {
+		return "", err
+	}
+
+	// Publish to default exchange (direct to queue), delayed until its ETA
+	if err := p.sendAll([]outgoing{m})[0]; err != nil {
+		return "", err
+	}
+
+	p.recordPending(taskID, task)
+
+	return taskID, nil
+}
+
+// SendGoTasks sends many Go tasks at once. Messages are pipelined on one
+// channel and confirmed together, and queue declarations are cached, so
+// this is much faster than calling SendGoTask in a loop. The results are
+// in the order of tasks; a failed item does not stop the others.
+func (p *RabbitMQPublisher) SendGoTasks(tasks []BatchTask) []BatchResult {
+	results := make([]BatchResult, len(tasks))
+	msgs := make([]outgoing, 0, len(tasks))
+	index := make([]int, 0, len(tasks))
+	for i, t := range tasks {
+		taskID, m, err := buildGoMessage(t.Task, t.Payload, t.Queue, t.Options)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].TaskID = taskID
+		msgs = append(msgs, m)
+		index = append(index, i)
+	}
+	if len(msgs) == 0 {
+		return results
+	}
+
+	for j, err := range p.sendAll(msgs) {
+		i := index[j]
+		if err != nil {
+			results[i] = BatchResult{Err: err}
+			continue
+		}
+		p.recordPending(results[i].TaskID, tasks[i].Task)
+	}
+	return results
+}
+
+// buildGoMessage builds a Go worker envelope and returns it with its task ID,
+// ready to be published to the default exchange.
+func buildGoMessage(task string, payload map[string]interface{}, queue string, options *TaskOptions) (string, outgoing, error) {
+	envelope, err := buildGoEnvelope(task, payload, options)
+	if err != nil {
+		return "", outgoing{}, err
+	}
+	m, err := goMessage(envelope, queue)
+	if err != nil {
+		return "", outgoing{}, err
+	}
+	return envelope.ID, traced(taskContext(options), m, task), nil
+}
+
+// buildGoEnvelope builds the Go worker envelope of a task.
+func buildGoEnvelope(task string, payload map[string]interface{}, options *TaskOptions) (tasks.TaskPayload, error) {
 	if task == "" {
-		return "", fmt.Errorf("task name is required")
+		return tasks.TaskPayload{}, fmt.Errorf("task name is required")
 	}
 	if payload == nil {
 		payload = map[string]interface{}{}
 	}
-	if queue == "" {
-		queue = "celery"
-	}
 
-	// Generate task ID
-	taskID := uuid.New().String()
-
-	// Declare queue (durable)
-	_, err := p.ch.QueueDeclare(
-		queue, // name
-		true,  // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // no-wait
-		nil,   // arguments
-	)
+	payloadJSON, err := json.Marshal(payload)
 	if err != nil {
-		return "", fmt.Errorf("failed to declare queue: %w", err)
+		return tasks.TaskPayload{}, fmt.Errorf("failed to marshal task payload: %w", err)
 	}
 
-	// Build task payload
-	taskPayload := map[string]interface{}{
-		"version":      "1.0",
-		"id":           taskID,
-		"task":         task,
-		"payload":      payload,
-		"created_at":   time.Now().Format(time.RFC3339),
-		"attempt":      0,
-		"max_attempts": 5,
+	envelope := tasks.TaskPayload{
+		Version:     tasks.EnvelopeVersion,
+		ID:          uuid.New().String(),
+		Task:        task,
+		Payload:     payloadJSON,
+		CreatedAt:   time.Now().Format(time.RFC3339),
+		Attempt:     0,
+		MaxAttempts: tasks.DefaultMaxAttempts,
 	}
 
 	// Apply options if provided
 	if options != nil {
 		if options.TimeoutSeconds != nil {
-			taskPayload["timeout_seconds"] = *options.TimeoutSeconds
-		}
-		if options.Notify != nil {
-			taskPayload["notify"] = options.Notify
+			envelope.TimeoutSeconds = *options.TimeoutSeconds
 		}
 		if options.MaxAttempts != nil {
-			taskPayload["max_attempts"] = *options.MaxAttempts
+			envelope.MaxAttempts = *options.MaxAttempts
 		}
+		envelope.Notify = options.Notify
+		envelope.IdempotencyKey = options.IdempotencyKey
+		if options.Meta != nil {
+			meta, err := json.Marshal(options.Meta)
+			if err != nil {
+				return tasks.TaskPayload{}, fmt.Errorf("failed to marshal task meta: %w", err)
+			}
+			envelope.Meta = meta
+		}
+		eta := time.Time{}
+		if options.ETA != nil {
+			eta = *options.ETA
+		} else if options.Countdown > 0 {
+			eta = time.Now().Add(options.Countdown)
+		}
+		if !eta.IsZero() {
+			envelope.ETA = eta.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return envelope, nil
+}
+
+// goMessage validates envelope and encodes it for queue, delayed until its
+// ETA.
+func goMessage(envelope tasks.TaskPayload, queue string) (outgoing, error) {
+	if err := envelope.Validate(); err != nil {
+		return outgoing{}, fmt.Errorf("invalid task envelope: %w", err)
 	}
 
-	bodyBytes, err := json.Marshal(taskPayload)
+	bodyBytes, err := json.Marshal(envelope)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal task payload: %w", err)
+		return outgoing{}, fmt.Errorf("failed to marshal task envelope: %w", err)
+	}
+
+	var eta time.Time
+	if envelope.ETA != "" {
+		// Validated above
+		eta, _ = time.Parse(time.RFC3339Nano, envelope.ETA)
 	}
 
 	// Prepare message
@@ -183,32 +279,69 @@ func (p *RabbitMQPublisher) SendGoTask(task string, payload map[string]interface
 		ContentEncoding: "utf-8",
 		DeliveryMode:    amqp.Persistent,
 		Body:            bodyBytes,
+		MessageId:       envelope.ID,
 	}
+	return outgoing{queue: goQueue(queue), msg: msg, eta: eta}, nil
+}
 
-	// Publish to default exchange (direct to queue)
-	err = p.ch.Publish(
-		"",    // exchange (empty = default)
-		queue, // routing key
-		false, // mandatory
-		false, // immediate
-		msg,
-	)
-	if err != nil {
-		return "", fmt.Errorf("failed to publish message: %w", err)
+// goQueue returns the queue Go tasks are sent to by default.
+func goQueue(queue string) string {
+	if queue == "" {
+		return "celery"
 	}
+	return queue
+}
 
-	return taskID, nil
+// SetResultBackend makes SendGoTask record published tasks as pending so
+// their state can be queried before a worker picks them up.
+func (p *RabbitMQPublisher) SetResultBackend(b results.Backend) {
+	p.results = b
 }
 
-// Close closes the RabbitMQ connection and channel
+func (p *RabbitMQPublisher) recordPending(taskID, task string) {
+	if p.results == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.results.Update(ctx, results.Update{TaskID: taskID, Task: task, Status: results.StatusPending}); err != nil {
+		slog.Warn("Failed to record pending task state", logging.KeyComponent, "publisher", logging.KeyTask, task, logging.KeyTaskID, taskID, logging.Err(err))
+	}
+}
+
+// Close closes the RabbitMQ connections and channels. Messages still in
+// the outage buffer are dropped.
 func (p *RabbitMQPublisher) Close() error {
-	var chErr, connErr error
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if p.done != nil {
+		close(p.done)
+	}
+	if n := len(p.buffer); n > 0 {
+		slog.Warn("Dropping buffered messages on close", logging.KeyComponent, "publisher", "count", n)
+		p.buffer = nil
+	}
 
-	if p.ch != nil {
-		chErr = p.ch.Close()
+	var chErr, connErr error
+	for _, ch := range p.idle {
+		if err := ch.ch.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) && chErr == nil {
+			chErr = err
+		}
 	}
-	if p.conn != nil {
-		connErr = p.conn.Close()
+	p.idle = nil
+	for _, pc := range p.conns {
+		if pc.conn == nil {
+			continue
+		}
+		if err := pc.conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) && connErr == nil {
+			connErr = err
+		}
+		pc.conn = nil
 	}
 
 	if chErr != nil {
@@ -1,11 +1,14 @@
 package publisher
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 	"time"
 
 	"base-go-app/internal/config"
+	"base-go-app/internal/tasks"
+	"base-go-app/internal/tracing"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/stretchr/testify/assert"
@@ -58,6 +61,89 @@ func TestSendGoTask(t *testing.T) {
 	})
 }
 
+func TestBuildGoMessage(t *testing.T) {
+	timeout := 60
+	attempts := 2
+	taskID, m, err := buildGoMessage("logger", map[string]interface{}{"message": "hi"}, "", &TaskOptions{
+		TimeoutSeconds: &timeout,
+		MaxAttempts:    &attempts,
+		Notify: &tasks.NotifyConfig{
+			Sockudo: &tasks.SockudoConfig{Channel: "private-user.1", Event: "task.done"},
+			Webhook: &tasks.WebhookConfig{URL: "https://example.com/hook", OAuthScope: "tasks"},
+		},
+		IdempotencyKey: "order-42",
+		Meta:           map[string]interface{}{"tenant": "acme"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "celery", m.queue)
+	assert.Equal(t, "", m.exchange)
+	assert.True(t, m.eta.IsZero())
+	assert.Equal(t, taskID, m.msg.MessageId)
+
+	// The worker decodes exactly what the publisher built
+	var envelope tasks.TaskPayload
+	require.NoError(t, json.Unmarshal(m.msg.Body, &envelope))
+	assert.NoError(t, envelope.Validate())
+	assert.Equal(t, tasks.EnvelopeVersion, envelope.Version)
+	assert.Equal(t, taskID, envelope.ID)
+	assert.Equal(t, "logger", envelope.Task)
+	assert.JSONEq(t, `{"message":"hi"}`, string(envelope.Payload))
+	assert.Equal(t, 60, envelope.TimeoutSeconds)
+	assert.Equal(t, 2, envelope.MaxAttempts)
+	assert.Equal(t, "order-42", envelope.IdempotencyKey)
+	assert.JSONEq(t, `{"tenant":"acme"}`, string(envelope.Meta))
+	require.NotNil(t, envelope.Notify)
+	assert.Equal(t, "task.done", envelope.Notify.Sockudo.Event)
+	assert.Equal(t, "https://example.com/hook", envelope.Notify.Webhook.URL)
+
+	t.Run("defaults", func(t *testing.T) {
+		_, m, err := buildGoMessage("logger", nil, "go.logger", nil)
+		require.NoError(t, err)
+		var envelope tasks.TaskPayload
+		require.NoError(t, json.Unmarshal(m.msg.Body, &envelope))
+		assert.Equal(t, tasks.DefaultMaxAttempts, envelope.MaxAttempts)
+		assert.JSONEq(t, `{}`, string(envelope.Payload))
+		assert.Nil(t, envelope.Notify)
+		assert.Empty(t, envelope.ETA)
+	})
+
+	t.Run("countdown and eta", func(t *testing.T) {
+		_, m, err := buildGoMessage("logger", nil, "go.logger", &TaskOptions{Countdown: time.Minute})
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), m.eta, 5*time.Second)
+		var envelope tasks.TaskPayload
+		require.NoError(t, json.Unmarshal(m.msg.Body, &envelope))
+		assert.Equal(t, m.eta.UTC().Format(time.RFC3339Nano), envelope.ETA)
+
+		// An explicit ETA wins over the countdown
+		at := time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+		_, m, err = buildGoMessage("logger", nil, "go.logger", &TaskOptions{Countdown: time.Minute, ETA: &at})
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(m.msg.Body, &envelope))
+		assert.Equal(t, "2030-01-02T02:04:05Z", envelope.ETA)
+	})
+
+	t.Run("trace context", func(t *testing.T) {
+		_, m, err := buildGoMessage("logger", nil, "go.logger", nil)
+		require.NoError(t, err)
+		assert.NotContains(t, m.msg.Headers, "traceparent")
+
+		ctx := tracing.Extract(context.Background(), map[string]interface{}{
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		})
+		_, m, err = buildGoMessage("logger", nil, "go.logger", &TaskOptions{Context: ctx})
+		require.NoError(t, err)
+		assert.Contains(t, m.msg.Headers["traceparent"], "4bf92f3577b34da6a3ce929d0e0e4736")
+	})
+
+	t.Run("invalid notify", func(t *testing.T) {
+		_, _, err := buildGoMessage("logger", nil, "go.logger", &TaskOptions{
+			Notify: &tasks.NotifyConfig{Webhook: &tasks.WebhookConfig{}},
+		})
+		assert.ErrorContains(t, err, "invalid task envelope")
+	})
+}
+
 func TestClose(t *testing.T) {
 	t.Run("close nil connections", func(t *testing.T) {
 		pub := &RabbitMQPublisher{}
@@ -97,7 +183,15 @@ func TestIntegration_SendCeleryTask(t *testing.T) {
 		assert.NotEmpty(t, taskID)
 
 		// Verify message was published by consuming it
-		msgs, err := pub.ch.Consume(
+		conn, err := amqp.Dial(cfg.GetRabbitMQURL())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		ch, err := conn.Channel()
+		require.NoError(t, err)
+		defer ch.Close()
+
+		msgs, err := ch.Consume(
 			"test_queue",
 			"",
 			true,  // auto-ack
@@ -152,8 +246,8 @@ func TestIntegration_SendGoTask(t *testing.T) {
 		timeout := 300
 		options := &TaskOptions{
 			TimeoutSeconds: &timeout,
-			Notify: map[string]string{
-				"webhook": "http://example.com/callback",
+			Notify: &tasks.NotifyConfig{
+				Webhook: &tasks.WebhookConfig{URL: "http://example.com/callback"},
 			},
 		}
 
@@ -168,7 +262,15 @@ func TestIntegration_SendGoTask(t *testing.T) {
 		assert.NotEmpty(t, taskID)
 
 		// Verify message was published by consuming it
-		msgs, err := pub.ch.Consume(
+		conn, err := amqp.Dial(cfg.GetRabbitMQURL())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		ch, err := conn.Channel()
+		require.NoError(t, err)
+		defer ch.Close()
+
+		msgs, err := ch.Consume(
 			"test_go_queue",
 			"",
 			true,  // auto-ack
@@ -186,7 +288,7 @@ func TestIntegration_SendGoTask(t *testing.T) {
 			err := json.Unmarshal(msg.Body, &taskPayload)
 			require.NoError(t, err)
 
-			assert.Equal(t, "1.0", taskPayload["version"])
+			assert.Equal(t, tasks.EnvelopeVersion, taskPayload["version"])
 			assert.Equal(t, taskID, taskPayload["id"])
 			assert.Equal(t, "logger", taskPayload["task"])
 			assert.Equal(t, float64(300), taskPayload["timeout_seconds"])
@@ -0,0 +1,39 @@
+package publisher
+
+import (
+	"context"
+
+	"base-go-app/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traced starts the producer span of m as a child of ctx (nil for a new
+// trace) and writes its trace context into the message headers, so that
+// the worker continues the trace. The span ends in sendAll.
+func traced(ctx context.Context, m outgoing, task string) outgoing {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracing.Tracer().Start(ctx, m.queue+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", m.queue),
+			attribute.String("messaging.message.id", m.msg.MessageId),
+			attribute.String("task", task),
+		),
+	)
+	m.msg.Headers = tracing.Inject(ctx, m.msg.Headers)
+	m.span = span
+	return m
+}
+
+// taskContext returns the context set in options, if any.
+func taskContext(options *TaskOptions) context.Context {
+	if options == nil {
+		return nil
+	}
+	return options.Context
+}
@@ -0,0 +1,168 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"base-go-app/internal/tasks"
+	"base-go-app/internal/workflow"
+
+	"github.com/google/uuid"
+)
+
+// ErrNoWorkflowStore is returned by SendGroup and SendChord when no
+// workflow store is set.
+var ErrNoWorkflowStore = errors.New("no workflow store configured")
+
+// SetWorkflowStore sets the store SendGroup and SendChord record groups
+// in. Workers must use the same store (WORKFLOW_STORE=database).
+func (p *RabbitMQPublisher) SetWorkflowStore(s workflow.Store) {
+	p.workflows = s
+}
+
+// SendChain publishes the first of steps; the worker publishes each
+// following step once the previous one succeeded, with the previous
+// result as its payload unless the step has a payload of its own. The
+// chain stops at the first failed step. Countdown and ETA only apply to
+// the first step. Returns the ID of the first task.
+func (p *RabbitMQPublisher) SendChain(steps []BatchTask) (string, error) {
+	if len(steps) == 0 {
+		return "", fmt.Errorf("chain has no steps")
+	}
+	first := steps[0]
+	envelope, err := buildGoEnvelope(first.Task, first.Payload, first.Options)
+	if err != nil {
+		return "", err
+	}
+	for i, step := range steps[1:] {
+		sig, err := signatureOf(step)
+		if err != nil {
+			return "", fmt.Errorf("chain step %d: %w", i+1, err)
+		}
+		envelope.Chain = append(envelope.Chain, sig)
+	}
+
+	m, err := goMessage(envelope, first.Queue)
+	if err != nil {
+		return "", err
+	}
+	if err := p.send(traced(taskContext(first.Options), m, first.Task)); err != nil {
+		return "", err
+	}
+	p.recordPending(envelope.ID, envelope.Task)
+	return envelope.ID, nil
+}
+
+// SendGroup publishes members to run in parallel and tracks their
+// outcome in the workflow store. Returns the group ID; the group can be
+// inspected with the store's Get.
+func (p *RabbitMQPublisher) SendGroup(members []BatchTask) (string, error) {
+	return p.sendGroup(members, nil)
+}
+
+// SendChord publishes members like SendGroup; once all of them
+// succeeded, the worker finishing the last one publishes callback with
+// the member results, in order, as a JSON array payload (unless callback
+// has a payload of its own; see tasks.ParentResult). The callback is not
+// run if a member fails.
+func (p *RabbitMQPublisher) SendChord(members []BatchTask, callback BatchTask) (string, error) {
+	sig, err := signatureOf(callback)
+	if err != nil {
+		return "", fmt.Errorf("chord callback: %w", err)
+	}
+	encoded, err := json.Marshal(sig)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal chord callback: %w", err)
+	}
+	return p.sendGroup(members, encoded)
+}
+
+// sendGroup records a group before publishing its members, so that a
+// member finishing right away finds it. Members that cannot be published
+// are recorded as failed, which fails the group; the returned error joins
+// their errors.
+func (p *RabbitMQPublisher) sendGroup(members []BatchTask, callback json.RawMessage) (string, error) {
+	if p.workflows == nil {
+		return "", ErrNoWorkflowStore
+	}
+	if len(members) == 0 {
+		return "", fmt.Errorf("group has no members")
+	}
+
+	groupID := uuid.New().String()
+	msgs := make([]outgoing, len(members))
+	taskIDs := make([]string, len(members))
+	for i, t := range members {
+		envelope, err := buildGoEnvelope(t.Task, t.Payload, t.Options)
+		if err != nil {
+			return "", fmt.Errorf("group member %d: %w", i, err)
+		}
+		envelope.Group = &tasks.GroupRef{ID: groupID, Index: i}
+		m, err := goMessage(envelope, t.Queue)
+		if err != nil {
+			return "", fmt.Errorf("group member %d: %w", i, err)
+		}
+		msgs[i] = traced(taskContext(t.Options), m, t.Task)
+		taskIDs[i] = envelope.ID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.workflows.CreateGroup(ctx, groupID, taskIDs, callback); err != nil {
+		return "", fmt.Errorf("failed to create task group: %w", err)
+	}
+
+	var errs []error
+	for i, err := range p.sendAll(msgs) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("group member %d: %w", i, err))
+			if _, cerr := p.workflows.CompleteMember(ctx, groupID, i, nil, err); cerr != nil {
+				errs = append(errs, fmt.Errorf("failed to record group member %d: %w", i, cerr))
+			}
+			continue
+		}
+		p.recordPending(taskIDs[i], members[i].Task)
+	}
+	return groupID, errors.Join(errs...)
+}
+
+// signatureOf describes t as a task the worker publishes later. A nil
+// payload is left empty so that the step receives the previous result.
+func signatureOf(t BatchTask) (tasks.Signature, error) {
+	if t.Task == "" {
+		return tasks.Signature{}, fmt.Errorf("task name is required")
+	}
+	sig := tasks.Signature{
+		Task:        t.Task,
+		Queue:       goQueue(t.Queue),
+		MaxAttempts: tasks.DefaultMaxAttempts,
+	}
+	if t.Payload != nil {
+		payload, err := json.Marshal(t.Payload)
+		if err != nil {
+			return tasks.Signature{}, fmt.Errorf("failed to marshal task payload: %w", err)
+		}
+		sig.Payload = payload
+	}
+	if o := t.Options; o != nil {
+		if o.TimeoutSeconds != nil {
+			sig.TimeoutSeconds = *o.TimeoutSeconds
+		}
+		if o.MaxAttempts != nil {
+			sig.MaxAttempts = *o.MaxAttempts
+		}
+		sig.Notify = o.Notify
+		sig.IdempotencyKey = o.IdempotencyKey
+		if o.Meta != nil {
+			meta, err := json.Marshal(o.Meta)
+			if err != nil {
+				return tasks.Signature{}, fmt.Errorf("failed to marshal task meta: %w", err)
+			}
+			sig.Meta = meta
+		}
+	}
+	return sig, nil
+}
@@ -0,0 +1,136 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"base-go-app/internal/tasks"
+	"base-go-app/internal/workflow"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeEnvelope(t *testing.T, body []byte) tasks.TaskPayload {
+	t.Helper()
+	var env tasks.TaskPayload
+	require.NoError(t, json.Unmarshal(body, &env))
+	return env
+}
+
+func TestSendChain(t *testing.T) {
+	p, d := newTestPublisher(t, 0)
+	ch := d.conn(0).channel(0)
+	retries := 5
+
+	id, err := p.SendChain([]BatchTask{
+		{Task: "fetch", Queue: "go.fetch", Payload: map[string]interface{}{"url": "https://example.com"}},
+		{Task: "parse"},
+		{Task: "store", Queue: "go.store", Payload: map[string]interface{}{"table": "pages"}, Options: &TaskOptions{MaxAttempts: &retries}},
+	})
+	require.NoError(t, err)
+
+	// Only the first step is published
+	require.Equal(t, 1, ch.count())
+	assert.Equal(t, "go.fetch", ch.published[0].queue)
+	env := decodeEnvelope(t, ch.published[0].msg.Body)
+	assert.Equal(t, id, env.ID)
+	assert.Equal(t, "fetch", env.Task)
+	require.Len(t, env.Chain, 2)
+
+	assert.Equal(t, "parse", env.Chain[0].Task)
+	assert.Equal(t, "celery", env.Chain[0].Queue)
+	assert.Empty(t, env.Chain[0].Payload, "nil payload takes the previous result")
+	assert.Equal(t, tasks.DefaultMaxAttempts, env.Chain[0].MaxAttempts)
+
+	assert.Equal(t, "store", env.Chain[1].Task)
+	assert.Equal(t, "go.store", env.Chain[1].Queue)
+	assert.JSONEq(t, `{"table":"pages"}`, string(env.Chain[1].Payload))
+	assert.Equal(t, 5, env.Chain[1].MaxAttempts)
+
+	_, err = p.SendChain(nil)
+	assert.Error(t, err)
+	_, err = p.SendChain([]BatchTask{{Task: "fetch"}, {}})
+	assert.Error(t, err)
+	assert.Equal(t, 1, ch.count())
+}
+
+func TestSendGroup(t *testing.T) {
+	p, d := newTestPublisher(t, 0)
+	ch := d.conn(0).channel(0)
+
+	_, err := p.SendGroup([]BatchTask{{Task: "resize"}})
+	assert.ErrorIs(t, err, ErrNoWorkflowStore)
+
+	store := workflow.NewMemoryStore(0)
+	p.SetWorkflowStore(store)
+
+	_, err = p.SendGroup(nil)
+	assert.Error(t, err)
+
+	groupID, err := p.SendGroup([]BatchTask{
+		{Task: "resize", Queue: "go.images", Payload: map[string]interface{}{"n": 0}},
+		{Task: "resize", Queue: "go.images", Payload: map[string]interface{}{"n": 1}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, ch.count())
+
+	g, err := store.Get(context.Background(), groupID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, g.Size)
+	assert.Equal(t, workflow.StatusRunning, g.Status)
+	assert.Empty(t, g.Callback)
+	for i := 0; i < 2; i++ {
+		env := decodeEnvelope(t, ch.published[i].msg.Body)
+		require.NotNil(t, env.Group)
+		assert.Equal(t, groupID, env.Group.ID)
+		assert.Equal(t, i, env.Group.Index)
+		assert.Equal(t, g.Members[i].TaskID, env.ID)
+	}
+}
+
+func TestSendGroupUnpublishedMemberFailsGroup(t *testing.T) {
+	p, d := newTestPublisher(t, 0)
+	ch := d.conn(0).channel(0)
+	ch.set(func(c *fakePubChannel) { c.unroutableQueue = "go.missing" })
+	store := workflow.NewMemoryStore(0)
+	p.SetWorkflowStore(store)
+
+	groupID, err := p.SendGroup([]BatchTask{{Task: "a", Queue: "go.a"}, {Task: "b", Queue: "go.missing"}})
+	assert.ErrorIs(t, err, ErrUnroutable)
+	require.NotEmpty(t, groupID)
+
+	g, err := store.Get(context.Background(), groupID)
+	require.NoError(t, err)
+	assert.Equal(t, workflow.StatusFailed, g.Status)
+	assert.Equal(t, workflow.StatusPending, g.Members[0].Status)
+	assert.Equal(t, workflow.StatusFailed, g.Members[1].Status)
+}
+
+func TestSendChord(t *testing.T) {
+	p, d := newTestPublisher(t, 0)
+	ch := d.conn(0).channel(0)
+	store := workflow.NewMemoryStore(0)
+	p.SetWorkflowStore(store)
+
+	_, err := p.SendChord([]BatchTask{{Task: "count"}}, BatchTask{})
+	assert.Error(t, err)
+	assert.Equal(t, 0, ch.count())
+
+	groupID, err := p.SendChord(
+		[]BatchTask{{Task: "count", Queue: "go.words"}, {Task: "count", Queue: "go.words"}},
+		BatchTask{Task: "sum", Queue: "go.sum"},
+	)
+	require.NoError(t, err)
+	// The callback is published by the worker finishing the group
+	require.Equal(t, 2, ch.count())
+
+	g, err := store.Get(context.Background(), groupID)
+	require.NoError(t, err)
+	var sig tasks.Signature
+	require.NoError(t, json.Unmarshal(g.Callback, &sig))
+	assert.Equal(t, "sum", sig.Task)
+	assert.Equal(t, "go.sum", sig.Queue)
+	assert.Empty(t, sig.Payload)
+}
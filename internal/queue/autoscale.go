@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"base-go-app/internal/logging"
+)
+
+const (
+	// A pool grows while its workers are busier than scaleUpUtilization
+	// and messages are waiting, and shrinks once they are less busy than
+	// scaleDownUtilization with none waiting.
+	scaleUpUtilization   = 0.8
+	scaleDownUtilization = 0.5
+	// scaleDownAfter is how many intervals in a row a pool must be
+	// oversized before it shrinks, so that bursty queues keep their
+	// workers.
+	scaleDownAfter = 3
+)
+
+// load is what the autoscaler observed of a queue over an interval.
+type load struct {
+	concurrency int
+	// backlog is the number of messages waiting: ready in the broker plus
+	// buffered in the worker.
+	backlog int
+	// utilization is the share of the interval the workers were busy.
+	utilization float64
+	// latency is the mean execution time of the tasks that finished, zero
+	// when none did.
+	latency time.Duration
+}
+
+// autoscale resizes the worker pool every interval from the queue's load,
+// within the pool bounds, until ctx is canceled. Nothing is resized while
+// the queue is paused or disconnected.
+func (c *queueConsumer) autoscale(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	oversized := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l, ok := c.measure(now.Sub(last))
+			last = now
+			if !ok {
+				oversized = 0
+				continue
+			}
+			min, max := c.cfg.PoolBounds()
+			n := scaleTarget(l, interval, min, max)
+			if n < l.concurrency {
+				if oversized++; oversized < scaleDownAfter {
+					continue
+				}
+			}
+			oversized = 0
+			if n == l.concurrency {
+				continue
+			}
+			c.log.Debug("Autoscaling worker pool", "backlog", l.backlog,
+				"utilization", math.Round(l.utilization*100)/100, "latency", l.latency)
+			if err := c.resize(n); err != nil {
+				if errors.Is(err, ErrStopped) {
+					return
+				}
+				c.log.Warn("Failed to autoscale worker pool", logging.Err(err))
+			}
+		}
+	}
+}
+
+// measure returns the load of the queue over the elapsed time and resets
+// the task counters. It fails while the queue is paused or disconnected.
+func (c *queueConsumer) measure(elapsed time.Duration) (load, bool) {
+	finished := atomic.SwapInt64(&c.finished, 0)
+	busy := time.Duration(atomic.SwapInt64(&c.busy, 0))
+	ch := c.channel()
+	if ch == nil || c.pausedUntil() != nil || elapsed <= 0 {
+		return load{}, false
+	}
+	q, err := ch.QueueDeclarePassive(c.cfg.Name, true, false, false, false, nil)
+	if err != nil {
+		c.log.Warn("Failed to read queue depth", logging.Err(err))
+		return load{}, false
+	}
+
+	l := load{concurrency: c.poolSize(), backlog: q.Messages + len(c.taskCh)}
+	if finished > 0 {
+		l.latency = busy / time.Duration(finished)
+	}
+	// Tasks still running count as busy too, which matters when they
+	// outlast the interval
+	l.utilization = math.Max(
+		busy.Seconds()/(elapsed.Seconds()*float64(l.concurrency)),
+		float64(atomic.LoadInt64(&c.inFlight))/float64(l.concurrency),
+	)
+	return l, true
+}
+
+// scaleTarget returns the pool size for l, between min and max. A busy
+// pool with a backlog grows by the workers needed to work it off within
+// one interval at the observed latency (one at a time without a latency
+// yet), at most doubling; an idle pool shrinks by up to a quarter, toward
+// the workers it kept busy.
+func scaleTarget(l load, interval time.Duration, min, max int) int {
+	n := l.concurrency
+	switch {
+	case l.backlog > 0 && l.utilization >= scaleUpUtilization:
+		extra := 1
+		if l.latency > 0 {
+			extra = int(math.Ceil(float64(l.backlog) * l.latency.Seconds() / interval.Seconds()))
+		}
+		if extra > n {
+			extra = n
+		}
+		n += extra
+	case l.backlog == 0 && l.utilization < scaleDownUtilization:
+		needed := int(math.Ceil(l.utilization * float64(n) / scaleUpUtilization))
+		floor := n - int(math.Ceil(float64(n)/4))
+		if needed < floor {
+			needed = floor
+		}
+		n = needed
+	}
+	if n < min {
+		n = min
+	}
+	if n > max {
+		n = max
+	}
+	return n
+}
@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/tasks"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScaleTarget(t *testing.T) {
+	interval := 10 * time.Second
+	for _, tc := range []struct {
+		name string
+		load load
+		want int
+	}{
+		{"busy with a backlog, no latency yet", load{concurrency: 4, backlog: 50, utilization: 1}, 5},
+		{"backlog worked off in one interval", load{concurrency: 4, backlog: 20, utilization: 0.9, latency: time.Second}, 6},
+		{"at most doubling", load{concurrency: 4, backlog: 1000, utilization: 1, latency: time.Second}, 8},
+		{"capped at max", load{concurrency: 10, backlog: 1000, utilization: 1, latency: time.Second}, 12},
+		{"backlog but idle workers", load{concurrency: 4, backlog: 50, utilization: 0.3}, 4},
+		{"busy without a backlog", load{concurrency: 4, utilization: 0.7}, 4},
+		{"idle shrinks by a quarter", load{concurrency: 8}, 6},
+		{"shrinks toward the busy workers", load{concurrency: 8, utilization: 0.45}, 6},
+		{"not below min", load{concurrency: 2}, 2},
+	} {
+		assert.Equal(t, tc.want, scaleTarget(tc.load, interval, 2, 12), tc.name)
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	c := newQueueConsumer(config.QueueConfig{Name: "q", Concurrency: 4}, config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 4)
+	_, ok := c.measure(time.Second)
+	assert.False(t, ok, "nothing to measure while disconnected")
+
+	c.ch = &fakeChannel{depth: 7}
+	c.taskCh <- amqp.Delivery{}
+	atomic.StoreInt64(&c.finished, 4)
+	atomic.StoreInt64(&c.busy, int64(2*time.Second))
+	l, ok := c.measure(time.Second)
+	require.True(t, ok)
+	assert.Equal(t, load{concurrency: 4, backlog: 8, utilization: 0.5, latency: 500 * time.Millisecond}, l)
+
+	// Counters restart with every interval; running tasks count as busy
+	atomic.StoreInt64(&c.inFlight, 3)
+	l, _ = c.measure(time.Second)
+	assert.Equal(t, 0.75, l.utilization)
+	assert.Zero(t, l.latency)
+
+	c.pause()
+	_, ok = c.measure(time.Second)
+	assert.False(t, ok, "nothing to measure while paused")
+}
+
+func TestAutoscale(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	qc := config.QueueConfig{Name: "q", Concurrency: 2, Prefetch: 4, MinConcurrency: 1, MaxConcurrency: 5, Autoscale: true}
+	c := newQueueConsumer(qc, config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	ch := &fakeChannel{depth: 100}
+	c.ch = ch
+	var wg sync.WaitGroup
+	c.startWorkers(ctx, ctx, &wg)
+
+	// Every worker busy and messages waiting: grows up to the max
+	atomic.StoreInt64(&c.inFlight, 5)
+	scaleCtx, stopScaling := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		c.autoscale(scaleCtx, 5*time.Millisecond)
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return c.poolSize() == 5 }, time.Second, time.Millisecond)
+	stopScaling()
+	<-done
+	assert.Equal(t, 10, c.poolPrefetch())
+
+	// Idle and nothing waiting: shrinks down to the min
+	atomic.StoreInt64(&c.inFlight, 0)
+	ch.depth = 0
+	go c.autoscale(ctx, 5*time.Millisecond)
+	require.Eventually(t, func() bool { return c.poolSize() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, 2, c.poolPrefetch())
+
+	// Draining stops the autoscaler
+	c.stopPool()
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// ErrNacked is returned when the broker refused a message (basic.nack).
+	ErrNacked = errors.New("message was nacked by the broker")
+	// ErrConfirmTimeout is returned when the broker did not confirm a
+	// message in time. The message may or may not have been enqueued.
+	ErrConfirmTimeout = errors.New("timed out waiting for publisher confirm")
+)
+
+// confirmTimeout is how long a publish waits for its confirmation.
+const confirmTimeout = 5 * time.Second
+
+// confirmBuffer bounds the confirmations queued by the AMQP reader. A
+// confirmation that arrives after its publish timed out waits there until
+// the next publish skips it.
+const confirmBuffer = 16
+
+// amqpConfirmChannel is the part of *amqp.Channel a confirmChannel uses.
+type amqpConfirmChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	Close() error
+}
+
+// confirmChannel is a channel in confirm mode for the messages a task
+// hands over to the tasks that follow it, which must not be lost once the
+// task is acked. Publishes are serialized, each waiting for its own
+// confirmation; the broker numbers them 1, 2, ... per channel.
+type confirmChannel struct {
+	mu       sync.Mutex
+	ch       amqpConfirmChannel
+	confirms chan amqp.Confirmation
+	// tag is the delivery tag of the last message published
+	tag uint64
+}
+
+// newConfirmChannel puts ch in confirm mode.
+func newConfirmChannel(ch amqpConfirmChannel) (*confirmChannel, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	return &confirmChannel{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, confirmBuffer)),
+	}, nil
+}
+
+// publish sends msg and waits until the broker confirms it.
+func (c *confirmChannel) publish(exchange, key string, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.ch.Publish(exchange, key, false, false, msg); err != nil {
+		return err
+	}
+	c.tag++
+
+	timer := time.NewTimer(confirmTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case conf, ok := <-c.confirms:
+			if !ok {
+				return amqp.ErrClosed
+			}
+			if conf.DeliveryTag < c.tag {
+				// Of a message whose publish timed out
+				continue
+			}
+			if !conf.Ack {
+				return ErrNacked
+			}
+			return nil
+		case <-timer.C:
+			return ErrConfirmTimeout
+		}
+	}
+}
+
+func (c *confirmChannel) Close() error {
+	return c.ch.Close()
+}
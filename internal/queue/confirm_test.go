@@ -0,0 +1,29 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfirmChannel(t *testing.T) {
+	ch := &fakeChannel{}
+	c, err := newConfirmChannel(ch)
+	require.NoError(t, err)
+
+	require.NoError(t, c.publish("", "go.logger", amqp.Publishing{}))
+
+	// A late confirmation of an earlier message is skipped
+	c.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+	require.NoError(t, c.publish("", "go.logger", amqp.Publishing{}))
+
+	ch.nack = true
+	assert.ErrorIs(t, c.publish("", "go.logger", amqp.Publishing{}), ErrNacked)
+	assert.Len(t, ch.published, 3)
+
+	_, err = newConfirmChannel(&fakeChannel{failWith: errors.New("closed")})
+	assert.Error(t, err)
+}
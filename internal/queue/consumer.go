@@ -2,18 +2,22 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"log/slog"
 	"os"
-	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"base-go-app/internal/broadcast"
 	"base-go-app/internal/config"
+	"base-go-app/internal/idempotency"
+	"base-go-app/internal/logging"
+	"base-go-app/internal/metrics"
+	"base-go-app/internal/ratelimit"
+	"base-go-app/internal/results"
 	"base-go-app/internal/tasks"
 	"base-go-app/internal/webhook"
+	"base-go-app/internal/workflow"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -37,6 +41,14 @@ func SetRabbitConnectedForTests(v bool) {
 // StartConsumer starts the consumer loop in a background goroutine and returns
 // a channel that will be closed when the consumer exits (typically because ctx
 // was canceled).
+//
+// Canceling ctx drains the consumer: it stops consuming, requeues the
+// deliveries that were not started and lets the tasks in flight finish
+// within cfg.GetShutdownGrace() before canceling them.
+//
+// Every queue returned by cfg.GetQueues gets its own AMQP channel (so prefetch
+// is applied per queue), its own task buffer and its own worker pool, so a slow
+// queue cannot starve the others.
 func StartConsumer(ctx context.Context, cfg *config.Config) <-chan struct{} {
 	done := make(chan struct{})
 
@@ -49,266 +61,232 @@ func StartConsumer(ctx context.Context, cfg *config.Config) <-chan struct{} {
 		os.Getenv("WEBHOOK_OAUTH_SCOPE"),
 	)
 	dispatcher := tasks.NewDispatcher(broadcaster, webhookClient)
-
-	// Worker pool config
-	concurrency := 10
-	if s := os.Getenv("WORKER_CONCURRENCY"); s != "" {
-		if v, err := strconv.Atoi(s); err == nil && v > 0 {
-			concurrency = v
-		}
+	dispatcher.Results = newResultBackend(ctx, cfg)
+	dispatcher.Idempotency = newIdempotencyStore(ctx, cfg)
+	dispatcher.Workflows = newWorkflowStore(ctx, cfg)
+	if cfg.RateLimitStore == config.RateLimitStoreDatabase {
+		slog.Info("Sharing rate limits across pods", "store", cfg.RateLimitStore)
+		dispatcher.RateLimiter = ratelimit.NewDBLimiter()
 	}
-	bufferSize := 100
-	if s := os.Getenv("TASK_CHANNEL_BUFFER"); s != "" {
-		if v, err := strconv.Atoi(s); err == nil && v > 0 {
-			bufferSize = v
-		}
-	}
-
-	taskCh := make(chan amqp.Delivery, bufferSize)
-	var wg sync.WaitGroup
-
-	// Shared channel for publishing retries
-	var (
-		chMu      sync.RWMutex
-		currentCh *amqp.Channel
-	)
-
-	// Start workers
-	for i := 0; i < concurrency; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case d, ok := <-taskCh:
-					if !ok {
-						return
-					}
-					// Process task
-					res := dispatcher.Dispatch(ctx, d.Body)
-					if res.Success {
-						d.Ack(false)
-					} else if res.Retry {
-						// Attempt to republish with incremented attempt count
-						var payload tasks.TaskPayload
-						if err := json.Unmarshal(d.Body, &payload); err == nil {
-							payload.Attempt = res.RetryAttempt
-							newBody, _ := json.Marshal(payload)
-
-							// Calculate backoff (exponential: 2^(attempt-1) seconds)
-							// e.g., attempt 1 (retry 1) -> 1s, retry 2 -> 2s, retry 3 -> 4s
-							backoffMs := int64(1000 * (1 << (payload.Attempt - 1)))
 
-							chMu.RLock()
-							pubCh := currentCh
-							chMu.RUnlock()
+	// Tasks outlive ctx so that shutting down lets them finish
+	taskCtx, cancelTasks := context.WithCancel(context.WithoutCancel(ctx))
+	grace := cfg.GetShutdownGrace()
 
-							if pubCh != nil {
-								err := pubCh.Publish(
-									d.Exchange,
-									d.RoutingKey,
-									false, // mandatory
-									false, // immediate
-									amqp.Publishing{
-										ContentType: "application/json",
-										Body:        newBody,
-										Headers: amqp.Table{
-											"x-delay": backoffMs, // For rabbitmq_delayed_message_exchange
-										},
-									},
-								)
-								if err == nil {
-									d.Ack(false)
-									continue
-								}
-								log.Printf("Failed to republish retry: %v", err)
-							}
-						}
-						// Fallback: Nack without requeue (DLQ)
-						d.Nack(false, false)
-					} else {
-						// Fatal error
-						d.Nack(false, false)
-					}
-				}
-			}
-		}(i)
+	var wg sync.WaitGroup
+	bufferSize := cfg.GetTaskChannelBuffer()
+	retryMode := cfg.GetRetryDelayMode()
+	var consumers []*queueConsumer
+	for _, qc := range cfg.GetQueues() {
+		c := newQueueConsumer(qc, retryMode, dispatcher, bufferSize)
+		c.startWorkers(ctx, taskCtx, &wg)
+		c.registerMetrics()
+		consumers = append(consumers, c)
+		min, max := qc.PoolBounds()
+		c.log.Info("Queue configured", "exchange", qc.Exchange, "routing_key", qc.RoutingKey,
+			"concurrency", qc.Concurrency, "prefetch", qc.Prefetch,
+			"min_concurrency", min, "max_concurrency", max, "autoscale", qc.Autoscale)
+		if qc.Autoscale {
+			go c.autoscale(ctx, cfg.GetAutoscaleInterval())
+		}
 	}
 
+	setConsumers(consumers)
+
 	go func() {
 		defer close(done)
-		defer wg.Wait() // Wait for workers to finish
-		defer close(taskCh)
+		defer cancelTasks()
+		defer func() {
+			for _, c := range consumers {
+				close(c.taskCh)
+			}
+		}()
 
 		delay := 2 * time.Second
+		// backoff waits delay before the next attempt and doubles it, up
+		// to 30s. It drains the workers and returns false if ctx is
+		// canceled meanwhile.
+		backoff := func() bool {
+			select {
+			case <-ctx.Done():
+				drainWorkers(consumers, &wg, grace, cancelTasks)
+				return false
+			case <-time.After(delay):
+			}
+			if delay < 30*time.Second {
+				delay *= 2
+				if delay > 30*time.Second {
+					delay = 30 * time.Second
+				}
+			}
+			return true
+		}
+		connected := false
 		for {
 			select {
 			case <-ctx.Done():
-				log.Println("StartConsumer: context canceled, shutting down consumer")
+				slog.Info("Context canceled, shutting down consumer")
 				atomic.StoreInt32(&rabbitConnected, 0)
+				drainWorkers(consumers, &wg, grace, cancelTasks)
 				return
 			default:
 			}
 
-			log.Printf("Attempting RabbitMQ connect...")
+			slog.Debug("Connecting to RabbitMQ")
 			conn, err := amqp.Dial(cfg.GetRabbitMQURL())
 			if err != nil {
-				log.Printf("RabbitMQ connect failed: %v", err)
-				// backoff
-				select {
-				case <-ctx.Done():
+				slog.Warn("RabbitMQ connect failed", logging.Err(err), "retry_in", delay)
+				if !backoff() {
 					return
-				case <-time.After(delay):
-				}
-				if delay < 30*time.Second {
-					delay *= 2
-					if delay > 30*time.Second {
-						delay = 30 * time.Second
-					}
 				}
 				continue
 			}
 
 			// Connected
 			atomic.StoreInt32(&rabbitConnected, 1)
-			log.Println("Connected to RabbitMQ")
-
-			ch, err := conn.Channel()
-			if err != nil {
-				log.Printf("Failed to open a channel: %v", err)
-				_ = conn.Close()
-				atomic.StoreInt32(&rabbitConnected, 0)
-				continue
-			}
-
-			// Update shared channel
-			chMu.Lock()
-			currentCh = ch
-			chMu.Unlock()
-
-			// Set QoS
-			if err := ch.Qos(concurrency*2, 0, false); err != nil {
-				log.Printf("Failed to set QoS: %v", err)
-			}
-
-			queueName := "logger"
-			exchangeName := "celery" // Keeping legacy name for now, or switch to "tasks"
-			routingKey := "logger"   // or task.log_db
-
-			// Declare Exchange
-			err = ch.ExchangeDeclare(
-				exchangeName, // name
-				"direct",     // type
-				true,         // durable
-				false,        // auto-deleted
-				false,        // internal
-				false,        // no-wait
-				nil,          // arguments
-			)
-			if err != nil {
-				log.Printf("Failed to declare exchange: %v", err)
-				ch.Close()
-				_ = conn.Close()
-				atomic.StoreInt32(&rabbitConnected, 0)
-				continue
+			slog.Info("Connected to RabbitMQ")
+			if connected {
+				metrics.RabbitMQReconnects.WithLabelValues("consumer").Inc()
 			}
+			connected = true
 
-			// Declare Queue
-			q, err := ch.QueueDeclare(
-				queueName, // name
-				true,      // durable
-				false,     // delete when unused
-				false,     // exclusive
-				false,     // no-wait
-				nil,       // arguments
-			)
-			if err != nil {
-				log.Printf("Failed to declare a queue: %v", err)
-				ch.Close()
-				_ = conn.Close()
-				atomic.StoreInt32(&rabbitConnected, 0)
-				continue
-			}
-
-			// Bind Queue
-			err = ch.QueueBind(
-				q.Name,
-				routingKey,
-				exchangeName,
-				false,
-				nil,
-			)
-			if err != nil {
-				log.Printf("Failed to bind queue: %v", err)
-				ch.Close()
-				_ = conn.Close()
-				atomic.StoreInt32(&rabbitConnected, 0)
-				continue
+			// Open a channel and subscribe for every queue
+			var deliveries []<-chan amqp.Delivery
+			for _, c := range consumers {
+				msgs, err := c.subscribe(conn)
+				if err != nil {
+					c.log.Error("Failed to subscribe", logging.Err(err), "retry_in", delay)
+					break
+				}
+				deliveries = append(deliveries, msgs)
 			}
-
-			msgs, err := ch.Consume(
-				q.Name, // queue
-				"",     // consumer
-				false,  // auto-ack (FALSE now, manual ack in worker)
-				false,  // exclusive
-				false,  // no-local
-				false,  // no-wait
-				nil,    // args
-			)
-			if err != nil {
-				log.Printf("Failed to register a consumer: %v", err)
-				ch.Close()
-				_ = conn.Close()
-				atomic.StoreInt32(&rabbitConnected, 0)
+			if len(deliveries) != len(consumers) {
+				// Retrying right away would fail the same way, e.g. on a
+				// queue declared with other arguments
+				closeConsumers(consumers, conn)
+				if !backoff() {
+					return
+				}
 				continue
 			}
 
 			// Reset delay after successful connection
 			delay = 2 * time.Second
 
-			// Process messages; when msgs channel closes we attempt to reconnect
-			notifyClose := conn.NotifyClose(make(chan *amqp.Error))
-			for {
-				select {
-				case <-ctx.Done():
-					log.Println("Context canceled while consuming, closing consumer")
-					chMu.Lock()
-					currentCh = nil
-					chMu.Unlock()
-					ch.Close()
-					_ = conn.Close()
-					atomic.StoreInt32(&rabbitConnected, 0)
-					return
-				case err := <-notifyClose:
-					log.Printf("RabbitMQ connection closed: %v", err)
-					goto Reconnect
-				case d, ok := <-msgs:
-					if !ok {
-						// Channel closed
-						log.Println("msgs channel closed")
-						goto Reconnect
-					}
-					// Push to worker pool
-					select {
-					case taskCh <- d:
-					case <-ctx.Done():
-						return
+			// Forward deliveries to the worker pools; when any msgs channel
+			// closes or the connection drops we attempt to reconnect
+			notifyClose := conn.NotifyClose(make(chan *amqp.Error, 1))
+			lost := make(chan struct{})
+			stop := make(chan struct{})
+			var lostOnce sync.Once
+			var fwd sync.WaitGroup
+			for i, c := range consumers {
+				fwd.Add(1)
+				go func(c *queueConsumer, msgs <-chan amqp.Delivery) {
+					defer fwd.Done()
+					if c.consume(ctx, msgs, stop) {
+						lostOnce.Do(func() { close(lost) })
 					}
+				}(c, deliveries[i])
+			}
+
+			select {
+			case <-ctx.Done():
+				slog.Info("Context canceled while consuming, draining consumer")
+				for _, c := range consumers {
+					c.stopConsuming()
 				}
+				fwd.Wait()
+				// Keep the connection open so in-flight tasks can ack
+				drainWorkers(consumers, &wg, grace, cancelTasks)
+				closeConsumers(consumers, conn)
+				return
+			case err := <-notifyClose:
+				slog.Warn("RabbitMQ connection closed", logging.Err(err))
+			case <-lost:
 			}
-		Reconnect:
+
 			// msgs channel closed or connection lost
-			log.Println("RabbitMQ consumer disconnected, will attempt reconnect")
-			chMu.Lock()
-			currentCh = nil
-			chMu.Unlock()
-			ch.Close()
-			_ = conn.Close()
-			atomic.StoreInt32(&rabbitConnected, 0)
+			slog.Warn("RabbitMQ consumer disconnected, will attempt reconnect")
+			closeConsumers(consumers, conn)
+			close(stop)
+			fwd.Wait()
 			// loop and retry
 		}
 	}()
 	return done
 }
+
+// newResultBackend creates the configured result backend and starts its
+// expiry janitor. It returns nil when results are disabled.
+func newResultBackend(ctx context.Context, cfg *config.Config) results.Backend {
+	expires := time.Duration(cfg.ResultExpiresSeconds) * time.Second
+	if expires <= 0 {
+		expires = results.DefaultExpiry
+	}
+
+	var backend results.Backend
+	switch cfg.ResultBackend {
+	case config.ResultBackendDatabase:
+		backend = results.NewDBBackend(expires)
+	case config.ResultBackendMemory:
+		backend = results.NewMemoryBackend(expires)
+	default:
+		return nil
+	}
+	slog.Info("Recording task results", "backend", cfg.ResultBackend, "expiry", expires)
+	results.StartJanitor(ctx, backend, 10*time.Minute)
+	return backend
+}
+
+// newIdempotencyStore creates the configured idempotency store and starts
+// its expiry janitor. It returns nil when deduplication is disabled.
+func newIdempotencyStore(ctx context.Context, cfg *config.Config) idempotency.Store {
+	ttl := time.Duration(cfg.IdempotencyTTLSeconds) * time.Second
+
+	var store idempotency.Store
+	switch cfg.IdempotencyStore {
+	case config.IdempotencyStoreDatabase:
+		store = idempotency.NewDBStore(ttl)
+	case config.IdempotencyStoreMemory:
+		store = idempotency.NewMemoryStore(ttl)
+	default:
+		return nil
+	}
+	slog.Info("Deduplicating tasks by idempotency key", "store", cfg.IdempotencyStore)
+	idempotency.StartJanitor(ctx, store, 10*time.Minute)
+	return store
+}
+
+// newWorkflowStore creates the configured workflow store and starts its
+// expiry janitor. Finished groups are kept as long as task results. It
+// returns nil when workflows are disabled.
+func newWorkflowStore(ctx context.Context, cfg *config.Config) workflow.Store {
+	expires := time.Duration(cfg.ResultExpiresSeconds) * time.Second
+	if expires <= 0 {
+		expires = workflow.DefaultExpiry
+	}
+
+	var store workflow.Store
+	switch cfg.WorkflowStore {
+	case config.WorkflowStoreDatabase:
+		store = workflow.NewDBStore(expires)
+	case config.WorkflowStoreMemory:
+		store = workflow.NewMemoryStore(expires)
+	default:
+		return nil
+	}
+	slog.Info("Tracking task groups", "store", cfg.WorkflowStore)
+	workflow.StartJanitor(ctx, store, 10*time.Minute)
+	return store
+}
+
+// closeConsumers releases every queue channel and the connection and marks
+// RabbitMQ as disconnected.
+func closeConsumers(consumers []*queueConsumer, conn *amqp.Connection) {
+	for _, c := range consumers {
+		c.closeChannel()
+	}
+	_ = conn.Close()
+	atomic.StoreInt32(&rabbitConnected, 0)
+}
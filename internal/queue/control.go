@@ -0,0 +1,301 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/logging"
+	"base-go-app/internal/tasks"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MaxConcurrency bounds the worker pool of a queue.
+const MaxConcurrency = config.MaxWorkerConcurrency
+
+var (
+	// ErrUnknownQueue is returned for a queue the worker does not consume.
+	ErrUnknownQueue = errors.New("unknown queue")
+	// ErrInvalidConcurrency is returned for a pool size out of the
+	// queue's bounds.
+	ErrInvalidConcurrency = errors.New("invalid concurrency")
+	// ErrStopped is returned when resizing a pool that is shutting down.
+	ErrStopped = errors.New("worker pool is shutting down")
+)
+
+// InFlightTask is a task being executed.
+type InFlightTask struct {
+	Queue   string    `json:"queue"`
+	TaskID  string    `json:"task_id,omitempty"`
+	Task    string    `json:"task,omitempty"`
+	Started time.Time `json:"started_at"`
+}
+
+// InFlight returns the tasks being executed, oldest first.
+func InFlight() []InFlightTask {
+	var out []InFlightTask
+	for _, c := range getConsumers() {
+		c.runningMu.Lock()
+		for _, t := range c.running {
+			out = append(out, t)
+		}
+		c.runningMu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+	return out
+}
+
+// Pause stops consuming from queue until Resume. Deliveries already
+// received are still executed.
+func Pause(queue string) error {
+	c, err := lookupConsumer(queue)
+	if err != nil {
+		return err
+	}
+	c.pause()
+	return nil
+}
+
+// Resume consumes from a paused queue again.
+func Resume(queue string) error {
+	c, err := lookupConsumer(queue)
+	if err != nil {
+		return err
+	}
+	c.resume()
+	return nil
+}
+
+// drained is set between Drain and ResumeAll.
+var drained atomic.Bool
+
+// Drain pauses every queue: the worker finishes the tasks it received and
+// takes no new ones, and its readiness fails (see DrainCheck). Unlike a
+// shutdown the process keeps running, so the drain lasts until ResumeAll.
+func Drain() {
+	drained.Store(true)
+	for _, c := range getConsumers() {
+		c.pause()
+	}
+}
+
+// ResumeAll resumes every queue and ends a drain.
+func ResumeAll() {
+	for _, c := range getConsumers() {
+		c.resume()
+	}
+	drained.Store(false)
+}
+
+// Drained reports whether the worker is drained (see Drain).
+func Drained() bool {
+	return drained.Load()
+}
+
+// SetConcurrency resizes the worker pool of queue to n workers, within
+// its pool bounds, and scales its prefetch along.
+func SetConcurrency(queue string, n int) error {
+	c, err := lookupConsumer(queue)
+	if err != nil {
+		return err
+	}
+	return c.resize(n)
+}
+
+func lookupConsumer(queue string) (*queueConsumer, error) {
+	for _, c := range getConsumers() {
+		if c.cfg.Name == queue {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownQueue, queue)
+}
+
+// track records that d started executing and returns its key for untrack.
+func (c *queueConsumer) track(d amqp.Delivery) uint64 {
+	id, task := tasks.Identify(d.Body, d.Headers)
+	c.runningMu.Lock()
+	defer c.runningMu.Unlock()
+	c.seq++
+	c.running[c.seq] = InFlightTask{Queue: c.cfg.Name, TaskID: id, Task: task, Started: time.Now()}
+	return c.seq
+}
+
+func (c *queueConsumer) untrack(key uint64) {
+	c.runningMu.Lock()
+	defer c.runningMu.Unlock()
+	delete(c.running, key)
+}
+
+// pause cancels the subscription; consume then waits for resume instead of
+// reconnecting.
+func (c *queueConsumer) pause() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	if c.paused {
+		return
+	}
+	c.paused = true
+	c.resumed = make(chan struct{})
+	if c.channel() != nil {
+		c.detached = true
+		c.stopConsuming()
+	}
+	c.log.Info("Consumption paused")
+}
+
+func (c *queueConsumer) resume() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	if !c.paused {
+		return
+	}
+	c.paused = false
+	close(c.resumed)
+	c.log.Info("Consumption resumed")
+}
+
+// pausedUntil returns a channel closed on resume, or nil when not paused.
+func (c *queueConsumer) pausedUntil() <-chan struct{} {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	if !c.paused {
+		return nil
+	}
+	return c.resumed
+}
+
+// takeDetached reports whether the subscription was canceled by pause,
+// rather than lost, and clears the flag.
+func (c *queueConsumer) takeDetached() bool {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	detached := c.detached
+	c.detached = false
+	return detached
+}
+
+// consume forwards deliveries to the worker pool, waiting out pauses, until
+// the subscription is lost (returns true), or ctx is canceled or stop
+// closed (returns false). msgs is nil when the queue was subscribed while
+// paused.
+func (c *queueConsumer) consume(ctx context.Context, msgs <-chan amqp.Delivery, stop <-chan struct{}) bool {
+	for {
+		if msgs != nil {
+			if !c.forward(ctx, msgs) {
+				// Shutting down: hand back what the broker sends
+				// until the subscription is canceled
+				c.requeueAll(msgs)
+				return false
+			}
+			if !c.takeDetached() {
+				c.log.Warn("Deliveries channel closed")
+				return true
+			}
+		}
+		if resumed := c.pausedUntil(); resumed != nil {
+			select {
+			case <-ctx.Done():
+				return false
+			case <-stop:
+				return false
+			case <-resumed:
+			}
+		}
+		var err error
+		if msgs, err = c.startConsuming(); err != nil {
+			c.log.Error("Failed to resume consuming", logging.Err(err))
+			return true
+		}
+	}
+}
+
+// resize grows or shrinks the worker pool to n workers. Prefetch keeps
+// its configured ratio to the pool size; the consumer is restarted to
+// apply it.
+func (c *queueConsumer) resize(n int) error {
+	if min, max := c.cfg.PoolBounds(); n < min || n > max {
+		return fmt.Errorf("%w: %d is not between %d and %d", ErrInvalidConcurrency, n, min, max)
+	}
+	prefetch, err := c.resizePool(n)
+	if err != nil {
+		return err
+	}
+	// Outside of poolMu: startConsuming takes it under pauseMu
+	if prefetch {
+		c.restartConsuming()
+	}
+	return nil
+}
+
+// resizePool starts or stops workers for resize and reports whether the
+// prefetch changed.
+func (c *queueConsumer) resizePool(n int) (bool, error) {
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	if c.stopped || c.wg == nil || c.poolCtx.Err() != nil {
+		return false, ErrStopped
+	}
+
+	from := c.concurrency
+	for ; c.concurrency < n; c.concurrency++ {
+		select {
+		case <-c.quit:
+			// A worker told to stop stays instead
+		default:
+			c.spawn()
+		}
+	}
+	for ; c.concurrency > n; c.concurrency-- {
+		c.quit <- struct{}{}
+	}
+
+	prefetch := c.cfg.Prefetch * n / c.cfg.Concurrency
+	if prefetch < 1 {
+		prefetch = 1
+	}
+	changed := prefetch != c.prefetch
+	c.prefetch = prefetch
+	c.log.Info("Worker pool resized", "from", from, "concurrency", n, "prefetch", c.prefetch)
+	return changed, nil
+}
+
+// restartConsuming cancels the subscription so that consume subscribes
+// again with the current prefetch. Unacked deliveries stay with the
+// channel. A paused queue picks up the prefetch when resumed.
+func (c *queueConsumer) restartConsuming() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	if c.paused || c.channel() == nil {
+		return
+	}
+	c.detached = true
+	c.stopConsuming()
+}
+
+// poolSize returns the current number of workers.
+func (c *queueConsumer) poolSize() int {
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	return c.concurrency
+}
+
+// poolPrefetch returns the prefetch matching the current pool size.
+func (c *queueConsumer) poolPrefetch() int {
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	return c.prefetch
+}
+
+// stopPool prevents resizing once the pool drains, so no worker is
+// started while drainWorkers waits for them.
+func (c *queueConsumer) stopPool() {
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	c.stopped = true
+}
@@ -0,0 +1,184 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/tasks"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizePool(t *testing.T) {
+	resetDrainStats()
+	defer resetDrainStats()
+	task := &blockingTask{started: make(chan struct{}, 8), release: make(chan struct{})}
+	tasks.ClearRegistry()
+	tasks.RegisterTask("block", task)
+	defer tasks.ClearRegistry()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	qc := config.QueueConfig{Name: "q", Concurrency: 2, Prefetch: 4}
+	c := newQueueConsumer(qc, config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 8)
+	ch := &fakeChannel{}
+	c.ch = ch
+	var wg sync.WaitGroup
+	c.startWorkers(ctx, ctx, &wg)
+
+	require.NoError(t, c.resize(3))
+	assert.Equal(t, 3, c.poolSize())
+	assert.Equal(t, 6, c.poolPrefetch(), "prefetch keeps its ratio to the pool size")
+	assert.Equal(t, []string{c.tag}, ch.canceled, "the consumer restarts to apply it")
+
+	for i := 0; i < 4; i++ {
+		d, _ := newTestDelivery(t, "block")
+		c.taskCh <- d
+	}
+	for i := 0; i < 3; i++ {
+		<-task.started
+	}
+	select {
+	case <-task.started:
+		t.Fatal("more tasks running than workers")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Busy workers stop once their task is done
+	require.NoError(t, c.resize(1))
+	assert.Equal(t, 2, c.poolPrefetch())
+	close(task.release)
+	require.Eventually(t, func() bool { return len(c.taskCh) == 0 && Stats().InFlight == 0 },
+		time.Second, 5*time.Millisecond)
+
+	assert.ErrorIs(t, c.resize(0), ErrInvalidConcurrency)
+	assert.ErrorIs(t, c.resize(MaxConcurrency+1), ErrInvalidConcurrency)
+
+	c.stopPool()
+	assert.ErrorIs(t, c.resize(2), ErrStopped)
+	cancel()
+	wg.Wait()
+}
+
+func TestResizeRestartsConsumer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	qc := config.QueueConfig{Name: "q", Concurrency: 2, Prefetch: 4}
+	c := newQueueConsumer(qc, config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	ch := &fakeChannel{deliveries: make(chan amqp.Delivery)}
+	c.ch = ch
+	var wg sync.WaitGroup
+	c.startWorkers(ctx, ctx, &wg)
+
+	msgs := make(chan amqp.Delivery)
+	lost := make(chan bool, 1)
+	go func() { lost <- c.consume(ctx, msgs, nil) }()
+
+	// The new prefetch is set before consuming again: basic.qos does not
+	// apply to a running consumer
+	require.NoError(t, c.resize(3))
+	close(msgs)
+	require.Eventually(t, func() bool { return len(ch.callLog()) == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"cancel", "qos 6", "consume"}, ch.callLog())
+
+	// Same prefetch: no restart
+	require.NoError(t, c.resize(3))
+	assert.Len(t, ch.callLog(), 3)
+
+	cancel()
+	close(ch.deliveries)
+	assert.False(t, <-lost)
+	wg.Wait()
+}
+
+func TestPauseAndResume(t *testing.T) {
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	ch := &fakeChannel{deliveries: make(chan amqp.Delivery, 1)}
+	c.ch = ch
+	setConsumers([]*queueConsumer{c})
+	defer setConsumers(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs := make(chan amqp.Delivery)
+	lost := make(chan bool, 1)
+	go func() { lost <- c.consume(ctx, msgs, nil) }()
+
+	assert.ErrorIs(t, Pause("unknown"), ErrUnknownQueue)
+	require.NoError(t, Pause("go.logger"))
+	require.Equal(t, []string{c.tag}, ch.canceled)
+	status := Queues()[0]
+	assert.True(t, status.Paused)
+	assert.False(t, status.Consuming)
+	for _, check := range ConsumerChecks() {
+		assert.NoError(t, check.Run(context.Background()), "a paused queue is not a failure")
+	}
+
+	// The broker ends the subscription; the consumer waits to be resumed
+	close(msgs)
+	select {
+	case <-lost:
+		t.Fatal("pause was taken for a lost subscription")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, Resume("go.logger"))
+	d, _ := newTestDelivery(t, "any")
+	ch.deliveries <- d
+	select {
+	case <-c.taskCh:
+	case <-time.After(time.Second):
+		t.Fatal("deliveries not forwarded after resume")
+	}
+
+	cancel()
+	close(ch.deliveries)
+	assert.False(t, <-lost)
+	assert.Equal(t, []string{"go.logger"}, ch.consumed)
+	assert.False(t, Queues()[0].Paused)
+}
+
+func TestConsumeReportsLostSubscription(t *testing.T) {
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	msgs := make(chan amqp.Delivery)
+	close(msgs)
+	assert.True(t, c.consume(context.Background(), msgs, nil))
+}
+
+func TestInFlightTasks(t *testing.T) {
+	resetDrainStats()
+	defer resetDrainStats()
+	task := &blockingTask{started: make(chan struct{}, 1), release: make(chan struct{})}
+	tasks.ClearRegistry()
+	tasks.RegisterTask("block", task)
+	defer tasks.ClearRegistry()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	c.ch = &fakeChannel{}
+	setConsumers([]*queueConsumer{c})
+	defer setConsumers(nil)
+	var wg sync.WaitGroup
+	c.startWorkers(ctx, ctx, &wg)
+
+	d, _ := newTestDelivery(t, "block")
+	c.taskCh <- d
+	<-task.started
+
+	running := InFlight()
+	require.Len(t, running, 1)
+	assert.Equal(t, "go.logger", running[0].Queue)
+	assert.Equal(t, "1", running[0].TaskID)
+	assert.Equal(t, "block", running[0].Task)
+	assert.WithinDuration(t, time.Now(), running[0].Started, time.Second)
+
+	close(task.release)
+	require.Eventually(t, func() bool { return len(InFlight()) == 0 }, time.Second, 5*time.Millisecond)
+	cancel()
+	wg.Wait()
+}
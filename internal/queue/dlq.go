@@ -0,0 +1,289 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/tasks"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers added to messages routed to a dead-letter queue by the worker.
+const (
+	HeaderFailureReason   = "x-failure-reason"
+	HeaderFailureAttempts = "x-failure-attempts"
+	HeaderFailureTask     = "x-failure-task"
+	HeaderFailedAt        = "x-failed-at"
+)
+
+// ErrQueueArguments is returned when the work queue already exists with
+// arguments other than the worker's, which RabbitMQ refuses to redeclare.
+var ErrQueueArguments = errors.New("queue exists with different arguments")
+
+// declareQueueError wraps the error of declaring queue, singling out a
+// queue declared with other dead-letter settings.
+func declareQueueError(queue string, err error) error {
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		return fmt.Errorf("%w: %s (%s); delete it or align its dead-letter settings with the worker's",
+			ErrQueueArguments, queue, amqpErr.Reason)
+	}
+	return fmt.Errorf("failed to declare a queue: %w", err)
+}
+
+// declareDeadLetter declares the dead-letter exchange and queue of cfg and
+// returns the arguments the work queue must be declared with.
+func declareDeadLetter(ch *amqp.Channel, cfg config.QueueConfig) (amqp.Table, error) {
+	if !cfg.DeadLetterEnabled() {
+		return nil, nil
+	}
+
+	err := ch.ExchangeDeclare(
+		cfg.DeadLetterExchange, // name
+		"direct",               // type
+		true,                   // durable
+		false,                  // auto-deleted
+		false,                  // internal
+		false,                  // no-wait
+		nil,                    // arguments
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to declare dead-letter exchange: %w", err)
+	}
+
+	_, err = ch.QueueDeclare(
+		cfg.DeadLetterQueue, // name
+		true,                // durable
+		false,               // delete when unused
+		false,               // exclusive
+		false,               // no-wait
+		nil,                 // arguments
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to declare dead-letter queue: %w", err)
+	}
+
+	// The work queue name is used as routing key so several queues can
+	// share one dead-letter exchange
+	if err := ch.QueueBind(cfg.DeadLetterQueue, cfg.Name, cfg.DeadLetterExchange, false, nil); err != nil {
+		return nil, fmt.Errorf("failed to bind dead-letter queue: %w", err)
+	}
+
+	return amqp.Table(cfg.Arguments()), nil
+}
+
+// deadLetterPublishing copies d and annotates it with the failure details.
+func deadLetterPublishing(d amqp.Delivery, res tasks.DispatchResult) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	reason := "unknown error"
+	if res.Error != nil {
+		reason = res.Error.Error()
+	}
+	headers[HeaderFailureReason] = reason
+	headers[HeaderFailureAttempts] = int32(res.Attempt + 1)
+	headers[HeaderFailureTask] = res.Task
+	headers[HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		CorrelationId:   d.CorrelationId,
+		MessageId:       d.MessageId,
+		Body:            d.Body,
+	}
+}
+
+// DeadLetterChannel is the subset of *amqp.Channel used to manage a DLQ.
+type DeadLetterChannel interface {
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	QueuePurge(name string, noWait bool) (int, error)
+}
+
+// DeadLetter describes a message held in a dead-letter queue.
+type DeadLetter struct {
+	TaskID   string          `json:"task_id,omitempty"`
+	Task     string          `json:"task,omitempty"`
+	Reason   string          `json:"reason,omitempty"`
+	Attempts int             `json:"attempts,omitempty"`
+	FailedAt string          `json:"failed_at,omitempty"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// DeadLetterManager inspects, replays and purges the DLQ of one queue.
+type DeadLetterManager struct {
+	ch    DeadLetterChannel
+	queue config.QueueConfig
+}
+
+// NewDeadLetterManager creates a manager for the DLQ of queue, which should
+// already have defaults applied (see config.Config.GetQueues).
+func NewDeadLetterManager(ch DeadLetterChannel, queue config.QueueConfig) (*DeadLetterManager, error) {
+	if !queue.DeadLetterEnabled() {
+		return nil, fmt.Errorf("dead-lettering is disabled for queue %s", queue.Name)
+	}
+	return &DeadLetterManager{ch: ch, queue: queue}, nil
+}
+
+// Inspect returns up to limit messages from the head of the DLQ without
+// removing them. A limit <= 0 returns every message.
+func (m *DeadLetterManager) Inspect(limit int) ([]DeadLetter, error) {
+	var held []amqp.Delivery
+	// Hold messages unacked until we're done so the same message is not
+	// fetched twice, then hand them all back to the queue
+	defer func() {
+		for _, d := range held {
+			_ = d.Nack(false, true)
+		}
+	}()
+
+	var letters []DeadLetter
+	for limit <= 0 || len(letters) < limit {
+		d, ok, err := m.ch.Get(m.queue.DeadLetterQueue, false)
+		if err != nil {
+			return letters, fmt.Errorf("failed to get dead letter: %w", err)
+		}
+		if !ok {
+			break
+		}
+		held = append(held, d)
+		letters = append(letters, describeDeadLetter(d))
+	}
+	return letters, nil
+}
+
+// Replay moves up to limit messages from the DLQ back to the work queue with
+// their attempt counter reset. A limit <= 0 replays the messages present when
+// the call started. It returns the number of replayed messages.
+func (m *DeadLetterManager) Replay(limit int) (int, error) {
+	replayed := 0
+	remaining := -1
+	for (limit <= 0 || replayed < limit) && remaining != 0 {
+		d, ok, err := m.ch.Get(m.queue.DeadLetterQueue, false)
+		if err != nil {
+			return replayed, fmt.Errorf("failed to get dead letter: %w", err)
+		}
+		if !ok {
+			break
+		}
+		if remaining < 0 {
+			// Don't chase messages that fail again while we replay
+			remaining = int(d.MessageCount) + 1
+		}
+		remaining--
+
+		if err := m.ch.Publish(m.queue.Exchange, m.queue.RoutingKey, false, false, replayPublishing(d)); err != nil {
+			_ = d.Nack(false, true)
+			return replayed, fmt.Errorf("failed to republish dead letter: %w", err)
+		}
+		if err := d.Ack(false); err != nil {
+			return replayed, fmt.Errorf("failed to ack dead letter: %w", err)
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+// Purge deletes every message in the DLQ and returns how many were removed.
+func (m *DeadLetterManager) Purge() (int, error) {
+	n, err := m.ch.QueuePurge(m.queue.DeadLetterQueue, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge dead-letter queue: %w", err)
+	}
+	return n, nil
+}
+
+func describeDeadLetter(d amqp.Delivery) DeadLetter {
+	letter := DeadLetter{
+		Task:     headerString(d.Headers, HeaderFailureTask),
+		Reason:   headerString(d.Headers, HeaderFailureReason),
+		Attempts: headerInt(d.Headers, HeaderFailureAttempts),
+		FailedAt: headerString(d.Headers, HeaderFailedAt),
+	}
+	if tasks.IsCeleryMessage(d.Headers) {
+		letter.TaskID = headerString(d.Headers, "id")
+		if letter.Task == "" {
+			letter.Task = headerString(d.Headers, "task")
+		}
+	} else {
+		var envelope tasks.TaskPayload
+		if err := json.Unmarshal(d.Body, &envelope); err == nil {
+			letter.TaskID = envelope.ID
+			if letter.Task == "" {
+				letter.Task = envelope.Task
+			}
+		}
+	}
+	if json.Valid(d.Body) {
+		letter.Body = json.RawMessage(d.Body)
+	} else {
+		letter.Body, _ = json.Marshal(string(d.Body))
+	}
+	if letter.Reason == "" {
+		// Dead-lettered by the broker rather than by the worker
+		if deaths, ok := d.Headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+			if death, ok := deaths[0].(amqp.Table); ok {
+				letter.Reason = headerString(death, "reason")
+			}
+		}
+	}
+	return letter
+}
+
+func replayPublishing(d amqp.Delivery) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		switch k {
+		case HeaderFailureReason, HeaderFailureAttempts, HeaderFailureTask, HeaderFailedAt,
+			"x-death", "x-first-death-exchange", "x-first-death-queue", "x-first-death-reason":
+			continue
+		}
+		headers[k] = v
+	}
+
+	// Start over with a fresh attempt counter
+	body := d.Body
+	if b, h, err := tasks.PrepareRetry(d.Body, headers, 0); err == nil {
+		body, headers = b, h
+	}
+
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		CorrelationId:   d.CorrelationId,
+		MessageId:       d.MessageId,
+		Body:            body,
+	}
+}
+
+func headerString(h amqp.Table, key string) string {
+	if v, ok := h[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func headerInt(h amqp.Table, key string) int {
+	switch v := h[key].(type) {
+	case int:
+		return v
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 0
+}
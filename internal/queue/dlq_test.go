@@ -0,0 +1,151 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/tasks"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDLQChannel is an in-memory DeadLetterChannel backed by a slice.
+type fakeDLQChannel struct {
+	messages  []amqp.Delivery
+	published []amqp.Publishing
+	keys      []string
+	acks      []*fakeAcknowledger
+}
+
+func (f *fakeDLQChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	if len(f.messages) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+	d := f.messages[0]
+	f.messages = f.messages[1:]
+	ack := &fakeAcknowledger{}
+	f.acks = append(f.acks, ack)
+	d.Acknowledger = ack
+	d.MessageCount = uint32(len(f.messages))
+	return d, true, nil
+}
+
+func (f *fakeDLQChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	f.published = append(f.published, msg)
+	f.keys = append(f.keys, exchange+"/"+key)
+	return nil
+}
+
+func (f *fakeDLQChannel) QueuePurge(name string, noWait bool) (int, error) {
+	n := len(f.messages)
+	f.messages = nil
+	return n, nil
+}
+
+func testDLQConfig() config.QueueConfig {
+	return (&config.Config{Queues: []config.QueueConfig{{Name: "go.logger"}}}).GetQueues()[0]
+}
+
+func deadLetterDelivery(t *testing.T, id string, attempt int) amqp.Delivery {
+	t.Helper()
+	body, err := json.Marshal(tasks.TaskPayload{ID: id, Task: "logger", Attempt: attempt, Payload: json.RawMessage(`{}`)})
+	require.NoError(t, err)
+	pub := deadLetterPublishing(
+		amqp.Delivery{Body: body, ContentType: "application/json", Headers: amqp.Table{"trace": "abc"}},
+		tasks.DispatchResult{Error: errors.New("boom"), Task: "logger", TaskID: id, Attempt: attempt},
+	)
+	return amqp.Delivery{Body: pub.Body, Headers: pub.Headers, ContentType: pub.ContentType}
+}
+
+func TestDeadLetterPublishingAnnotates(t *testing.T) {
+	d := deadLetterDelivery(t, "1", 4)
+	assert.Equal(t, "boom", d.Headers[HeaderFailureReason])
+	assert.Equal(t, int32(5), d.Headers[HeaderFailureAttempts])
+	assert.Equal(t, "logger", d.Headers[HeaderFailureTask])
+	assert.NotEmpty(t, d.Headers[HeaderFailedAt])
+	assert.Equal(t, "abc", d.Headers["trace"])
+}
+
+func TestDeclareQueueError(t *testing.T) {
+	err := declareQueueError("go.logger", &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - inequivalent arg 'x-dead-letter-exchange'"})
+	assert.ErrorIs(t, err, ErrQueueArguments)
+	assert.Contains(t, err.Error(), "go.logger")
+
+	err = declareQueueError("go.logger", amqp.ErrClosed)
+	assert.NotErrorIs(t, err, ErrQueueArguments)
+	assert.ErrorIs(t, err, amqp.ErrClosed)
+}
+
+func TestNewDeadLetterManagerDisabled(t *testing.T) {
+	_, err := NewDeadLetterManager(&fakeDLQChannel{}, config.QueueConfig{Name: "q", DisableDeadLetter: true})
+	assert.Error(t, err)
+}
+
+func TestDeadLetterManagerInspect(t *testing.T) {
+	ch := &fakeDLQChannel{messages: []amqp.Delivery{
+		deadLetterDelivery(t, "1", 4),
+		deadLetterDelivery(t, "2", 2),
+		{Body: []byte("not json"), Headers: amqp.Table{
+			"x-death": []interface{}{amqp.Table{"reason": "rejected"}},
+		}},
+	}}
+	mgr, err := NewDeadLetterManager(ch, testDLQConfig())
+	require.NoError(t, err)
+
+	letters, err := mgr.Inspect(0)
+	require.NoError(t, err)
+	require.Len(t, letters, 3)
+	assert.Equal(t, "1", letters[0].TaskID)
+	assert.Equal(t, "logger", letters[0].Task)
+	assert.Equal(t, "boom", letters[0].Reason)
+	assert.Equal(t, 5, letters[0].Attempts)
+	assert.Equal(t, "rejected", letters[2].Reason)
+	assert.JSONEq(t, `"not json"`, string(letters[2].Body))
+
+	// Messages are handed back to the queue
+	for _, ack := range ch.acks {
+		assert.Equal(t, 1, ack.nacked)
+		assert.True(t, ack.requeue)
+	}
+}
+
+func TestDeadLetterManagerReplay(t *testing.T) {
+	ch := &fakeDLQChannel{messages: []amqp.Delivery{
+		deadLetterDelivery(t, "1", 4),
+		deadLetterDelivery(t, "2", 2),
+		deadLetterDelivery(t, "3", 1),
+	}}
+	qc := testDLQConfig()
+	mgr, err := NewDeadLetterManager(ch, qc)
+	require.NoError(t, err)
+
+	n, err := mgr.Replay(2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Len(t, ch.messages, 1)
+	require.Len(t, ch.published, 2)
+	assert.Equal(t, qc.Exchange+"/"+qc.RoutingKey, ch.keys[0])
+
+	msg := ch.published[0]
+	assert.NotContains(t, msg.Headers, HeaderFailureReason)
+	assert.Equal(t, "abc", msg.Headers["trace"])
+	var envelope tasks.TaskPayload
+	require.NoError(t, json.Unmarshal(msg.Body, &envelope))
+	assert.Equal(t, "1", envelope.ID)
+	assert.Equal(t, 0, envelope.Attempt)
+	assert.Equal(t, 1, ch.acks[0].acked)
+}
+
+func TestDeadLetterManagerPurge(t *testing.T) {
+	ch := &fakeDLQChannel{messages: []amqp.Delivery{deadLetterDelivery(t, "1", 0)}}
+	mgr, err := NewDeadLetterManager(ch, testDLQConfig())
+	require.NoError(t, err)
+
+	n, err := mgr.Purge()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
@@ -0,0 +1,122 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"base-go-app/internal/logging"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// cancelWait is how long workers get to return once their tasks were
+// canceled at the end of the grace period.
+const cancelWait = 5 * time.Second
+
+// Shutdown accounting, reported by the health endpoint
+var (
+	draining int32
+	inFlight int64
+	requeued int64
+)
+
+// DrainStats describes the tasks of the worker during shutdown.
+type DrainStats struct {
+	// Draining is set once the worker stopped consuming to shut down.
+	Draining bool `json:"draining"`
+	// InFlight is the number of tasks being executed.
+	InFlight int64 `json:"in_flight"`
+	// Requeued is the number of deliveries handed back to the broker
+	// because the worker is shutting down.
+	Requeued int64 `json:"requeued"`
+}
+
+// Stats returns the current drain state.
+func Stats() DrainStats {
+	return DrainStats{
+		Draining: atomic.LoadInt32(&draining) == 1,
+		InFlight: atomic.LoadInt64(&inFlight),
+		Requeued: atomic.LoadInt64(&requeued),
+	}
+}
+
+// stopConsuming cancels the subscription (basic.cancel) so the broker
+// stops sending deliveries; the deliveries channel closes once the ones
+// already sent have been received. Unacked deliveries stay with this
+// channel so in-flight tasks can still ack them.
+func (c *queueConsumer) stopConsuming() {
+	ch := c.channel()
+	if ch == nil {
+		return
+	}
+	if err := ch.Cancel(c.tag, false); err != nil {
+		// The channel is likely gone already; closing it ends the
+		// deliveries channel, and the broker requeues what is unacked
+		c.log.Warn("Failed to cancel consumer", logging.Err(err))
+		c.closeChannel()
+	}
+}
+
+// requeue hands a delivery that was not started back to the broker.
+func (c *queueConsumer) requeue(d amqp.Delivery) {
+	if err := d.Nack(false, true); err != nil {
+		c.log.Warn("Failed to requeue delivery", logging.Err(err))
+		return
+	}
+	atomic.AddInt64(&requeued, 1)
+}
+
+// requeueAll requeues deliveries until msgs is closed.
+func (c *queueConsumer) requeueAll(msgs <-chan amqp.Delivery) {
+	for d := range msgs {
+		c.requeue(d)
+	}
+}
+
+// requeueBuffered requeues the deliveries waiting in taskCh. Nothing may
+// send to taskCh anymore.
+func (c *queueConsumer) requeueBuffered() {
+	for {
+		select {
+		case d := <-c.taskCh:
+			c.requeue(d)
+		default:
+			return
+		}
+	}
+}
+
+// drainWorkers requeues the buffered deliveries of consumers and waits up
+// to grace for the tasks in flight. Then it cancels them with cancelTasks
+// and waits a little longer; tasks still running after that are abandoned,
+// and their messages are requeued by the broker once the connection closes.
+func drainWorkers(consumers []*queueConsumer, wg *sync.WaitGroup, grace time.Duration, cancelTasks context.CancelFunc) {
+	atomic.StoreInt32(&draining, 1)
+	for _, c := range consumers {
+		c.stopPool()
+		c.requeueBuffered()
+	}
+	slog.Info("Draining: waiting for tasks in flight", "grace", grace, "in_flight", atomic.LoadInt64(&inFlight))
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(grace):
+		slog.Warn("Draining: grace period expired, canceling tasks in flight", "in_flight", atomic.LoadInt64(&inFlight))
+		cancelTasks()
+		select {
+		case <-finished:
+		case <-time.After(cancelWait):
+			slog.Warn("Draining: abandoning tasks that ignored cancellation", "in_flight", atomic.LoadInt64(&inFlight))
+		}
+	}
+	slog.Info("Drained", "requeued", atomic.LoadInt64(&requeued), "in_flight", atomic.LoadInt64(&inFlight))
+}
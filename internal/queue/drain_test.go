@@ -0,0 +1,165 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/tasks"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingTask runs until released or until its context is canceled.
+type blockingTask struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingTask) Handle(ctx context.Context, payload json.RawMessage) error {
+	b.started <- struct{}{}
+	select {
+	case <-b.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func resetDrainStats() {
+	atomic.StoreInt32(&draining, 0)
+	atomic.StoreInt64(&inFlight, 0)
+	atomic.StoreInt64(&requeued, 0)
+}
+
+func TestDrainLetsInFlightTasksFinish(t *testing.T) {
+	resetDrainStats()
+	defer resetDrainStats()
+	task := &blockingTask{started: make(chan struct{}, 1), release: make(chan struct{})}
+	tasks.ClearRegistry()
+	tasks.RegisterTask("block", task)
+	defer tasks.ClearRegistry()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	taskCtx, cancelTasks := context.WithCancel(context.Background())
+	defer cancelTasks()
+
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 4)
+	c.ch = &fakeChannel{}
+	var wg sync.WaitGroup
+	c.startWorkers(ctx, taskCtx, &wg)
+
+	running, runningAck := newTestDelivery(t, "block")
+	c.taskCh <- running
+	<-task.started
+	assert.Equal(t, int64(1), Stats().InFlight)
+
+	// Buffered behind the running task
+	waiting, waitingAck := newTestDelivery(t, "block")
+	c.taskCh <- waiting
+
+	cancel()
+	drained := make(chan struct{})
+	go func() {
+		drainWorkers([]*queueConsumer{c}, &wg, 5*time.Second, cancelTasks)
+		close(drained)
+	}()
+
+	require.Eventually(t, func() bool { return Stats().Requeued == 1 }, time.Second, 5*time.Millisecond)
+	assert.True(t, Stats().Draining)
+	waitingAck.mu.Lock()
+	assert.Equal(t, 1, waitingAck.nacked)
+	assert.True(t, waitingAck.requeue)
+	waitingAck.mu.Unlock()
+
+	close(task.release)
+	select {
+	case <-drained:
+	case <-time.After(2 * time.Second):
+		t.Fatal("drain did not finish")
+	}
+	assert.NoError(t, taskCtx.Err(), "tasks finished within the grace period")
+	assert.Equal(t, 1, runningAck.acked)
+	assert.Equal(t, int64(0), Stats().InFlight)
+}
+
+func TestDrainCancelsTasksAfterGracePeriod(t *testing.T) {
+	resetDrainStats()
+	defer resetDrainStats()
+	task := &blockingTask{started: make(chan struct{}, 1), release: make(chan struct{})}
+	tasks.ClearRegistry()
+	tasks.RegisterTask("block", task)
+	defer tasks.ClearRegistry()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	taskCtx, cancelTasks := context.WithCancel(context.Background())
+	defer cancelTasks()
+
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	ch := &fakeChannel{}
+	c.ch = ch
+	var wg sync.WaitGroup
+	c.startWorkers(ctx, taskCtx, &wg)
+
+	d, ack := newTestDelivery(t, "block")
+	c.taskCh <- d
+	<-task.started
+
+	cancel()
+	drainWorkers([]*queueConsumer{c}, &wg, 50*time.Millisecond, cancelTasks)
+
+	// Requeued as is rather than retried: no attempt is used up
+	assert.Equal(t, 0, ack.acked)
+	assert.Equal(t, 1, ack.nacked)
+	assert.True(t, ack.requeue)
+	assert.Empty(t, ch.published)
+	assert.Equal(t, int64(1), Stats().Requeued)
+}
+
+func TestForwardRequeuesOnShutdown(t *testing.T) {
+	resetDrainStats()
+	defer resetDrainStats()
+
+	// No workers and a full buffer: the delivery cannot be handed over
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	blocked, _ := newTestDelivery(t, "any")
+	c.taskCh <- blocked
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs := make(chan amqp.Delivery, 2)
+	d, ack := newTestDelivery(t, "any")
+	msgs <- d
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	assert.False(t, c.forward(ctx, msgs))
+	assert.Equal(t, 1, ack.nacked)
+	assert.True(t, ack.requeue)
+
+	late, lateAck := newTestDelivery(t, "any")
+	msgs <- late
+	close(msgs)
+	c.requeueAll(msgs)
+	assert.Equal(t, 1, lateAck.nacked)
+	assert.Equal(t, int64(2), Stats().Requeued)
+}
+
+func TestStopConsumingCancelsSubscription(t *testing.T) {
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	ch := &fakeChannel{}
+	c.ch = ch
+
+	c.stopConsuming()
+	require.Len(t, ch.canceled, 1)
+	assert.Equal(t, c.tag, ch.canceled[0])
+	assert.Contains(t, c.tag, "go.logger")
+	// The channel stays open for in-flight acks
+	assert.NotNil(t, c.channel())
+}
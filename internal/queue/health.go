@@ -0,0 +1,153 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"base-go-app/internal/health"
+)
+
+// consumers of the running StartConsumer, reported by the health checks
+var (
+	consumersMu     sync.RWMutex
+	activeConsumers []*queueConsumer
+)
+
+func setConsumers(consumers []*queueConsumer) {
+	consumersMu.Lock()
+	defer consumersMu.Unlock()
+	activeConsumers = consumers
+}
+
+func getConsumers() []*queueConsumer {
+	consumersMu.RLock()
+	defer consumersMu.RUnlock()
+	return activeConsumers
+}
+
+// QueueStatus describes a consumed queue.
+type QueueStatus struct {
+	Name string `json:"name"`
+	// Consuming is set while the queue's subscription is open.
+	Consuming bool `json:"consuming"`
+	// Paused is set while consumption is paused through the admin API.
+	Paused      bool  `json:"paused"`
+	Concurrency int   `json:"concurrency"`
+	Prefetch    int   `json:"prefetch"`
+	InFlight    int64 `json:"in_flight"`
+	// Buffered and BufferSize describe the in-memory task buffer.
+	Buffered   int `json:"buffered"`
+	BufferSize int `json:"buffer_size"`
+	// LastDone is when a task of the queue last finished (or the workers
+	// started).
+	LastDone time.Time `json:"last_done"`
+}
+
+// Saturated reports whether every worker is busy and the buffer is full.
+func (s QueueStatus) Saturated() bool {
+	return s.InFlight >= int64(s.Concurrency) && s.Buffered >= s.BufferSize
+}
+
+// Queues returns the status of every consumed queue.
+func Queues() []QueueStatus {
+	consumers := getConsumers()
+	out := make([]QueueStatus, len(consumers))
+	for i, c := range consumers {
+		out[i] = c.status()
+	}
+	return out
+}
+
+func (c *queueConsumer) status() QueueStatus {
+	paused := c.pausedUntil() != nil
+	return QueueStatus{
+		Name:        c.cfg.Name,
+		Consuming:   c.channel() != nil && !paused,
+		Paused:      paused,
+		Concurrency: c.poolSize(),
+		Prefetch:    c.poolPrefetch(),
+		InFlight:    atomic.LoadInt64(&c.inFlight),
+		Buffered:    len(c.taskCh),
+		BufferSize:  cap(c.taskCh),
+		LastDone:    time.Unix(0, atomic.LoadInt64(&c.lastDone)),
+	}
+}
+
+// BrokerCheck fails while the consumer is not connected to RabbitMQ.
+func BrokerCheck() health.Check {
+	return health.Check{Name: "rabbitmq", Critical: true, Run: func(context.Context) error {
+		if !RabbitConnected() {
+			return errors.New("not connected to RabbitMQ")
+		}
+		return nil
+	}}
+}
+
+// DrainCheck fails once the worker started draining to shut down, or
+// while it is drained through Drain, so that it is taken out of service.
+func DrainCheck() health.Check {
+	return health.Check{Name: "draining", Critical: true, Run: func(context.Context) error {
+		if Stats().Draining {
+			return errors.New("worker is draining")
+		}
+		if Drained() {
+			return errors.New("worker is drained")
+		}
+		return nil
+	}}
+}
+
+// ConsumerChecks returns a check per consumed queue that fails while the
+// queue is not subscribed, unless it was paused on purpose. Call it after
+// StartConsumer.
+func ConsumerChecks() []health.Check {
+	var checks []health.Check
+	for _, c := range getConsumers() {
+		c := c
+		checks = append(checks, health.Check{Name: "queue:" + c.cfg.Name, Critical: true, Run: func(context.Context) error {
+			if c.channel() == nil && c.pausedUntil() == nil {
+				return fmt.Errorf("not consuming from %s", c.cfg.Name)
+			}
+			return nil
+		}})
+	}
+	return checks
+}
+
+// SaturationCheck fails while a queue's workers are all busy and its
+// buffer is full. It is not critical: the worker keeps making progress.
+func SaturationCheck() health.Check {
+	return health.Check{Name: "worker_pool", Run: func(context.Context) error {
+		var saturated []string
+		for _, s := range Queues() {
+			if s.Saturated() {
+				saturated = append(saturated, fmt.Sprintf("%s (%d/%d busy, %d/%d buffered)",
+					s.Name, s.InFlight, s.Concurrency, s.Buffered, s.BufferSize))
+			}
+		}
+		if len(saturated) > 0 {
+			return fmt.Errorf("saturated: %s", strings.Join(saturated, ", "))
+		}
+		return nil
+	}}
+}
+
+// StallCheck fails when every worker of a queue has been busy without any
+// task finishing for longer than after, i.e. the pool is stuck. It is a
+// liveness check: restarting the process is the only remedy.
+func StallCheck(after time.Duration) health.Check {
+	return health.Check{Name: "workers", Critical: true, Run: func(context.Context) error {
+		for _, s := range Queues() {
+			if s.InFlight >= int64(s.Concurrency) && time.Since(s.LastDone) > after {
+				return fmt.Errorf("%s: all %d workers busy, no task finished for %s",
+					s.Name, s.Concurrency, time.Since(s.LastDone).Round(time.Second))
+			}
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/tasks"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestQueueHealthChecks(t *testing.T) {
+	c := newQueueConsumer(config.QueueConfig{Name: "q", Concurrency: 1}, config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	atomic.StoreInt64(&c.lastDone, time.Now().UnixNano())
+	setConsumers([]*queueConsumer{c})
+	defer setConsumers(nil)
+
+	consumers := ConsumerChecks()
+	if len(consumers) != 1 || consumers[0].Name != "queue:q" {
+		t.Fatalf("unexpected consumer checks: %+v", consumers)
+	}
+	if err := consumers[0].Run(context.Background()); err == nil {
+		t.Fatalf("expected the unsubscribed queue to fail")
+	}
+	c.ch = &fakeChannel{}
+	if err := consumers[0].Run(context.Background()); err != nil {
+		t.Fatalf("expected the subscribed queue to pass, got %v", err)
+	}
+
+	saturation, stall := SaturationCheck(), StallCheck(time.Minute)
+	if err := saturation.Run(context.Background()); err != nil {
+		t.Fatalf("idle pool reported saturated: %v", err)
+	}
+
+	// Every worker busy and the buffer full
+	atomic.StoreInt64(&c.inFlight, 1)
+	c.taskCh <- amqp.Delivery{}
+	if err := saturation.Run(context.Background()); err == nil {
+		t.Fatalf("expected saturation")
+	}
+	if err := stall.Run(context.Background()); err != nil {
+		t.Fatalf("busy pool reported stalled: %v", err)
+	}
+
+	// ... and nothing finished for too long
+	atomic.StoreInt64(&c.lastDone, time.Now().Add(-2*time.Minute).UnixNano())
+	if err := stall.Run(context.Background()); err == nil {
+		t.Fatalf("expected a stall")
+	}
+
+	status := Queues()[0]
+	if !status.Consuming || status.InFlight != 1 || status.Buffered != 1 || status.BufferSize != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
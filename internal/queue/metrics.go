@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"base-go-app/internal/metrics"
+	"base-go-app/internal/tasks"
+)
+
+func init() {
+	metrics.TasksInFlight.Set(func() float64 { return float64(atomic.LoadInt64(&inFlight)) })
+}
+
+// registerMetrics exposes the occupancy of the consumer's task buffer and
+// the size of its worker pool.
+func (c *queueConsumer) registerMetrics() {
+	metrics.TaskBuffer.Set(func() float64 { return float64(len(c.taskCh)) }, c.cfg.Name)
+	metrics.TaskBufferCapacity.WithLabelValues(c.cfg.Name).Set(float64(cap(c.taskCh)))
+	metrics.WorkerPoolSize.Set(func() float64 { return float64(c.poolSize()) }, c.cfg.Name)
+}
+
+// taskLabel returns the task label of a result. Names of unregistered
+// tasks come from the messages, so they share one label.
+func taskLabel(task string) string {
+	if _, ok := tasks.LookupTask(task); !ok {
+		return "unknown"
+	}
+	return task
+}
+
+// deferred reports whether a task was put back without running, which is
+// not a failure.
+func deferred(res tasks.DispatchResult) bool {
+	return errors.Is(res.Error, tasks.ErrNotDue) || errors.Is(res.Error, tasks.ErrDuplicateInProgress) || throttled(res)
+}
+
+// throttled reports whether a task was held back by the limits of its type.
+func throttled(res tasks.DispatchResult) bool {
+	return errors.Is(res.Error, tasks.ErrConcurrencyLimited) || errors.Is(res.Error, tasks.ErrRateLimited)
+}
+
+// observe records the outcome of a dispatch.
+func (c *queueConsumer) observe(res tasks.DispatchResult) {
+	task := taskLabel(res.Task)
+	if !res.Interrupted && !deferred(res) {
+		// The others come back and are counted then
+		metrics.TasksReceived.WithLabelValues(task, c.cfg.Name).Inc()
+	}
+	if res.Duration > 0 {
+		metrics.TaskDuration.WithLabelValues(task, c.cfg.Name).Observe(res.Duration.Seconds())
+	}
+	switch {
+	case res.Success:
+		metrics.TasksSucceeded.WithLabelValues(task, c.cfg.Name).Inc()
+	case throttled(res):
+		metrics.TasksThrottled.WithLabelValues(task, c.cfg.Name).Inc()
+	case res.Interrupted, deferred(res):
+	default:
+		metrics.TasksFailed.WithLabelValues(task, c.cfg.Name).Inc()
+	}
+}
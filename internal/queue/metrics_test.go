@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/metrics"
+	"base-go-app/internal/tasks"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// observations returns how many values a histogram series observed.
+func observations(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, o.(prometheus.Metric).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestProcessRecordsMetrics(t *testing.T) {
+	tasks.ClearRegistry()
+	tasks.RegisterTask("ok_task", okHandler{})
+	tasks.RegisterTask("err_task", errHandler{})
+	defer tasks.ClearRegistry()
+
+	// A queue name of its own, as metrics are global
+	c := newQueueConsumer(config.QueueConfig{Name: "metrics.q", Concurrency: 1}, config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 3)
+	c.ch = &fakeChannel{}
+	c.registerMetrics()
+
+	run := func(p tasks.TaskPayload) {
+		body, _ := json.Marshal(p)
+		c.process(context.Background(), amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: body})
+	}
+	run(tasks.TaskPayload{ID: "1", Task: "ok_task", Payload: json.RawMessage(`{}`)})
+	run(tasks.TaskPayload{ID: "2", Task: "err_task", MaxAttempts: 3, Payload: json.RawMessage(`{}`)})
+	run(tasks.TaskPayload{ID: "3", Task: "err_task", Attempt: 2, MaxAttempts: 3, Payload: json.RawMessage(`{}`)})
+	run(tasks.TaskPayload{ID: "4", Task: "no_such_task", Payload: json.RawMessage(`{}`)})
+	// Put back until its ETA: received when it comes back
+	run(tasks.TaskPayload{ID: "5", Task: "ok_task", ETA: time.Now().Add(time.Hour).Format(time.RFC3339), Payload: json.RawMessage(`{}`)})
+	c.taskCh <- amqp.Delivery{}
+
+	count := func(v *prometheus.CounterVec, task string) float64 {
+		return testutil.ToFloat64(v.WithLabelValues(task, "metrics.q"))
+	}
+	assert.Equal(t, 1.0, count(metrics.TasksReceived, "ok_task"))
+	assert.Equal(t, 1.0, count(metrics.TasksSucceeded, "ok_task"))
+	assert.Equal(t, 2.0, count(metrics.TasksReceived, "err_task"))
+	assert.Equal(t, 2.0, count(metrics.TasksFailed, "err_task"))
+	assert.Equal(t, 1.0, count(metrics.TasksRetried, "err_task"))
+	assert.Equal(t, 1.0, count(metrics.TasksDeadLettered, "err_task"))
+	assert.Equal(t, 1.0, count(metrics.TasksDeadLettered, "unknown"))
+	assert.Equal(t, uint64(1), observations(t, metrics.TaskDuration.WithLabelValues("ok_task", "metrics.q")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.TaskBuffer.With("metrics.q")))
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.TaskBufferCapacity.WithLabelValues("metrics.q")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.TasksInFlight))
+}
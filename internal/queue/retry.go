@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+
+	"base-go-app/internal/config"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// retryQueueName returns the TTL wait queue holding retries of queue for delay.
+func retryQueueName(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", queue, delay.Milliseconds())
+}
+
+// retryQueueArgs returns the arguments of a TTL wait queue: messages expire
+// after delay and are dead-lettered back to the work queue's binding. The
+// queue itself is removed once it has been idle for a while.
+func retryQueueArgs(cfg config.QueueConfig, delay time.Duration) amqp.Table {
+	ttl := delay.Milliseconds()
+	return amqp.Table{
+		"x-message-ttl":             ttl,
+		"x-dead-letter-exchange":    cfg.Exchange,
+		"x-dead-letter-routing-key": cfg.RoutingKey,
+		"x-expires":                 2*ttl + time.Minute.Milliseconds(),
+	}
+}
+
+// delayedExchangeName returns the x-delayed-message exchange used for
+// retries of cfg when RetryDelayPlugin is enabled.
+func delayedExchangeName(cfg config.QueueConfig) string {
+	return cfg.Exchange + ".delayed"
+}
+
+// declareDelayedExchange declares the plugin-backed delayed exchange and binds
+// the work queue to it.
+func declareDelayedExchange(ch *amqp.Channel, cfg config.QueueConfig) error {
+	name := delayedExchangeName(cfg)
+	err := ch.ExchangeDeclare(
+		name,                // name
+		"x-delayed-message", // type
+		true,                // durable
+		false,               // auto-deleted
+		false,               // internal
+		false,               // no-wait
+		amqp.Table{"x-delayed-type": "direct"},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare delayed exchange (is rabbitmq_delayed_message_exchange enabled?): %w", err)
+	}
+	if err := ch.QueueBind(cfg.Name, cfg.RoutingKey, name, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue to delayed exchange: %w", err)
+	}
+	return nil
+}
+
+// publishRetry publishes msg so that it reaches the work queue again after
+// delay, using either a TTL wait queue or the delayed-message exchange.
+func (c *queueConsumer) publishRetry(ch amqpChannel, msg amqp.Publishing, delay time.Duration) error {
+	if delay <= 0 {
+		return ch.Publish(c.cfg.Exchange, c.cfg.RoutingKey, false, false, msg)
+	}
+
+	if c.retryMode == config.RetryDelayPlugin {
+		if msg.Headers == nil {
+			msg.Headers = amqp.Table{}
+		}
+		msg.Headers["x-delay"] = delay.Milliseconds()
+		return ch.Publish(delayedExchangeName(c.cfg), c.cfg.RoutingKey, false, false, msg)
+	}
+
+	// Declaring on every retry also keeps the wait queue from expiring
+	name := retryQueueName(c.cfg.Name, delay)
+	_, err := ch.QueueDeclare(
+		name,                         // name
+		true,                         // durable
+		false,                        // delete when unused
+		false,                        // exclusive
+		false,                        // no-wait
+		retryQueueArgs(c.cfg, delay), // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare retry queue %s: %w", name, err)
+	}
+	// Publish through the default exchange straight into the wait queue
+	return ch.Publish("", name, false, false, msg)
+}
@@ -0,0 +1,397 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/idempotency"
+	"base-go-app/internal/tasks"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type publishedMsg struct {
+	exchange string
+	key      string
+	msg      amqp.Publishing
+}
+
+// fakeChannel records publishes, queue declarations and subscriptions.
+type fakeChannel struct {
+	published []publishedMsg
+	declared  map[string]amqp.Table
+	canceled  []string
+	consumed  []string
+	qos       []int
+	failWith  error
+	// deliveries is returned by Consume when set
+	deliveries chan amqp.Delivery
+	// depth is the message count reported by QueueDeclarePassive
+	depth int
+
+	// confirms receives a confirmation of each publish once in confirm
+	// mode, a nack when nack is set
+	confirms chan amqp.Confirmation
+	nack     bool
+
+	// calls logs Qos, Cancel and Consume in order
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeChannel) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeChannel) callLog() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if f.failWith != nil {
+		return f.failWith
+	}
+	f.published = append(f.published, publishedMsg{exchange, key, msg})
+	if f.confirms != nil {
+		f.confirms <- amqp.Confirmation{DeliveryTag: uint64(len(f.published)), Ack: !f.nack}
+	}
+	return nil
+}
+
+func (f *fakeChannel) Confirm(noWait bool) error { return f.failWith }
+
+func (f *fakeChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	f.confirms = confirm
+	return confirm
+}
+
+func (f *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	if f.declared == nil {
+		f.declared = map[string]amqp.Table{}
+	}
+	f.declared[name] = args
+	return amqp.Queue{Name: name}, nil
+}
+
+func (f *fakeChannel) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	return amqp.Queue{Name: name, Messages: f.depth}, nil
+}
+
+func (f *fakeChannel) Cancel(consumer string, noWait bool) error {
+	f.canceled = append(f.canceled, consumer)
+	f.record("cancel")
+	return nil
+}
+
+func (f *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	f.consumed = append(f.consumed, queue)
+	f.record("consume")
+	if f.deliveries != nil {
+		return f.deliveries, nil
+	}
+	return make(chan amqp.Delivery), nil
+}
+
+func (f *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	f.qos = append(f.qos, prefetchCount)
+	f.record(fmt.Sprintf("qos %d", prefetchCount))
+	return nil
+}
+
+func (f *fakeChannel) Close() error { return nil }
+
+func testQueueConfig() config.QueueConfig {
+	return (&config.Config{Queues: []config.QueueConfig{{Name: "go.logger", Concurrency: 1}}}).GetQueues()[0]
+}
+
+func TestRetryQueueArgs(t *testing.T) {
+	qc := testQueueConfig()
+	assert.Equal(t, "go.logger.retry.4000ms", retryQueueName(qc.Name, 4*time.Second))
+	args := retryQueueArgs(qc, 4*time.Second)
+	assert.Equal(t, int64(4000), args["x-message-ttl"])
+	assert.Equal(t, "celery", args["x-dead-letter-exchange"])
+	assert.Equal(t, "go.logger", args["x-dead-letter-routing-key"])
+	assert.Greater(t, args["x-expires"].(int64), int64(4000))
+}
+
+func TestPublishRetryTTL(t *testing.T) {
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, nil, 1)
+	ch := &fakeChannel{}
+
+	require.NoError(t, c.publishRetry(ch, amqp.Publishing{Body: []byte("x")}, 2*time.Second))
+	require.Len(t, ch.published, 1)
+	assert.Equal(t, "", ch.published[0].exchange)
+	assert.Equal(t, "go.logger.retry.2000ms", ch.published[0].key)
+	assert.Contains(t, ch.declared, "go.logger.retry.2000ms")
+}
+
+func TestPublishRetryPlugin(t *testing.T) {
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayPlugin, nil, 1)
+	ch := &fakeChannel{}
+
+	require.NoError(t, c.publishRetry(ch, amqp.Publishing{Body: []byte("x")}, 2*time.Second))
+	require.Len(t, ch.published, 1)
+	assert.Equal(t, "celery.delayed", ch.published[0].exchange)
+	assert.Equal(t, "go.logger", ch.published[0].key)
+	assert.Equal(t, int64(2000), ch.published[0].msg.Headers["x-delay"])
+	assert.Empty(t, ch.declared)
+}
+
+func TestPublishRetryImmediate(t *testing.T) {
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, nil, 1)
+	ch := &fakeChannel{}
+
+	require.NoError(t, c.publishRetry(ch, amqp.Publishing{Body: []byte("x")}, 0))
+	require.Len(t, ch.published, 1)
+	assert.Equal(t, "celery", ch.published[0].exchange)
+	assert.Equal(t, "go.logger", ch.published[0].key)
+}
+
+type errHandler struct{}
+
+func (errHandler) Handle(ctx context.Context, payload json.RawMessage) error {
+	return errors.New("boom")
+}
+
+func TestProcessSchedulesDelayedRetry(t *testing.T) {
+	tasks.ClearRegistry()
+	tasks.RegisterTask("err_task", errHandler{})
+	defer tasks.ClearRegistry()
+	t.Setenv("BACKOFF_ENABLED", "true")
+	t.Setenv("BACKOFF_INITIAL_SECONDS", "3")
+
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	ch := &fakeChannel{}
+	c.ch = ch
+
+	body, _ := json.Marshal(tasks.TaskPayload{ID: "1", Task: "err_task", Attempt: 1, MaxAttempts: 5, Payload: json.RawMessage(`{}`)})
+	ack := &fakeAcknowledger{}
+	c.process(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body, Headers: amqp.Table{"x-death": "old", "keep": "me"}})
+
+	assert.Equal(t, 1, ack.acked)
+	require.Len(t, ch.published, 1)
+	// Attempt 1 failed: 3s * 2^1
+	assert.Equal(t, "go.logger.retry.6000ms", ch.published[0].key)
+	assert.Equal(t, "me", ch.published[0].msg.Headers["keep"])
+	assert.NotContains(t, ch.published[0].msg.Headers, "x-death")
+
+	var envelope tasks.TaskPayload
+	require.NoError(t, json.Unmarshal(ch.published[0].msg.Body, &envelope))
+	assert.Equal(t, 2, envelope.Attempt)
+}
+
+func TestProcessDelaysDuplicateInProgress(t *testing.T) {
+	tasks.ClearRegistry()
+	tasks.RegisterTask("ok_task", okHandler{})
+	defer tasks.ClearRegistry()
+	t.Setenv("BACKOFF_ENABLED", "")
+
+	store := idempotency.NewMemoryStore(time.Hour)
+	_, err := store.Acquire(context.Background(), "k", "other-worker", time.Minute)
+	require.NoError(t, err)
+	d := tasks.NewDispatcher(nil, nil)
+	d.Idempotency = store
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, d, 1)
+	ch := &fakeChannel{}
+	c.ch = ch
+
+	body, _ := json.Marshal(tasks.TaskPayload{ID: "1", Task: "ok_task", IdempotencyKey: "k", MaxAttempts: 5, Payload: json.RawMessage(`{}`)})
+	ack := &fakeAcknowledger{}
+	c.process(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+	// Put back with a delay even without backoff, not requeued hot
+	assert.Equal(t, 1, ack.acked)
+	require.Len(t, ch.published, 1)
+	assert.Equal(t, "go.logger.retry.1000ms", ch.published[0].key)
+}
+
+func TestProcessDeadLettersWhenRetriesExhausted(t *testing.T) {
+	tasks.ClearRegistry()
+	tasks.RegisterTask("err_task", errHandler{})
+	defer tasks.ClearRegistry()
+
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	ch := &fakeChannel{}
+	c.ch = ch
+
+	body, _ := json.Marshal(tasks.TaskPayload{ID: "1", Task: "err_task", Attempt: 4, MaxAttempts: 5, Payload: json.RawMessage(`{}`)})
+	ack := &fakeAcknowledger{}
+	c.process(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+	assert.Equal(t, 1, ack.acked)
+	require.Len(t, ch.published, 1)
+	assert.Equal(t, "celery.dlx", ch.published[0].exchange)
+	assert.Equal(t, "boom", ch.published[0].msg.Headers[HeaderFailureReason])
+}
+
+func TestProcessRejectsWhenRetryPublishFails(t *testing.T) {
+	tasks.ClearRegistry()
+	tasks.RegisterTask("err_task", errHandler{})
+	defer tasks.ClearRegistry()
+
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	c.ch = &fakeChannel{failWith: errors.New("channel closed")}
+
+	body, _ := json.Marshal(tasks.TaskPayload{ID: "1", Task: "err_task", MaxAttempts: 5, Payload: json.RawMessage(`{}`)})
+	ack := &fakeAcknowledger{}
+	c.process(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+	assert.Equal(t, 0, ack.acked)
+	assert.Equal(t, 1, ack.nacked)
+	assert.False(t, ack.requeue)
+}
+
+func TestProcessRetriesCeleryMessage(t *testing.T) {
+	tasks.ClearRegistry()
+	tasks.RegisterTask("err_task", errHandler{})
+	defer tasks.ClearRegistry()
+
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	ch := &fakeChannel{}
+	c.ch = ch
+
+	body := []byte(`[[{"a":1}], {}, {}]`)
+	ack := &fakeAcknowledger{}
+	c.process(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		Body:         body,
+		Headers:      amqp.Table{"lang": "py", "task": "err_task", "id": "c-1", "retries": int32(0)},
+	})
+
+	assert.Equal(t, 1, ack.acked)
+	require.Len(t, ch.published, 1)
+	assert.Equal(t, body, ch.published[0].msg.Body)
+	assert.Equal(t, int32(1), ch.published[0].msg.Headers["retries"])
+	assert.Equal(t, "err_task", ch.published[0].msg.Headers["task"])
+}
+
+func TestProcessRedelaysEarlyMessage(t *testing.T) {
+	tasks.ClearRegistry()
+	h := &countingTask{}
+	tasks.RegisterTask("later_task", h)
+	defer tasks.ClearRegistry()
+
+	eta := time.Now().Add(100 * time.Second).UTC().Format(time.RFC3339Nano)
+	body, _ := json.Marshal(tasks.TaskPayload{ID: "1", Task: "later_task", Attempt: 0, MaxAttempts: 5, ETA: eta, Payload: json.RawMessage(`{}`)})
+
+	t.Run("ttl", func(t *testing.T) {
+		c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+		ch := &fakeChannel{}
+		c.ch = ch
+
+		ack := &fakeAcknowledger{}
+		c.process(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+		assert.Equal(t, 1, ack.acked)
+		assert.Equal(t, 0, h.n)
+		require.Len(t, ch.published, 1)
+		// ~100s rounded down to a power-of-two wait queue
+		assert.Equal(t, "go.logger.retry.64000ms", ch.published[0].key)
+
+		var envelope tasks.TaskPayload
+		require.NoError(t, json.Unmarshal(ch.published[0].msg.Body, &envelope))
+		assert.Equal(t, 0, envelope.Attempt, "waiting does not consume an attempt")
+		assert.Equal(t, eta, envelope.ETA)
+	})
+
+	t.Run("plugin", func(t *testing.T) {
+		c := newQueueConsumer(testQueueConfig(), config.RetryDelayPlugin, tasks.NewDispatcher(nil, nil), 1)
+		ch := &fakeChannel{}
+		c.ch = ch
+
+		c.process(context.Background(), amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: body})
+		require.Len(t, ch.published, 1)
+		delay := ch.published[0].msg.Headers["x-delay"].(int64)
+		assert.InDelta(t, 100000, delay, 2000)
+	})
+
+	t.Run("due", func(t *testing.T) {
+		past := time.Now().Add(-time.Second).UTC().Format(time.RFC3339Nano)
+		body, _ := json.Marshal(tasks.TaskPayload{ID: "2", Task: "later_task", MaxAttempts: 5, ETA: past, Payload: json.RawMessage(`{}`)})
+		c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+		c.ch = &fakeChannel{}
+
+		c.process(context.Background(), amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: body})
+		assert.Equal(t, 1, h.n)
+	})
+}
+
+type countingTask struct{ n int }
+
+func (c *countingTask) Handle(ctx context.Context, payload json.RawMessage) error {
+	c.n++
+	return nil
+}
+
+func TestProcessPublishesChainStep(t *testing.T) {
+	tasks.ClearRegistry()
+	tasks.RegisterTask("test_task", &countingTask{})
+	defer tasks.ClearRegistry()
+
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	ch := &fakeChannel{}
+	c.ch = ch
+	pub := &fakeChannel{}
+	c.pub, _ = newConfirmChannel(pub)
+
+	body, _ := json.Marshal(tasks.TaskPayload{
+		ID:      "1",
+		Task:    "test_task",
+		Payload: json.RawMessage(`{}`),
+		Chain:   []tasks.Signature{{Task: "second"}, {Task: "third", Queue: "go.other"}},
+	})
+	ack := &fakeAcknowledger{}
+	c.process(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+	// Acked once the broker confirmed the step
+	assert.Equal(t, 1, ack.acked)
+	assert.Empty(t, ch.published)
+	require.Len(t, pub.published, 1)
+	// Steps without a queue stay on the consumer's queue
+	assert.Equal(t, "", pub.published[0].exchange)
+	assert.Equal(t, "go.logger", pub.published[0].key)
+
+	var envelope tasks.TaskPayload
+	require.NoError(t, json.Unmarshal(pub.published[0].msg.Body, &envelope))
+	assert.Equal(t, "second", envelope.Task)
+	assert.Equal(t, envelope.ID, pub.published[0].msg.MessageId)
+	require.Len(t, envelope.Chain, 1)
+	assert.Equal(t, "go.other", envelope.Chain[0].Queue)
+}
+
+func TestProcessRequeuesWhenChainStepIsNotConfirmed(t *testing.T) {
+	tasks.ClearRegistry()
+	tasks.RegisterTask("test_task", &countingTask{})
+	defer tasks.ClearRegistry()
+
+	c := newQueueConsumer(testQueueConfig(), config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	c.ch = &fakeChannel{}
+	c.pub, _ = newConfirmChannel(&fakeChannel{nack: true})
+
+	body, _ := json.Marshal(tasks.TaskPayload{ID: "1", Task: "test_task", Payload: json.RawMessage(`{}`), Chain: []tasks.Signature{{Task: "second"}}})
+	ack := &fakeAcknowledger{}
+	c.process(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+	assert.Equal(t, 0, ack.acked)
+	assert.Equal(t, 1, ack.nacked)
+	assert.True(t, ack.requeue)
+
+	// Same without a channel to publish on
+	c.pub = nil
+	ack = &fakeAcknowledger{}
+	c.process(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body})
+	assert.Equal(t, 1, ack.nacked)
+	assert.True(t, ack.requeue)
+}
@@ -0,0 +1,42 @@
+package queue
+
+import (
+	"context"
+
+	"base-go-app/internal/tasks"
+	"base-go-app/internal/tracing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan starts the consumer span of d, continuing the trace context
+// found in its headers.
+func (c *queueConsumer) startSpan(ctx context.Context, d amqp.Delivery) (context.Context, trace.Span) {
+	ctx = tracing.Extract(ctx, d.Headers)
+	return tracing.Tracer().Start(ctx, c.cfg.Name+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", c.cfg.Name),
+			attribute.String("messaging.message.id", d.MessageId),
+		),
+	)
+}
+
+// endSpan names the task on span and ends it. Deferred tasks are not
+// errors.
+func (c *queueConsumer) endSpan(span trace.Span, res tasks.DispatchResult) {
+	span.SetAttributes(
+		attribute.String("task", res.Task),
+		attribute.String("task.id", res.TaskID),
+		attribute.Int("task.attempt", res.Attempt),
+		attribute.Bool("task.retry", res.Retry && !deferred(res)),
+	)
+	var err error
+	if !res.Success && !deferred(res) {
+		err = res.Error
+	}
+	tracing.End(span, err)
+}
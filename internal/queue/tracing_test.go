@@ -0,0 +1,40 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/tasks"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceHandler records the span context its task ran with.
+type traceHandler struct{ sc *trace.SpanContext }
+
+func (h traceHandler) Handle(ctx context.Context, payload json.RawMessage) error {
+	*h.sc = trace.SpanContextFromContext(ctx)
+	return nil
+}
+
+func TestProcessContinuesTrace(t *testing.T) {
+	var sc trace.SpanContext
+	tasks.ClearRegistry()
+	tasks.RegisterTask("traced_task", traceHandler{&sc})
+	defer tasks.ClearRegistry()
+
+	c := newQueueConsumer(config.QueueConfig{Name: "q", Concurrency: 1}, config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+	d, ack := newTestDelivery(t, "traced_task")
+	d.Headers = amqp.Table{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	c.process(context.Background(), d)
+
+	if ack.acked != 1 {
+		t.Fatalf("expected ack, got acked=%d nacked=%d", ack.acked, ack.nacked)
+	}
+	if got := sc.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("handler ran in trace %q", got)
+	}
+}
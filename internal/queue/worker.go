@@ -0,0 +1,493 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/logging"
+	"base-go-app/internal/metrics"
+	"base-go-app/internal/tasks"
+	"base-go-app/internal/tracing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// amqpChannel is the subset of *amqp.Channel used by workers once the
+// queue has been subscribed.
+type amqpChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	Cancel(consumer string, noWait bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Close() error
+}
+
+// queueConsumer owns the AMQP channel, task buffer and worker pool of a
+// single configured queue.
+type queueConsumer struct {
+	cfg        config.QueueConfig
+	retryMode  string
+	dispatcher *tasks.Dispatcher
+	taskCh     chan amqp.Delivery
+	// tag identifies the subscription so it can be canceled on shutdown
+	tag string
+	log *slog.Logger
+
+	// Channel used for consuming and for publishing retries, and the
+	// confirmed channel the tasks that follow a task are published on
+	chMu sync.RWMutex
+	ch   amqpChannel
+	pub  *confirmChannel
+
+	// Tasks being executed, and when the last one finished (Unix nanos),
+	// for the health checks
+	inFlight int64
+	lastDone int64
+	// Tasks finished and the time spent on them (nanos) since the
+	// autoscaler last looked
+	finished int64
+	busy     int64
+
+	// Worker pool, resizable at runtime (see resize). Surplus workers
+	// exit when they receive from quit.
+	poolMu      sync.Mutex
+	concurrency int
+	prefetch    int
+	quit        chan struct{}
+	stopped     bool
+	poolCtx     context.Context
+	taskCtx     context.Context
+	wg          *sync.WaitGroup
+
+	// Consumption paused through the admin API (see pause)
+	pauseMu  sync.Mutex
+	paused   bool
+	resumed  chan struct{}
+	detached bool
+
+	// Tasks being executed, by sequence number, for the admin API
+	runningMu sync.Mutex
+	running   map[uint64]InFlightTask
+	seq       uint64
+}
+
+func newQueueConsumer(cfg config.QueueConfig, retryMode string, dispatcher *tasks.Dispatcher, bufferSize int) *queueConsumer {
+	return &queueConsumer{
+		cfg:         cfg,
+		retryMode:   retryMode,
+		dispatcher:  dispatcher,
+		taskCh:      make(chan amqp.Delivery, bufferSize),
+		tag:         logging.WorkerID() + "-" + cfg.Name,
+		log:         slog.Default().With(logging.KeyQueue, cfg.Name),
+		concurrency: cfg.Concurrency,
+		prefetch:    cfg.Prefetch,
+		quit:        make(chan struct{}, MaxConcurrency),
+		running:     map[uint64]InFlightTask{},
+	}
+}
+
+// taskLog returns the consumer's logger with the task of res.
+func (c *queueConsumer) taskLog(res tasks.DispatchResult) *slog.Logger {
+	return c.log.With(logging.KeyTaskID, res.TaskID, logging.KeyTask, res.Task, logging.KeyAttempt, res.Attempt)
+}
+
+// startWorkers launches cfg.Concurrency goroutines draining taskCh. Workers
+// stop taking deliveries once ctx is canceled; tasks run with taskCtx, so
+// the ones in flight can finish.
+func (c *queueConsumer) startWorkers(ctx, taskCtx context.Context, wg *sync.WaitGroup) {
+	atomic.StoreInt64(&c.lastDone, time.Now().UnixNano())
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	c.poolCtx, c.taskCtx, c.wg = ctx, taskCtx, wg
+	for i := 0; i < c.concurrency; i++ {
+		c.spawn()
+	}
+}
+
+// spawn starts a worker. c.poolMu must be held.
+func (c *queueConsumer) spawn() {
+	ctx, taskCtx := c.poolCtx, c.taskCtx
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.quit:
+				return
+			case d, ok := <-c.taskCh:
+				if !ok {
+					return
+				}
+				if ctx.Err() != nil {
+					// Lost the race with shutdown
+					c.requeue(d)
+					return
+				}
+				atomic.AddInt64(&inFlight, 1)
+				atomic.AddInt64(&c.inFlight, 1)
+				id := c.track(d)
+				start := time.Now()
+				c.process(taskCtx, d)
+				c.untrack(id)
+				atomic.AddInt64(&c.inFlight, -1)
+				atomic.AddInt64(&inFlight, -1)
+				now := time.Now()
+				atomic.StoreInt64(&c.lastDone, now.UnixNano())
+				atomic.AddInt64(&c.finished, 1)
+				atomic.AddInt64(&c.busy, int64(now.Sub(start)))
+			}
+		}
+	}()
+}
+
+// subscribe opens a channel on conn, applies QoS, declares the exchange,
+// queue and binding, and starts consuming.
+func (c *queueConsumer) subscribe(conn *amqp.Connection) (<-chan amqp.Delivery, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a channel: %w", err)
+	}
+
+	// Declare Exchange
+	err = ch.ExchangeDeclare(
+		c.cfg.Exchange, // name
+		"direct",       // type
+		true,           // durable
+		false,          // auto-deleted
+		false,          // internal
+		false,          // no-wait
+		nil,            // arguments
+	)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare exchange: %w", err)
+	}
+
+	// Declare dead-letter topology
+	queueArgs, err := declareDeadLetter(ch, c.cfg)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	// Declare Queue
+	q, err := ch.QueueDeclare(
+		c.cfg.Name, // name
+		true,       // durable
+		false,      // delete when unused
+		false,      // exclusive
+		false,      // no-wait
+		queueArgs,  // arguments
+	)
+	if err != nil {
+		ch.Close()
+		return nil, declareQueueError(c.cfg.Name, err)
+	}
+
+	// Bind Queue
+	err = ch.QueueBind(
+		q.Name,
+		c.cfg.RoutingKey,
+		c.cfg.Exchange,
+		false,
+		nil,
+	)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to bind queue: %w", err)
+	}
+
+	if c.retryMode == config.RetryDelayPlugin {
+		if err := declareDelayedExchange(ch, c.cfg); err != nil {
+			ch.Close()
+			return nil, err
+		}
+	}
+
+	pubCh, err := conn.Channel()
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to open a channel: %w", err)
+	}
+	pub, err := newConfirmChannel(pubCh)
+	if err != nil {
+		pubCh.Close()
+		ch.Close()
+		return nil, err
+	}
+
+	c.chMu.Lock()
+	c.ch = ch
+	c.pub = pub
+	c.chMu.Unlock()
+
+	msgs, err := c.startConsuming()
+	if err != nil {
+		c.closeChannel()
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// startConsuming subscribes to the queue on the current channel. It
+// returns no deliveries while the queue is paused.
+func (c *queueConsumer) startConsuming() (<-chan amqp.Delivery, error) {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	c.detached = false
+	if c.paused {
+		return nil, nil
+	}
+	ch := c.channel()
+	if ch == nil {
+		return nil, errors.New("channel closed")
+	}
+	// basic.qos only applies to consumers started after it
+	if err := ch.Qos(c.poolPrefetch(), 0, false); err != nil {
+		c.log.Warn("Failed to set QoS", logging.Err(err))
+	}
+	msgs, err := ch.Consume(
+		c.cfg.Name, // queue
+		c.tag,      // consumer
+		false,      // auto-ack (FALSE now, manual ack in worker)
+		false,      // exclusive
+		false,      // no-local
+		false,      // no-wait
+		nil,        // args
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register a consumer: %w", err)
+	}
+	return msgs, nil
+}
+
+// closeChannel closes the current AMQP channels, if any.
+func (c *queueConsumer) closeChannel() {
+	c.chMu.Lock()
+	ch, pub := c.ch, c.pub
+	c.ch, c.pub = nil, nil
+	c.chMu.Unlock()
+	if ch != nil {
+		ch.Close()
+	}
+	if pub != nil {
+		pub.Close()
+	}
+}
+
+// channel returns the current AMQP channel, or nil while disconnected.
+func (c *queueConsumer) channel() amqpChannel {
+	c.chMu.RLock()
+	defer c.chMu.RUnlock()
+	return c.ch
+}
+
+// publisher returns the current confirmed channel, or nil while
+// disconnected.
+func (c *queueConsumer) publisher() *confirmChannel {
+	c.chMu.RLock()
+	defer c.chMu.RUnlock()
+	return c.pub
+}
+
+// forward pushes deliveries into the worker pool until msgs closes (returns
+// true) or ctx is canceled (returns false). Deliveries left in msgs in the
+// latter case are for the caller to requeue.
+func (c *queueConsumer) forward(ctx context.Context, msgs <-chan amqp.Delivery) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case d, ok := <-msgs:
+			if !ok {
+				return true
+			}
+			// Push to worker pool
+			select {
+			case c.taskCh <- d:
+			case <-ctx.Done():
+				c.requeue(d)
+				return false
+			}
+		}
+	}
+}
+
+// process dispatches a single delivery and acks, retries or rejects it.
+// The task runs in a consumer span continuing the publisher's trace.
+func (c *queueConsumer) process(ctx context.Context, d amqp.Delivery) {
+	ctx, span := c.startSpan(ctx, d)
+	log := c.log
+	if sc := span.SpanContext(); sc.IsValid() {
+		log = log.With(logging.KeyTraceID, sc.TraceID().String())
+	}
+	res := c.dispatcher.DispatchMessage(logging.NewContext(ctx, log), d.Body, d.Headers)
+	defer c.endSpan(span, res)
+	c.observe(res)
+	if res.Success {
+		if err := c.publishNext(ctx, res.Next); err != nil {
+			// Run the task again rather than lose the rest of the workflow;
+			// a redelivered group member publishes the callback again
+			c.taskLog(res).Error("Failed to publish workflow tasks, requeuing", logging.Err(err))
+			if err := d.Nack(false, true); err != nil {
+				c.taskLog(res).Warn("Failed to requeue delivery", logging.Err(err))
+			}
+			return
+		}
+		d.Ack(false)
+		return
+	}
+	if res.Interrupted {
+		// Canceled by shutdown; another worker runs it from the start
+		c.requeue(d)
+		return
+	}
+	if !res.Retry {
+		// Fatal error or retries exhausted
+		c.deadLetter(d, res)
+		return
+	}
+
+	// Attempt to republish with incremented attempt count after a backoff
+	if body, headers, err := tasks.PrepareRetry(d.Body, d.Headers, res.RetryAttempt); err == nil {
+		var delay time.Duration
+		switch {
+		case res.RetryDelay > 0:
+			delay = res.RetryDelay
+			if c.retryMode != config.RetryDelayPlugin {
+				delay = tasks.DelayStep(delay)
+			}
+		case tasks.BackoffEnabled():
+			delay = tasks.GetBackoffDuration(res.Attempt)
+		}
+		if deferred(res) && delay < minDeferDelay {
+			// Nothing ran, so nothing is likely to have changed yet
+			delay = minDeferDelay
+		}
+
+		if pubCh := c.channel(); pubCh != nil {
+			err := c.publishRetry(pubCh, retryPublishing(d, body, headers), delay)
+			if err == nil {
+				if !deferred(res) {
+					metrics.TasksRetried.WithLabelValues(taskLabel(res.Task), c.cfg.Name).Inc()
+				}
+				switch {
+				case errors.Is(res.Error, tasks.ErrNotDue):
+					c.taskLog(res).Info("Task not due, delayed", "due_in", res.RetryDelay.Round(time.Millisecond), "delay", delay)
+				case throttled(res):
+					c.taskLog(res).Info("Task throttled, delayed", "reason", res.Error.Error(), "delay", delay)
+				default:
+					c.taskLog(res).Info("Task retry scheduled", "retry_attempt", res.RetryAttempt, "delay", delay)
+				}
+				d.Ack(false)
+				return
+			}
+			c.taskLog(res).Error("Failed to republish retry", logging.Err(err))
+		}
+	}
+	// Fallback: dead-letter the message
+	c.deadLetter(d, res)
+}
+
+// minDeferDelay is the least a task put back without running waits, even
+// without backoff, so that it does not come straight back.
+const minDeferDelay = time.Second
+
+// publishNext publishes the workflow tasks that follow a task, through the
+// default exchange, in the trace of ctx, and waits for the broker to
+// confirm each. Tasks without a queue go to this consumer's queue.
+func (c *queueConsumer) publishNext(ctx context.Context, next []tasks.NextTask) error {
+	if len(next) == 0 {
+		return nil
+	}
+	pub := c.publisher()
+	if pub == nil {
+		return errors.New("channel closed")
+	}
+	for _, n := range next {
+		body, err := json.Marshal(n.Envelope)
+		if err != nil {
+			return err
+		}
+		queue := n.Queue
+		if queue == "" {
+			queue = c.cfg.Name
+		}
+		err = pub.publish("", queue, amqp.Publishing{
+			Headers:         tracing.Inject(ctx, nil),
+			ContentType:     "application/json",
+			ContentEncoding: "utf-8",
+			DeliveryMode:    amqp.Persistent,
+			MessageId:       n.Envelope.ID,
+			Body:            body,
+		})
+		if err != nil {
+			return err
+		}
+		c.log.Info("Published workflow task", logging.KeyTask, n.Envelope.Task, logging.KeyTaskID, n.Envelope.ID, "to", queue)
+	}
+	return nil
+}
+
+// deadLetter routes a failed delivery to the dead-letter queue, annotated
+// with the failure reason, attempt count and task name. If that's not
+// possible the delivery is rejected, which lets the broker dead-letter it
+// without annotations (or drop it when dead-lettering is disabled).
+func (c *queueConsumer) deadLetter(d amqp.Delivery, res tasks.DispatchResult) {
+	metrics.TasksDeadLettered.WithLabelValues(taskLabel(res.Task), c.cfg.Name).Inc()
+	if c.cfg.DeadLetterEnabled() {
+		if pubCh := c.channel(); pubCh != nil {
+			err := pubCh.Publish(
+				c.cfg.DeadLetterExchange,
+				c.cfg.Name,
+				false, // mandatory
+				false, // immediate
+				deadLetterPublishing(d, res),
+			)
+			if err == nil {
+				c.taskLog(res).Warn("Task dead-lettered", logging.Err(res.Error))
+				d.Ack(false)
+				return
+			}
+			c.taskLog(res).Error("Failed to publish dead letter", logging.Err(err))
+		}
+	}
+	d.Nack(false, false)
+}
+
+// retryPublishing builds the message republished for a retry, keeping the
+// given headers except the ones added by dead-lettering.
+func retryPublishing(d amqp.Delivery, body []byte, original map[string]interface{}) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range original {
+		switch k {
+		case "x-death", "x-first-death-exchange", "x-first-death-queue", "x-first-death-reason", "x-delay":
+			continue
+		}
+		headers[k] = v
+	}
+	contentType := d.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     contentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		CorrelationId:   d.CorrelationId,
+		MessageId:       d.MessageId,
+		Body:            body,
+	}
+}
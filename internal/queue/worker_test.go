@@ -0,0 +1,121 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"base-go-app/internal/config"
+	"base-go-app/internal/tasks"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeAcknowledger records ack/nack calls for a delivery.
+type fakeAcknowledger struct {
+	mu      sync.Mutex
+	acked   int
+	nacked  int
+	requeue bool
+}
+
+func (f *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.acked++
+	return nil
+}
+
+func (f *fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nacked++
+	f.requeue = requeue
+	return nil
+}
+
+func (f *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return f.Nack(tag, false, requeue)
+}
+
+type okHandler struct{}
+
+func (okHandler) Handle(ctx context.Context, payload json.RawMessage) error { return nil }
+
+func newTestDelivery(t *testing.T, task string) (amqp.Delivery, *fakeAcknowledger) {
+	t.Helper()
+	body, err := json.Marshal(tasks.TaskPayload{ID: "1", Task: task, Payload: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	ack := &fakeAcknowledger{}
+	return amqp.Delivery{Acknowledger: ack, Body: body}, ack
+}
+
+func TestQueueConsumerProcess(t *testing.T) {
+	tasks.ClearRegistry()
+	tasks.RegisterTask("ok_task", okHandler{})
+	defer tasks.ClearRegistry()
+
+	c := newQueueConsumer(config.QueueConfig{Name: "q", Concurrency: 1}, config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 1)
+
+	d, ack := newTestDelivery(t, "ok_task")
+	c.process(context.Background(), d)
+	if ack.acked != 1 || ack.nacked != 0 {
+		t.Fatalf("expected ack, got acked=%d nacked=%d", ack.acked, ack.nacked)
+	}
+
+	d, ack = newTestDelivery(t, "unknown_task")
+	c.process(context.Background(), d)
+	if ack.nacked != 1 || ack.requeue {
+		t.Fatalf("expected nack without requeue, got nacked=%d requeue=%v", ack.nacked, ack.requeue)
+	}
+}
+
+func TestQueueConsumerWorkersDrainTaskCh(t *testing.T) {
+	tasks.ClearRegistry()
+	tasks.RegisterTask("ok_task", okHandler{})
+	defer tasks.ClearRegistry()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := newQueueConsumer(config.QueueConfig{Name: "q", Concurrency: 2}, config.RetryDelayTTL, tasks.NewDispatcher(nil, nil), 4)
+	var wg sync.WaitGroup
+	c.startWorkers(ctx, ctx, &wg)
+
+	msgs := make(chan amqp.Delivery, 3)
+	var acks []*fakeAcknowledger
+	for i := 0; i < 3; i++ {
+		d, ack := newTestDelivery(t, "ok_task")
+		acks = append(acks, ack)
+		msgs <- d
+	}
+	close(msgs)
+
+	if !c.forward(ctx, msgs) {
+		t.Fatalf("expected forward to report closed msgs channel")
+	}
+
+	deadline := time.After(2 * time.Second)
+	for _, ack := range acks {
+		for {
+			ack.mu.Lock()
+			n := ack.acked
+			ack.mu.Unlock()
+			if n == 1 {
+				break
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("timed out waiting for ack")
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}
+
+	cancel()
+	wg.Wait()
+}
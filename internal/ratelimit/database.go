@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"base-go-app/internal/database"
+	"base-go-app/internal/logging"
+	"base-go-app/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DBLimiter keeps token buckets in the task_rate_limits table so a limit
+// holds across every worker pod. Each reservation locks the bucket's row
+// for the duration of a short transaction. The table is created on first
+// use. Refills are computed from each pod's clock, so clocks must be
+// roughly in sync.
+//
+// While the database is unavailable, buckets fall back to memory: limits
+// then apply per pod until it is back.
+type DBLimiter struct {
+	fallback *MemoryLimiter
+
+	degradedMu sync.Mutex
+	degraded   bool
+}
+
+// NewDBLimiter creates a database-backed Limiter.
+func NewDBLimiter() *DBLimiter {
+	return &DBLimiter{fallback: NewMemoryLimiter()}
+}
+
+func (l *DBLimiter) Reserve(ctx context.Context, key string, limit Limit, maxWait time.Duration) (time.Duration, bool, error) {
+	if limit.Rate <= 0 {
+		return 0, true, nil
+	}
+	wait, ok, err := l.reserve(ctx, key, limit, maxWait)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, false, err
+		}
+		l.setDegraded(ctx, err)
+		return l.fallback.Reserve(ctx, key, limit, maxWait)
+	}
+	l.setDegraded(ctx, nil)
+	return wait, ok, nil
+}
+
+// reserve takes a token from the bucket's row, creating it full.
+func (l *DBLimiter) reserve(ctx context.Context, key string, limit Limit, maxWait time.Duration) (wait time.Duration, ok bool, err error) {
+	db, err := database.Migrated(ctx, &models.RateLimitBucket{})
+	if err != nil {
+		return 0, false, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		row := models.RateLimitBucket{Key: key, Tokens: limit.burst(), UpdatedAt: time.Now()}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+			return err
+		}
+		var b models.RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).Take(&b).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		tokens := refill(b.Tokens, now.Sub(b.UpdatedAt), limit)
+		if wait = tokenWait(tokens, limit.Rate); wait > maxWait {
+			// Nothing taken, nothing to write
+			return nil
+		}
+		ok = true
+		return tx.Model(&models.RateLimitBucket{}).
+			Where("key = ?", key).
+			Updates(map[string]interface{}{"tokens": tokens - 1, "updated_at": now}).Error
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return wait, ok, nil
+}
+
+// setDegraded logs when buckets move to memory because of err, and back
+// once err is nil.
+func (l *DBLimiter) setDegraded(ctx context.Context, err error) {
+	l.degradedMu.Lock()
+	defer l.degradedMu.Unlock()
+	if l.degraded == (err != nil) {
+		return
+	}
+	l.degraded = err != nil
+	if err != nil {
+		logging.FromContext(ctx).Warn("Rate limits fall back to memory, they apply per pod", logging.Err(err))
+	} else {
+		logging.FromContext(ctx).Info("Rate limits shared through the database again")
+	}
+}
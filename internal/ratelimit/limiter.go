@@ -0,0 +1,31 @@
+// Package ratelimit limits how often task types run with token buckets.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit is a token bucket that refills at Rate tokens per second and holds
+// up to Burst tokens.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// burst returns the bucket size, at least one token.
+func (l Limit) burst() float64 {
+	if l.Burst < 1 {
+		return 1
+	}
+	return float64(l.Burst)
+}
+
+// Limiter hands out the tokens of named buckets.
+type Limiter interface {
+	// Reserve takes a token from the bucket key if one is available within
+	// maxWait, and returns how long the caller must wait before using it.
+	// Otherwise it takes nothing and returns ok false with the time until
+	// a token is available.
+	Reserve(ctx context.Context, key string, limit Limit, maxWait time.Duration) (wait time.Duration, ok bool, err error)
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"base-go-app/internal/database"
+	"base-go-app/internal/database/databasetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testLimiters runs fn against every Limiter implementation.
+func testLimiters(t *testing.T, fn func(t *testing.T, l Limiter)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemoryLimiter())
+	})
+	t.Run("database", func(t *testing.T) {
+		databasetest.Use(t)
+		fn(t, NewDBLimiter())
+	})
+}
+
+func TestLimiterBurst(t *testing.T) {
+	testLimiters(t, func(t *testing.T, l Limiter) {
+		ctx := context.Background()
+		limit := Limit{Rate: 1, Burst: 3}
+
+		for i := 0; i < 3; i++ {
+			wait, ok, err := l.Reserve(ctx, "task", limit, 0)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Zero(t, wait)
+		}
+
+		// The bucket is empty: the next token comes in about a second
+		wait, ok, err := l.Reserve(ctx, "task", limit, 0)
+		require.NoError(t, err)
+		assert.False(t, ok)
+		assert.InDelta(t, time.Second, wait, float64(50*time.Millisecond))
+
+		// Other keys have their own bucket
+		_, ok, _ = l.Reserve(ctx, "other", limit, 0)
+		assert.True(t, ok)
+	})
+}
+
+func TestLimiterReservesAhead(t *testing.T) {
+	testLimiters(t, func(t *testing.T, l Limiter) {
+		ctx := context.Background()
+		limit := Limit{Rate: 10}
+
+		_, ok, _ := l.Reserve(ctx, "task", limit, 0)
+		require.True(t, ok)
+		// Waiters within maxWait are spaced by 1/rate
+		first, ok, _ := l.Reserve(ctx, "task", limit, time.Second)
+		require.True(t, ok)
+		second, ok, _ := l.Reserve(ctx, "task", limit, time.Second)
+		require.True(t, ok)
+		assert.InDelta(t, 100*time.Millisecond, first, float64(20*time.Millisecond))
+		assert.InDelta(t, 200*time.Millisecond, second, float64(20*time.Millisecond))
+
+		// Refills with time
+		time.Sleep(350 * time.Millisecond)
+		wait, ok, _ := l.Reserve(ctx, "task", limit, 0)
+		assert.True(t, ok)
+		assert.Zero(t, wait)
+	})
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	testLimiters(t, func(t *testing.T, l Limiter) {
+		for i := 0; i < 100; i++ {
+			_, ok, err := l.Reserve(context.Background(), "task", Limit{}, 0)
+			require.NoError(t, err)
+			require.True(t, ok)
+		}
+	})
+}
+
+func TestDBLimiterSharedAcrossPods(t *testing.T) {
+	databasetest.Use(t)
+	ctx := context.Background()
+	a, b := NewDBLimiter(), NewDBLimiter()
+	limit := Limit{Rate: 1, Burst: 2}
+
+	_, ok, err := a.Reserve(ctx, "task", limit, 0)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, ok, _ = b.Reserve(ctx, "task", limit, 0)
+	require.True(t, ok)
+
+	// The burst is spent for both
+	_, ok, _ = a.Reserve(ctx, "task", limit, 0)
+	assert.False(t, ok)
+	_, ok, _ = b.Reserve(ctx, "task", limit, 0)
+	assert.False(t, ok)
+}
+
+func TestDBLimiterFallsBackToMemory(t *testing.T) {
+	database.ClearDBForTests()
+	ctx := context.Background()
+	l := NewDBLimiter()
+	limit := Limit{Rate: 1}
+
+	_, ok, err := l.Reserve(ctx, "task", limit, 0)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	wait, ok, err := l.Reserve(ctx, "task", limit, 0)
+	require.NoError(t, err)
+	assert.False(t, ok, "limits still apply without the database")
+	assert.Greater(t, wait, time.Duration(0))
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryLimiter is an in-process Limiter. Its limits apply to one worker
+// process only.
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewMemoryLimiter creates an in-memory limiter.
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]*bucket)}
+}
+
+func (m *MemoryLimiter) Reserve(ctx context.Context, key string, limit Limit, maxWait time.Duration) (time.Duration, bool, error) {
+	if limit.Rate <= 0 {
+		return 0, true, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.burst(), last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = refill(b.tokens, now.Sub(b.last), limit)
+	b.last = now
+
+	wait := tokenWait(b.tokens, limit.Rate)
+	if wait > maxWait {
+		return wait, false, nil
+	}
+	// Tokens go negative while reserved ahead, spacing out the waiters
+	b.tokens--
+	return wait, true, nil
+}
+
+// refill returns the tokens of a bucket after elapsed, capped at its burst.
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(limit.burst(), tokens+elapsed.Seconds()*limit.Rate)
+}
+
+// tokenWait returns how long until a bucket holding tokens has a whole one.
+func tokenWait(tokens, rate float64) time.Duration {
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / rate * float64(time.Second))
+}
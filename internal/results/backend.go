@@ -0,0 +1,112 @@
+package results
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"base-go-app/internal/logging"
+	"base-go-app/internal/models"
+)
+
+// Status is the lifecycle state of a task.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusStarted   Status = "started"
+	StatusRetrying  Status = "retrying"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// DefaultExpiry is how long finished results are kept when no TTL is given.
+const DefaultExpiry = 24 * time.Hour
+
+// ErrNotFound is returned by Get for unknown or expired task IDs.
+var ErrNotFound = errors.New("task result not found")
+
+// Update describes a state transition of a task.
+type Update struct {
+	TaskID  string
+	Task    string
+	Status  Status
+	Attempt int // zero-based attempt the update refers to
+	Error   string
+	Result  json.RawMessage
+}
+
+// Backend stores task states and results keyed by task ID.
+type Backend interface {
+	// Update records a state transition.
+	Update(ctx context.Context, u Update) error
+	// Get returns the record of a task, or ErrNotFound.
+	Get(ctx context.Context, taskID string) (*models.TaskResult, error)
+	// DeleteExpired removes finished records whose expiry has passed and
+	// returns how many were removed.
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// apply folds u into rec. finished results expire after ttl (never when
+// ttl <= 0).
+func apply(rec *models.TaskResult, u Update, ttl time.Duration, now time.Time) {
+	if rec.TaskID == "" {
+		rec.TaskID = u.TaskID
+		rec.CreatedAt = now
+	}
+	if u.Task != "" {
+		rec.Task = u.Task
+	}
+	rec.UpdatedAt = now
+
+	switch u.Status {
+	case StatusPending:
+		// Publishing may race with the worker picking the task up; never
+		// move a task back to pending
+		if rec.Status != "" {
+			return
+		}
+	case StatusStarted:
+		rec.Attempts = u.Attempt + 1
+		rec.StartedAt = &now
+	case StatusRetrying:
+		rec.Attempts = u.Attempt + 1
+		rec.Error = u.Error
+	case StatusSucceeded:
+		rec.Error = ""
+		rec.Result = u.Result
+		rec.FinishedAt = &now
+	case StatusFailed:
+		rec.Error = u.Error
+		rec.FinishedAt = &now
+	}
+	rec.Status = string(u.Status)
+
+	if rec.FinishedAt != nil && ttl > 0 {
+		expires := now.Add(ttl)
+		rec.ExpiresAt = &expires
+	}
+}
+
+// StartJanitor deletes expired results every interval until ctx is canceled.
+func StartJanitor(ctx context.Context, b Backend, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := b.DeleteExpired(ctx)
+				if err != nil {
+					slog.Warn("Failed to delete expired task results", logging.Err(err))
+				} else if n > 0 {
+					slog.Debug("Deleted expired task results", "count", n)
+				}
+			}
+		}
+	}()
+}
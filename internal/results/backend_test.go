@@ -0,0 +1,96 @@
+package results
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"base-go-app/internal/database"
+	"base-go-app/internal/database/databasetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBackends runs fn against every Backend implementation.
+func testBackends(t *testing.T, ttl time.Duration, fn func(t *testing.T, b Backend)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemoryBackend(ttl))
+	})
+	t.Run("database", func(t *testing.T) {
+		databasetest.Use(t)
+		fn(t, NewDBBackend(ttl))
+	})
+}
+
+func TestBackendLifecycle(t *testing.T) {
+	testBackends(t, time.Hour, func(t *testing.T, b Backend) {
+		ctx := context.Background()
+
+		require.NoError(t, b.Update(ctx, Update{TaskID: "t1", Task: "logger", Status: StatusPending}))
+		rec, err := b.Get(ctx, "t1")
+		require.NoError(t, err)
+		assert.Equal(t, "pending", rec.Status)
+		assert.Equal(t, "logger", rec.Task)
+
+		require.NoError(t, b.Update(ctx, Update{TaskID: "t1", Task: "logger", Status: StatusStarted}))
+		require.NoError(t, b.Update(ctx, Update{TaskID: "t1", Task: "logger", Status: StatusRetrying, Error: "boom"}))
+		require.NoError(t, b.Update(ctx, Update{TaskID: "t1", Task: "logger", Status: StatusStarted, Attempt: 1}))
+
+		rec, err = b.Get(ctx, "t1")
+		require.NoError(t, err)
+		assert.Equal(t, "started", rec.Status)
+		assert.Equal(t, 2, rec.Attempts)
+		assert.Equal(t, "boom", rec.Error)
+		assert.NotNil(t, rec.StartedAt)
+		assert.Nil(t, rec.FinishedAt)
+		assert.Nil(t, rec.ExpiresAt)
+
+		require.NoError(t, b.Update(ctx, Update{TaskID: "t1", Task: "logger", Status: StatusSucceeded, Attempt: 1, Result: json.RawMessage(`{"ok":true}`)}))
+		rec, err = b.Get(ctx, "t1")
+		require.NoError(t, err)
+		assert.Equal(t, "succeeded", rec.Status)
+		assert.Empty(t, rec.Error)
+		assert.JSONEq(t, `{"ok":true}`, string(rec.Result))
+		assert.NotNil(t, rec.FinishedAt)
+		require.NotNil(t, rec.ExpiresAt)
+		assert.True(t, rec.ExpiresAt.After(time.Now()))
+
+		// A late pending update doesn't regress the state
+		require.NoError(t, b.Update(ctx, Update{TaskID: "t1", Task: "logger", Status: StatusPending}))
+		rec, err = b.Get(ctx, "t1")
+		require.NoError(t, err)
+		assert.Equal(t, "succeeded", rec.Status)
+
+		_, err = b.Get(ctx, "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestBackendExpiry(t *testing.T) {
+	testBackends(t, time.Millisecond, func(t *testing.T, b Backend) {
+		ctx := context.Background()
+
+		require.NoError(t, b.Update(ctx, Update{TaskID: "done", Task: "x", Status: StatusFailed, Error: "boom"}))
+		require.NoError(t, b.Update(ctx, Update{TaskID: "running", Task: "x", Status: StatusStarted}))
+		time.Sleep(5 * time.Millisecond)
+
+		_, err := b.Get(ctx, "done")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		n, err := b.DeleteExpired(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+
+		_, err = b.Get(ctx, "running")
+		assert.NoError(t, err)
+	})
+}
+
+func TestDBBackendUnavailable(t *testing.T) {
+	database.ClearDBForTests()
+	b := NewDBBackend(time.Hour)
+	err := b.Update(context.Background(), Update{TaskID: "t", Status: StatusStarted})
+	assert.ErrorIs(t, err, database.ErrNotConnected)
+}
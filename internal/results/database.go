@@ -0,0 +1,77 @@
+package results
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"base-go-app/internal/database"
+	"base-go-app/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DBBackend stores results in the task_results table through the shared
+// database connection. The table is created on first use.
+type DBBackend struct {
+	ttl time.Duration
+}
+
+// NewDBBackend creates a database backend whose finished results expire
+// after ttl.
+func NewDBBackend(ttl time.Duration) *DBBackend {
+	return &DBBackend{ttl: ttl}
+}
+
+func (b *DBBackend) Update(ctx context.Context, u Update) error {
+	db, err := database.Migrated(ctx, &models.TaskResult{})
+	if err != nil {
+		return err
+	}
+
+	if u.Status == StatusPending {
+		// Only insert; the worker may already have recorded progress
+		var rec models.TaskResult
+		apply(&rec, u, b.ttl, time.Now())
+		return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rec).Error
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var rec models.TaskResult
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("task_id = ?", u.TaskID).Take(&rec).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		apply(&rec, u, b.ttl, time.Now())
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&rec).Error
+	})
+}
+
+func (b *DBBackend) Get(ctx context.Context, taskID string) (*models.TaskResult, error) {
+	db, err := database.Migrated(ctx, &models.TaskResult{})
+	if err != nil {
+		return nil, err
+	}
+
+	var rec models.TaskResult
+	err = db.Where("task_id = ?", taskID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Take(&rec).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (b *DBBackend) DeleteExpired(ctx context.Context) (int64, error) {
+	db, err := database.Migrated(ctx, &models.TaskResult{})
+	if err != nil {
+		return 0, err
+	}
+	res := db.Where("expires_at IS NOT NULL AND expires_at <= ?", time.Now()).Delete(&models.TaskResult{})
+	return res.RowsAffected, res.Error
+}
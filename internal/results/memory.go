@@ -0,0 +1,62 @@
+package results
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"base-go-app/internal/models"
+)
+
+// MemoryBackend is an in-process Backend, mainly for tests and local runs.
+type MemoryBackend struct {
+	ttl     time.Duration
+	mu      sync.RWMutex
+	records map[string]models.TaskResult
+}
+
+// NewMemoryBackend creates an in-memory backend whose finished results
+// expire after ttl.
+func NewMemoryBackend(ttl time.Duration) *MemoryBackend {
+	return &MemoryBackend{
+		ttl:     ttl,
+		records: make(map[string]models.TaskResult),
+	}
+}
+
+func (m *MemoryBackend) Update(ctx context.Context, u Update) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec := m.records[u.TaskID]
+	apply(&rec, u, m.ttl, time.Now())
+	m.records[u.TaskID] = rec
+	return nil
+}
+
+func (m *MemoryBackend) Get(ctx context.Context, taskID string) (*models.TaskResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rec, ok := m.records[taskID]
+	if !ok || expired(&rec, time.Now()) {
+		return nil, ErrNotFound
+	}
+	return &rec, nil
+}
+
+func (m *MemoryBackend) DeleteExpired(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var n int64
+	for id, rec := range m.records {
+		if expired(&rec, now) {
+			delete(m.records, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func expired(rec *models.TaskResult, now time.Time) bool {
+	return rec.ExpiresAt != nil && !now.Before(*rec.ExpiresAt)
+}
@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	ts, err := time.Parse(time.RFC3339, s)
+	require.NoError(t, err)
+	return ts
+}
+
+func TestParseCronNext(t *testing.T) {
+	cases := []struct {
+		expr  string
+		after string
+		want  string
+	}{
+		{"* * * * *", "2026-03-04T10:20:30Z", "2026-03-04T10:21:00Z"},
+		{"*/15 * * * *", "2026-03-04T10:20:00Z", "2026-03-04T10:30:00Z"},
+		{"0 3 * * *", "2026-03-04T10:20:00Z", "2026-03-05T03:00:00Z"},
+		{"30 9 * * mon-fri", "2026-03-06T10:00:00Z", "2026-03-09T09:30:00Z"},
+		{"0 0 1 jan,jul *", "2026-03-04T00:00:00Z", "2026-07-01T00:00:00Z"},
+		{"0 12 29 2 *", "2026-03-01T00:00:00Z", "2028-02-29T12:00:00Z"},
+		{"5/20 8-10 * * *", "2026-03-04T08:45:00Z", "2026-03-04T09:05:00Z"},
+		// Both day fields restricted: either matches
+		{"0 0 13 * fri", "2026-03-01T00:00:00Z", "2026-03-06T00:00:00Z"},
+		// Sunday as 7
+		{"0 0 * * 7", "2026-03-04T00:00:00Z", "2026-03-08T00:00:00Z"},
+		{"@monthly", "2026-03-04T00:00:00Z", "2026-04-01T00:00:00Z"},
+		{"@hourly", "2026-03-04T10:00:00Z", "2026-03-04T11:00:00Z"},
+		{"@every 10m", "2026-03-04T10:03:00Z", "2026-03-04T10:10:00Z"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.expr, func(t *testing.T) {
+			s, err := ParseCron(tc.expr, nil)
+			require.NoError(t, err)
+			assert.Equal(t, mustTime(t, tc.want), s.Next(mustTime(t, tc.after)).UTC())
+		})
+	}
+}
+
+func TestParseCronTimezone(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Amsterdam")
+	require.NoError(t, err)
+	s, err := ParseCron("0 9 * * *", loc)
+	require.NoError(t, err)
+	// 09:00 CET is 08:00 UTC
+	assert.Equal(t, mustTime(t, "2026-01-05T08:00:00Z"), s.Next(mustTime(t, "2026-01-05T00:00:00Z")).UTC())
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "5-1 * * * *", "*/0 * * * *", "* * * * xyz", "@every", "@every -1s"} {
+		_, err := ParseCron(expr, nil)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestParseCronNeverMatches(t *testing.T) {
+	s, err := ParseCron("0 0 30 2 *", nil)
+	require.NoError(t, err)
+	assert.True(t, s.Next(time.Now()).IsZero())
+}
+
+func TestEvery(t *testing.T) {
+	s := Every(30 * time.Second)
+	assert.Equal(t, mustTime(t, "2026-03-04T10:00:30Z"), s.Next(mustTime(t, "2026-03-04T10:00:05Z")))
+	assert.Equal(t, mustTime(t, "2026-03-04T10:01:00Z"), s.Next(mustTime(t, "2026-03-04T10:00:30Z")))
+}
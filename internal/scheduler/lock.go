@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"base-go-app/internal/database"
+	"base-go-app/internal/models"
+
+	"gorm.io/gorm/clause"
+)
+
+// Locker elects the scheduler replica that fires periodic tasks.
+type Locker interface {
+	// Acquire takes or renews the lock name for owner until ttl from now,
+	// and reports whether owner holds it.
+	Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	// Release gives up owner's lock so another replica can take over
+	// without waiting for the lease to expire.
+	Release(ctx context.Context, name, owner string) error
+}
+
+// DBLocker keeps scheduler leases in the scheduler_locks table. The table
+// is created on first use. Leases are compared against each replica's
+// clock, so clocks must be roughly in sync.
+type DBLocker struct{}
+
+// NewDBLocker creates a database-backed Locker.
+func NewDBLocker() *DBLocker {
+	return &DBLocker{}
+}
+
+func (l *DBLocker) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	db, err := database.Migrated(ctx, &models.SchedulerLock{})
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	row := models.SchedulerLock{Name: name, Owner: owner, LockedUntil: now.Add(ttl), UpdatedAt: now}
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return true, nil
+	}
+
+	// Renew our own lease or take over an expired one. The conditions make
+	// the update atomic, so only one replica can win.
+	res = db.Model(&models.SchedulerLock{}).
+		Where("name = ?", name).
+		Where("owner = ? OR locked_until <= ?", owner, now).
+		Updates(map[string]interface{}{
+			"owner":        owner,
+			"locked_until": now.Add(ttl),
+			"updated_at":   now,
+		})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (l *DBLocker) Release(ctx context.Context, name, owner string) error {
+	db, err := database.Migrated(ctx, &models.SchedulerLock{})
+	if err != nil {
+		return err
+	}
+	return db.Where("name = ? AND owner = ?", name, owner).Delete(&models.SchedulerLock{}).Error
+}
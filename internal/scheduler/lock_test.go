@@ -0,0 +1,63 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"base-go-app/internal/database"
+	"base-go-app/internal/database/databasetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBLocker(t *testing.T) {
+	databasetest.Use(t)
+	ctx := context.Background()
+	l := NewDBLocker()
+
+	ok, err := l.Acquire(ctx, LockName, "a", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = l.Acquire(ctx, LockName, "b", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok, "held by a")
+
+	// The holder renews its lease
+	ok, err = l.Acquire(ctx, LockName, "a", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Only the holder can release
+	require.NoError(t, l.Release(ctx, LockName, "b"))
+	ok, err = l.Acquire(ctx, LockName, "b", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, l.Release(ctx, LockName, "a"))
+	ok, err = l.Acquire(ctx, LockName, "b", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestDBLockerTakesOverExpiredLease(t *testing.T) {
+	databasetest.Use(t)
+	ctx := context.Background()
+	l := NewDBLocker()
+
+	ok, err := l.Acquire(ctx, LockName, "a", -time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = l.Acquire(ctx, LockName, "b", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestDBLockerUnavailable(t *testing.T) {
+	database.ClearDBForTests()
+	_, err := NewDBLocker().Acquire(context.Background(), LockName, "a", time.Minute)
+	assert.ErrorIs(t, err, database.ErrNotConnected)
+}
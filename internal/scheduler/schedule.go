@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"base-go-app/internal/publisher"
+)
+
+// Entry is a periodic task. Exactly one of Cron and Every must be set.
+// Go tasks are sent with Payload and Options; Celery tasks (Celery set,
+// for Python workers) with Args and Kwargs.
+type Entry struct {
+	Name string `json:"name"`
+	// Cron is a cron expression, see ParseCron
+	Cron string `json:"cron,omitempty"`
+	// Every is an interval such as "30s" or "1h", see Every
+	Every string `json:"every,omitempty"`
+	// Timezone is the IANA zone Cron is evaluated in (default UTC)
+	Timezone string `json:"timezone,omitempty"`
+
+	Task  string `json:"task"`
+	Queue string `json:"queue,omitempty"`
+
+	Payload map[string]interface{} `json:"payload,omitempty"`
+	Options *publisher.TaskOptions `json:"options,omitempty"`
+
+	Celery bool                   `json:"celery,omitempty"`
+	Args   []interface{}          `json:"args,omitempty"`
+	Kwargs map[string]interface{} `json:"kwargs,omitempty"`
+}
+
+// schedule validates e and returns its Schedule.
+func (e Entry) schedule() (Schedule, error) {
+	if e.Name == "" {
+		return nil, fmt.Errorf("schedule entry for task %q has no name", e.Task)
+	}
+	if e.Task == "" {
+		return nil, fmt.Errorf("schedule entry %s has no task", e.Name)
+	}
+	switch {
+	case e.Cron != "" && e.Every != "":
+		return nil, fmt.Errorf("schedule entry %s: set either cron or every, not both", e.Name)
+	case e.Every != "":
+		d, err := time.ParseDuration(e.Every)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("schedule entry %s: invalid every %q", e.Name, e.Every)
+		}
+		return Every(d), nil
+	case e.Cron != "":
+		loc := time.UTC
+		if e.Timezone != "" {
+			var err error
+			if loc, err = time.LoadLocation(e.Timezone); err != nil {
+				return nil, fmt.Errorf("schedule entry %s: %w", e.Name, err)
+			}
+		}
+		s, err := ParseCron(e.Cron, loc)
+		if err != nil {
+			return nil, fmt.Errorf("schedule entry %s: %w", e.Name, err)
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("schedule entry %s: set cron or every", e.Name)
+}
+
+// LoadFile reads a JSON array of Entry from path and validates it.
+func LoadFile(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule: %w", err)
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule: %w", err)
+	}
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if _, err := e.schedule(); err != nil {
+			return nil, err
+		}
+		if seen[e.Name] {
+			return nil, fmt.Errorf("duplicate schedule entry %s", e.Name)
+		}
+		seen[e.Name] = true
+	}
+	return entries, nil
+}
@@ -0,0 +1,175 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"base-go-app/internal/logging"
+	"base-go-app/internal/publisher"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// LockName is the lock the scheduler replicas compete for.
+	LockName = "scheduler"
+	// DefaultLockTTL is how long a leader keeps the lock without renewing
+	// it. A crashed leader is replaced within this time.
+	DefaultLockTTL = 30 * time.Second
+)
+
+// Sender is the part of publisher.Publisher the scheduler needs.
+type Sender interface {
+	SendGoTask(task string, payload map[string]interface{}, queue string, options *publisher.TaskOptions) (string, error)
+	SendCeleryTaskWithOptions(task string, args []interface{}, queue string, options *publisher.CeleryTaskOptions) (string, error)
+}
+
+type job struct {
+	entry    Entry
+	schedule Schedule
+	next     time.Time
+}
+
+// Scheduler publishes periodic tasks, like Celery beat. With a Locker,
+// only the replica holding the lock publishes; the others keep track of
+// the schedule so they can take over at the next tick. Ticks that fall
+// between a leader's crash and the takeover are skipped, not caught up.
+type Scheduler struct {
+	jobs    []*job
+	sender  Sender
+	locker  Locker
+	lockTTL time.Duration
+	owner   string
+	log     *slog.Logger
+
+	leader  bool
+	renewAt time.Time
+}
+
+// New creates a scheduler for entries. locker may be nil when a single
+// replica runs; lockTTL defaults to DefaultLockTTL.
+func New(entries []Entry, sender Sender, locker Locker, lockTTL time.Duration) (*Scheduler, error) {
+	if lockTTL <= 0 {
+		lockTTL = DefaultLockTTL
+	}
+	host, _ := os.Hostname()
+	s := &Scheduler{
+		sender:  sender,
+		locker:  locker,
+		lockTTL: lockTTL,
+		owner:   fmt.Sprintf("%s-%s", host, uuid.New().String()),
+		log:     slog.Default().With(logging.KeyComponent, "scheduler"),
+	}
+	for _, e := range entries {
+		sched, err := e.schedule()
+		if err != nil {
+			return nil, err
+		}
+		s.jobs = append(s.jobs, &job{entry: e, schedule: sched})
+	}
+	return s, nil
+}
+
+// Run fires due tasks every second until ctx is canceled, then releases
+// the lock.
+func (s *Scheduler) Run(ctx context.Context) {
+	now := time.Now()
+	for _, j := range s.jobs {
+		j.next = j.schedule.Next(now)
+		s.log.Info("Scheduled", "entry", j.entry.Name, logging.KeyTask, j.entry.Task, "next_run", j.next.Format(time.RFC3339))
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.release()
+			return
+		case now := <-ticker.C:
+			s.tick(ctx, now)
+		}
+	}
+}
+
+// tick publishes the jobs due at now if this replica is the leader, and
+// returns how many were published.
+func (s *Scheduler) tick(ctx context.Context, now time.Time) int {
+	leader := s.isLeader(ctx, now)
+	fired := 0
+	for _, j := range s.jobs {
+		if j.next.IsZero() || now.Before(j.next) {
+			continue
+		}
+		if leader {
+			if err := s.fire(j.entry); err != nil {
+				s.log.Error("Failed to publish", "entry", j.entry.Name, logging.KeyTask, j.entry.Task, logging.Err(err))
+			} else {
+				fired++
+			}
+		}
+		j.next = j.schedule.Next(now)
+	}
+	return fired
+}
+
+// isLeader takes or renews the lock when due and reports whether this
+// replica may publish.
+func (s *Scheduler) isLeader(ctx context.Context, now time.Time) bool {
+	if s.locker == nil {
+		return true
+	}
+	if now.Before(s.renewAt) {
+		return s.leader
+	}
+
+	lockCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	leader, err := s.locker.Acquire(lockCtx, LockName, s.owner, s.lockTTL)
+	if err != nil {
+		// Our lease may still be valid, but without renewing it we cannot
+		// tell whether another replica took over
+		s.log.Warn("Failed to acquire lock", logging.Err(err))
+		leader = false
+	}
+	if leader != s.leader {
+		if leader {
+			s.log.Info("Became the leader", "owner", s.owner)
+		} else {
+			s.log.Info("No longer the leader", "owner", s.owner)
+		}
+	}
+	s.leader = leader
+	s.renewAt = now.Add(s.lockTTL / 3)
+	return leader
+}
+
+func (s *Scheduler) release() {
+	if s.locker == nil || !s.leader {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.locker.Release(ctx, LockName, s.owner); err != nil {
+		s.log.Warn("Failed to release lock", logging.Err(err))
+	}
+	s.leader = false
+}
+
+func (s *Scheduler) fire(e Entry) error {
+	var taskID string
+	var err error
+	if e.Celery {
+		taskID, err = s.sender.SendCeleryTaskWithOptions(e.Task, e.Args, e.Queue, &publisher.CeleryTaskOptions{Kwargs: e.Kwargs})
+	} else {
+		taskID, err = s.sender.SendGoTask(e.Task, e.Payload, e.Queue, e.Options)
+	}
+	if err != nil {
+		return err
+	}
+	s.log.Info("Published", "entry", e.Name, logging.KeyTask, e.Task, logging.KeyTaskID, taskID)
+	return nil
+}
@@ -0,0 +1,188 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"base-go-app/internal/publisher"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sent struct {
+	task    string
+	queue   string
+	payload map[string]interface{}
+	args    []interface{}
+	celery  bool
+}
+
+type fakeSender struct {
+	sent []sent
+	err  error
+}
+
+func (f *fakeSender) SendGoTask(task string, payload map[string]interface{}, queue string, options *publisher.TaskOptions) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	f.sent = append(f.sent, sent{task: task, queue: queue, payload: payload})
+	return "id", nil
+}
+
+func (f *fakeSender) SendCeleryTaskWithOptions(task string, args []interface{}, queue string, options *publisher.CeleryTaskOptions) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	f.sent = append(f.sent, sent{task: task, queue: queue, args: args, payload: options.Kwargs, celery: true})
+	return "id", nil
+}
+
+type fakeLocker struct {
+	holder string
+	err    error
+	calls  int
+}
+
+func (l *fakeLocker) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	l.calls++
+	if l.err != nil {
+		return false, l.err
+	}
+	if l.holder == "" {
+		l.holder = owner
+	}
+	return l.holder == owner, nil
+}
+
+func (l *fakeLocker) Release(ctx context.Context, name, owner string) error {
+	if l.holder == owner {
+		l.holder = ""
+	}
+	return nil
+}
+
+// start initialises the jobs' next run times like Run does.
+func start(s *Scheduler, now time.Time) {
+	for _, j := range s.jobs {
+		j.next = j.schedule.Next(now)
+	}
+}
+
+func TestSchedulerTick(t *testing.T) {
+	sender := &fakeSender{}
+	s, err := New([]Entry{
+		{Name: "beat", Every: "10s", Task: "logger", Queue: "go.logger", Payload: map[string]interface{}{"message": "beat"}},
+		{Name: "report", Cron: "0 * * * *", Task: "app.tasks.report", Celery: true, Args: []interface{}{1}},
+	}, sender, nil, 0)
+	require.NoError(t, err)
+
+	now := mustTime(t, "2026-03-04T10:59:55Z")
+	start(s, now)
+
+	assert.Equal(t, 0, s.tick(context.Background(), now.Add(time.Second)))
+	assert.Equal(t, 2, s.tick(context.Background(), now.Add(5*time.Second)))
+	require.Len(t, sender.sent, 2)
+	assert.Equal(t, "logger", sender.sent[0].task)
+	assert.Equal(t, "go.logger", sender.sent[0].queue)
+	assert.Equal(t, "beat", sender.sent[0].payload["message"])
+	assert.True(t, sender.sent[1].celery)
+	assert.Equal(t, []interface{}{1}, sender.sent[1].args)
+
+	// Each tick fires once
+	assert.Equal(t, 0, s.tick(context.Background(), now.Add(6*time.Second)))
+	assert.Equal(t, 1, s.tick(context.Background(), now.Add(15*time.Second)))
+}
+
+func TestSchedulerOnlyLeaderFires(t *testing.T) {
+	locker := &fakeLocker{}
+	sa := &fakeSender{}
+	sb := &fakeSender{}
+	entries := []Entry{{Name: "beat", Every: "1s", Task: "logger"}}
+	a, err := New(entries, sa, locker, 30*time.Second)
+	require.NoError(t, err)
+	b, err := New(entries, sb, locker, 30*time.Second)
+	require.NoError(t, err)
+
+	now := mustTime(t, "2026-03-04T10:00:00Z")
+	start(a, now)
+	start(b, now)
+	for i := 1; i <= 3; i++ {
+		a.tick(context.Background(), now.Add(time.Duration(i)*time.Second))
+		b.tick(context.Background(), now.Add(time.Duration(i)*time.Second))
+	}
+	assert.Len(t, sa.sent, 3)
+	assert.Empty(t, sb.sent)
+	// The lease is renewed every lockTTL/3, not every tick
+	assert.Equal(t, 2, locker.calls)
+
+	// a stops: b takes over at its next renewal without replaying the
+	// ticks it skipped as a follower
+	a.release()
+	later := now.Add(11 * time.Second)
+	assert.Equal(t, 1, b.tick(context.Background(), later))
+	assert.Len(t, sb.sent, 1)
+}
+
+func TestSchedulerLockErrorStopsPublishing(t *testing.T) {
+	locker := &fakeLocker{err: errors.New("database not connected")}
+	sender := &fakeSender{}
+	s, err := New([]Entry{{Name: "beat", Every: "1s", Task: "logger"}}, sender, locker, 0)
+	require.NoError(t, err)
+
+	now := mustTime(t, "2026-03-04T10:00:00Z")
+	start(s, now)
+	assert.Equal(t, 0, s.tick(context.Background(), now.Add(time.Second)))
+	assert.Empty(t, sender.sent)
+}
+
+func TestSchedulerPublishErrorKeepsSchedule(t *testing.T) {
+	sender := &fakeSender{err: errors.New("not connected")}
+	s, err := New([]Entry{{Name: "beat", Every: "1s", Task: "logger"}}, sender, nil, 0)
+	require.NoError(t, err)
+
+	now := mustTime(t, "2026-03-04T10:00:00Z")
+	start(s, now)
+	assert.Equal(t, 0, s.tick(context.Background(), now.Add(time.Second)))
+	assert.Equal(t, now.Add(2*time.Second), s.jobs[0].next)
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(body string) string {
+		path := filepath.Join(dir, "schedule.json")
+		require.NoError(t, os.WriteFile(path, []byte(body), 0o644))
+		return path
+	}
+
+	entries, err := LoadFile(write(`[
+		{"name": "cleanup", "cron": "0 3 * * *", "timezone": "Europe/Amsterdam", "task": "cleanup", "queue": "go.maintenance", "options": {"max_attempts": 1}},
+		{"name": "beat", "every": "30s", "task": "logger", "payload": {"message": "beat"}}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, 1, *entries[0].Options.MaxAttempts)
+	assert.Equal(t, "beat", entries[1].Payload["message"])
+
+	for _, body := range []string{
+		`{}`,
+		`[{"name": "x", "task": "t"}]`,
+		`[{"name": "x", "task": "t", "cron": "* * * * *", "every": "1s"}]`,
+		`[{"name": "x", "cron": "* * * * *"}]`,
+		`[{"task": "t", "cron": "* * * * *"}]`,
+		`[{"name": "x", "task": "t", "every": "soon"}]`,
+		`[{"name": "x", "task": "t", "cron": "* * * * *", "timezone": "Mars/Base"}]`,
+		`[{"name": "x", "task": "t", "every": "1s"}, {"name": "x", "task": "u", "every": "1s"}]`,
+	} {
+		_, err := LoadFile(write(body))
+		assert.Error(t, err, body)
+	}
+
+	_, err = LoadFile(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,151 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CeleryProtocolVersion is the envelope version reported for tasks adapted
+// from Celery protocol v2 messages.
+const CeleryProtocolVersion = "celery/2"
+
+// ErrTaskExpired is returned for Celery tasks whose "expires" time has passed.
+var ErrTaskExpired = errors.New("task expired")
+
+// IsCeleryMessage reports whether AMQP headers describe a Celery protocol v2
+// message, which carries the task name and ID in headers rather than in the
+// body.
+func IsCeleryMessage(headers map[string]interface{}) bool {
+	task, _ := headers["task"].(string)
+	id, _ := headers["id"].(string)
+	return task != "" && id != ""
+}
+
+// parseCeleryMessage adapts a Celery v2 message (body [args, kwargs, embed])
+// to a TaskPayload. The handler payload is derived from the arguments:
+//
+//   - a single positional argument and no kwargs: that argument
+//   - no positional arguments: the kwargs object
+//   - otherwise: {"args": [...], "kwargs": {...}}
+func parseCeleryMessage(headers map[string]interface{}, body []byte) (TaskPayload, error) {
+	var parts []json.RawMessage
+	if err := json.Unmarshal(body, &parts); err != nil {
+		return TaskPayload{}, fmt.Errorf("invalid celery message body: %w", err)
+	}
+	if len(parts) < 2 {
+		return TaskPayload{}, fmt.Errorf("invalid celery message body: expected [args, kwargs, embed], got %d element(s)", len(parts))
+	}
+
+	var args []json.RawMessage
+	if err := json.Unmarshal(parts[0], &args); err != nil {
+		return TaskPayload{}, fmt.Errorf("invalid celery args: %w", err)
+	}
+	var kwargs map[string]json.RawMessage
+	if err := json.Unmarshal(parts[1], &kwargs); err != nil {
+		return TaskPayload{}, fmt.Errorf("invalid celery kwargs: %w", err)
+	}
+
+	var payload json.RawMessage
+	switch {
+	case len(args) == 1 && len(kwargs) == 0:
+		payload = args[0]
+	case len(args) == 0:
+		payload = parts[1]
+		if len(kwargs) == 0 {
+			payload = json.RawMessage(`{}`)
+		}
+	default:
+		if kwargs == nil {
+			kwargs = map[string]json.RawMessage{}
+		}
+		b, err := json.Marshal(map[string]interface{}{"args": args, "kwargs": kwargs})
+		if err != nil {
+			return TaskPayload{}, err
+		}
+		payload = b
+	}
+
+	envelope := TaskPayload{
+		Version: CeleryProtocolVersion,
+		ID:      headers["id"].(string),
+		Task:    headers["task"].(string),
+		Payload: payload,
+		Attempt: headerInt(headers["retries"]),
+	}
+
+	// timelimit is [soft, hard]; the hard limit wins
+	if limits, ok := headers["timelimit"].([]interface{}); ok {
+		for _, l := range limits {
+			if v := headerInt(l); v > 0 {
+				envelope.TimeoutSeconds = v
+			}
+		}
+	}
+
+	if eta, ok := headers["eta"].(string); ok {
+		envelope.ETA = eta
+	}
+	if expires, ok := headers["expires"].(string); ok && expires != "" {
+		t, err := time.Parse(time.RFC3339Nano, expires)
+		if err == nil && time.Now().After(t) {
+			return envelope, fmt.Errorf("%w at %s", ErrTaskExpired, expires)
+		}
+	}
+
+	return envelope, nil
+}
+
+// PrepareRetry returns the body and headers of a message to be republished
+// as the given attempt. Celery messages keep their body and have the
+// "retries" header updated; Go envelopes have their attempt rewritten.
+func PrepareRetry(body []byte, headers map[string]interface{}, attempt int) ([]byte, map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(headers)+1)
+	for k, v := range headers {
+		out[k] = v
+	}
+
+	if IsCeleryMessage(headers) {
+		out["retries"] = int32(attempt)
+		return body, out, nil
+	}
+
+	var envelope TaskPayload
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, nil, err
+	}
+	envelope.Attempt = attempt
+	newBody, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newBody, out, nil
+}
+
+// headerInt converts a numeric AMQP header or JSON value to an int.
+func headerInt(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int8:
+		return int(n)
+	case int16:
+		return int(n)
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case uint8:
+		return int(n)
+	case uint16:
+		return int(n)
+	case uint32:
+		return int(n)
+	case float32:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
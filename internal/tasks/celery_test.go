@@ -0,0 +1,121 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func celeryHeaders(task, id string) map[string]interface{} {
+	return map[string]interface{}{"lang": "py", "task": task, "id": id, "root_id": id}
+}
+
+func TestIsCeleryMessage(t *testing.T) {
+	assert.True(t, IsCeleryMessage(celeryHeaders("logger", "1")))
+	assert.False(t, IsCeleryMessage(nil))
+	assert.False(t, IsCeleryMessage(map[string]interface{}{"task": "logger"}))
+}
+
+func TestParseCeleryMessagePayload(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"single arg", `[[{"message":"hi"}], {}, {"callbacks": null}]`, `{"message":"hi"}`},
+		{"kwargs only", `[[], {"to":"a@b.c"}, {}]`, `{"to":"a@b.c"}`},
+		{"no arguments", `[[], {}, {}]`, `{}`},
+		{"mixed", `[["a", 1], {"k":true}, {}]`, `{"args":["a",1],"kwargs":{"k":true}}`},
+		{"several args", `[["a", "b"], {}, {}]`, `{"args":["a","b"],"kwargs":{}}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env, err := parseCeleryMessage(celeryHeaders("t", "1"), []byte(tc.body))
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.want, string(env.Payload))
+			assert.Equal(t, CeleryProtocolVersion, env.Version)
+			assert.Equal(t, "t", env.Task)
+			assert.Equal(t, "1", env.ID)
+		})
+	}
+}
+
+func TestParseCeleryMessageHeaders(t *testing.T) {
+	headers := celeryHeaders("t", "1")
+	headers["retries"] = int32(2)
+	headers["timelimit"] = []interface{}{int32(30), int64(60)}
+	headers["expires"] = time.Now().Add(time.Hour).Format(time.RFC3339)
+	headers["eta"] = "2030-01-02T03:04:05.000000+00:00"
+
+	env, err := parseCeleryMessage(headers, []byte(`[[], {}, {}]`))
+	require.NoError(t, err)
+	assert.Equal(t, 2, env.Attempt)
+	assert.Equal(t, 60, env.TimeoutSeconds)
+	assert.Equal(t, "2030-01-02T03:04:05.000000+00:00", env.ETA)
+
+	headers["timelimit"] = []interface{}{int32(30), nil}
+	env, err = parseCeleryMessage(headers, []byte(`[[], {}, {}]`))
+	require.NoError(t, err)
+	assert.Equal(t, 30, env.TimeoutSeconds)
+
+	headers["expires"] = time.Now().Add(-time.Minute).Format(time.RFC3339)
+	_, err = parseCeleryMessage(headers, []byte(`[[], {}, {}]`))
+	assert.True(t, errors.Is(err, ErrTaskExpired))
+}
+
+func TestParseCeleryMessageInvalid(t *testing.T) {
+	for _, body := range []string{`{}`, `[[]]`, `["x", {}, {}]`, `[[], [], {}]`} {
+		_, err := parseCeleryMessage(celeryHeaders("t", "1"), []byte(body))
+		assert.Error(t, err, body)
+	}
+}
+
+type payloadRecorder struct {
+	payload json.RawMessage
+}
+
+func (p *payloadRecorder) Handle(ctx context.Context, payload json.RawMessage) error {
+	p.payload = payload
+	return nil
+}
+
+func TestDispatchCeleryMessage(t *testing.T) {
+	ClearRegistry()
+	h := &payloadRecorder{}
+	RegisterTask("logger", h)
+
+	d := NewDispatcher(nil, nil)
+	res := d.DispatchMessage(context.Background(), []byte(`[[{"message":"from python"}], {}, {"callbacks": null, "errbacks": null, "chain": null, "chord": null}]`), celeryHeaders("logger", "c-1"))
+	require.True(t, res.Success, "error: %v", res.Error)
+	assert.Equal(t, "c-1", res.TaskID)
+	assert.JSONEq(t, `{"message":"from python"}`, string(h.payload))
+
+	// Go envelopes are still accepted alongside
+	body, _ := json.Marshal(TaskPayload{Task: "logger", ID: "g-1", Payload: json.RawMessage(`{"message":"from go"}`)})
+	res = d.DispatchMessage(context.Background(), body, map[string]interface{}{"unrelated": "header"})
+	require.True(t, res.Success, "error: %v", res.Error)
+	assert.JSONEq(t, `{"message":"from go"}`, string(h.payload))
+}
+
+func TestPrepareRetry(t *testing.T) {
+	celeryBody := []byte(`[[1], {}, {}]`)
+	body, headers, err := PrepareRetry(celeryBody, celeryHeaders("t", "1"), 3)
+	require.NoError(t, err)
+	assert.Equal(t, celeryBody, body)
+	assert.Equal(t, int32(3), headers["retries"])
+
+	goBody, _ := json.Marshal(TaskPayload{Task: "t", ID: "1", Attempt: 0})
+	body, _, err = PrepareRetry(goBody, nil, 2)
+	require.NoError(t, err)
+	var env TaskPayload
+	require.NoError(t, json.Unmarshal(body, &env))
+	assert.Equal(t, 2, env.Attempt)
+
+	_, _, err = PrepareRetry([]byte(`not json`), nil, 1)
+	assert.Error(t, err)
+}
@@ -3,14 +3,24 @@ package tasks
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"base-go-app/internal/broadcast"
+	"base-go-app/internal/idempotency"
+	"base-go-app/internal/logging"
+	"base-go-app/internal/metrics"
+	"base-go-app/internal/ratelimit"
+	"base-go-app/internal/results"
+	"base-go-app/internal/tracing"
 	"base-go-app/internal/webhook"
+	"base-go-app/internal/workflow"
+
+	"github.com/google/uuid"
 )
 
 const DefaultMaxAttempts = 5
@@ -19,8 +29,34 @@ const DefaultMaxAttempts = 5
 type Dispatcher struct {
 	Broadcaster   broadcast.Broadcaster
 	WebhookClient webhook.Client
+	// Results optionally records task states and results.
+	Results results.Backend
+	// Idempotency optionally deduplicates tasks carrying an IdempotencyKey.
+	Idempotency idempotency.Store
+	// Workflows tracks groups and chords; required for group members.
+	Workflows workflow.Store
+	// RateLimiter enforces the rate limits of task types (see
+	// WithRateLimit). NewDispatcher sets an in-memory limiter.
+	RateLimiter ratelimit.Limiter
+
+	// Execution slots of the task types with a MaxConcurrency
+	slotsMu sync.Mutex
+	slots   map[string]chan struct{}
 }
 
+// ErrDuplicateInProgress is returned when another worker is executing a
+// task with the same idempotency key; the task is retried later without
+// consuming an attempt.
+var ErrDuplicateInProgress = errors.New("task with the same idempotency key is in progress")
+
+// ErrNotDue is returned for tasks received before their ETA; they are
+// re-delayed without consuming an attempt.
+var ErrNotDue = errors.New("task is not due yet")
+
+// etaTolerance lets a task run slightly before its ETA: broker delays are
+// not exact, and re-delaying would make it at least a second late.
+const etaTolerance = 100 * time.Millisecond
+
 // NewDispatcher creates a new dispatcher with dependencies.
 func NewDispatcher(b broadcast.Broadcaster, w webhook.Client) *Dispatcher {
 	if b == nil {
@@ -32,6 +68,7 @@ func NewDispatcher(b broadcast.Broadcaster, w webhook.Client) *Dispatcher {
 	return &Dispatcher{
 		Broadcaster:   b,
 		WebhookClient: w,
+		RateLimiter:   ratelimit.NewMemoryLimiter(),
 	}
 }
 
@@ -40,28 +77,140 @@ type DispatchResult struct {
 	Success      bool
 	Retry        bool
 	RetryAttempt int
-	Error        error
+	// RetryDelay, when set, replaces the backoff delay before the retry.
+	RetryDelay time.Duration
+	Error      error
+	// Result is the JSON-encoded result of a ResultTaskHandler, if any.
+	Result json.RawMessage
+	// Duplicate is set when the task was skipped because a task with the
+	// same idempotency key already succeeded.
+	Duplicate bool
+	// Next lists the workflow tasks to publish before acking the message.
+	Next []NextTask
+	// Interrupted is set when the task failed because ctx was canceled,
+	// i.e. the worker is shutting down. The message should be requeued.
+	Interrupted bool
+
+	// Duration is how long the handler ran (zero if it did not run).
+	Duration time.Duration
+
+	// Identity of the task, when the envelope could be parsed
+	TaskID  string
+	Task    string
+	Attempt int
 }
 
-// Dispatch processes a raw message body.
+// Dispatch processes a raw message body in the Go envelope format.
 func (d *Dispatcher) Dispatch(ctx context.Context, body []byte) DispatchResult {
+	return d.DispatchMessage(ctx, body, nil)
+}
+
+// DispatchMessage processes a message given its body and AMQP headers.
+// Celery protocol v2 messages (detected from the headers) are adapted to
+// a TaskPayload; anything else must be a Go envelope.
+func (d *Dispatcher) DispatchMessage(ctx context.Context, body []byte, headers map[string]interface{}) DispatchResult {
 	var envelope TaskPayload
-	if err := json.Unmarshal(body, &envelope); err != nil {
+	logger := logging.FromContext(ctx)
+	celery := IsCeleryMessage(headers)
+	if celery {
+		var err error
+		envelope, err = parseCeleryMessage(headers, body)
+		if err != nil {
+			// Malformed or expired; retrying won't help
+			logger.Error("Error parsing celery task", logging.KeyTask, headers["task"], logging.KeyTaskID, headers["id"], logging.Err(err))
+			return DispatchResult{Success: false, Error: err, TaskID: envelope.ID, Task: envelope.Task, Attempt: envelope.Attempt}
+		}
+	} else if err := json.Unmarshal(body, &envelope); err != nil {
 		// If we can't parse it, we can't retry it safely (poison message).
-		// However, for migration, we might want to check if it's a legacy Celery message.
-		// For now, we assume new format or fail.
-		log.Printf("Error unmarshaling task envelope: %v", err)
+		logger.Error("Error unmarshaling task envelope", logging.Err(err))
 		return DispatchResult{Success: false, Error: err}
 	}
 
+	// Everything logged from here on, handlers included, names the task
+	logger = logger.With(logging.KeyTaskID, envelope.ID, logging.KeyTask, envelope.Task, logging.KeyAttempt, envelope.Attempt)
+	ctx = logging.NewContext(ctx, logger)
+
+	if !celery {
+		if err := CheckEnvelopeVersion(envelope.Version); err != nil {
+			// Published by a newer (or older) publisher; another worker
+			// build may be able to run it, so leave it for the dead-letter queue
+			logger.Error("Task rejected", logging.Err(err))
+			d.record(ctx, &envelope, results.StatusFailed, nil, err)
+			d.completeMember(ctx, &envelope, nil, err)
+			return DispatchResult{Success: false, Error: err, TaskID: envelope.ID, Task: envelope.Task, Attempt: envelope.Attempt}
+		}
+	}
+
+	// Hold back tasks that arrived before their ETA
+	if envelope.ETA != "" {
+		if eta, err := time.Parse(time.RFC3339Nano, envelope.ETA); err != nil {
+			logger.Warn("Task has an invalid eta, running it now", "eta", envelope.ETA)
+		} else if wait := time.Until(eta); wait > etaTolerance {
+			return DispatchResult{
+				Success:      false,
+				Retry:        true,
+				RetryAttempt: envelope.Attempt,
+				RetryDelay:   wait,
+				Error:        ErrNotDue,
+				TaskID:       envelope.ID,
+				Task:         envelope.Task,
+				Attempt:      envelope.Attempt,
+			}
+		}
+	}
+
 	// Validate task
 	handler, ok := LookupTask(envelope.Task)
 	if !ok {
 		err := fmt.Errorf("unknown task: %s", envelope.Task)
-		log.Printf("%v", err)
-		return DispatchResult{Success: false, Error: err}
+		logger.Error("Unknown task")
+		d.record(ctx, &envelope, results.StatusFailed, nil, err)
+		d.completeMember(ctx, &envelope, nil, err)
+		return DispatchResult{Success: false, Error: err, TaskID: envelope.ID, Task: envelope.Task, Attempt: envelope.Attempt}
+	}
+
+	// Claim the idempotency key, if any, before taking a slot or a token:
+	// duplicates must not use up the limits of the type
+	claimOwner := ""
+	if envelope.IdempotencyKey != "" && d.Idempotency != nil {
+		owner := uuid.NewString()
+		state, err := d.Idempotency.Acquire(ctx, envelope.IdempotencyKey, owner, idempotencyLease(&envelope))
+		switch {
+		case err != nil:
+			// Fail open: the dedup store is best effort, like the database
+			logger.Warn("Idempotency check failed, executing anyway", logging.Err(err))
+		case state == idempotency.Completed:
+			logger.Info("Task skipped: idempotency key already completed", "idempotency_key", envelope.IdempotencyKey)
+			return DispatchResult{Success: true, Duplicate: true, TaskID: envelope.ID, Task: envelope.Task, Attempt: envelope.Attempt}
+		case state == idempotency.InProgress:
+			logger.Info("Task deferred: idempotency key is in progress elsewhere", "idempotency_key", envelope.IdempotencyKey)
+			return DispatchResult{
+				Success:      false,
+				Retry:        true,
+				RetryAttempt: envelope.Attempt,
+				Error:        ErrDuplicateInProgress,
+				TaskID:       envelope.ID,
+				Task:         envelope.Task,
+				Attempt:      envelope.Attempt,
+			}
+		default:
+			claimOwner = owner
+		}
 	}
 
+	// Hold back tasks over the limits of their type
+	release, held := d.throttle(ctx, &envelope)
+	if held != nil {
+		if claimOwner != "" {
+			// Let the task claim it again when it comes back
+			if err := d.Idempotency.Release(context.WithoutCancel(ctx), envelope.IdempotencyKey, claimOwner); err != nil {
+				logger.Error("Failed to release idempotency key", "idempotency_key", envelope.IdempotencyKey, logging.Err(err))
+			}
+		}
+		return *held
+	}
+	defer release()
+
 	// Set defaults
 	if envelope.MaxAttempts <= 0 {
 		envelope.MaxAttempts = DefaultMaxAttempts
@@ -69,39 +218,129 @@ func (d *Dispatcher) Dispatch(ctx context.Context, body []byte) DispatchResult {
 
 	// Create context with timeout if specified
 	taskCtx := ctx
+	if envelope.ParentResult != nil {
+		taskCtx = context.WithValue(taskCtx, parentResultKey{}, envelope.ParentResult)
+	}
 	if envelope.TimeoutSeconds > 0 {
 		var cancel context.CancelFunc
-		taskCtx, cancel = context.WithTimeout(ctx, time.Duration(envelope.TimeoutSeconds)*time.Second)
+		taskCtx, cancel = context.WithTimeout(taskCtx, time.Duration(envelope.TimeoutSeconds)*time.Second)
 		defer cancel()
 	}
 
+	d.record(ctx, &envelope, results.StatusStarted, nil, nil)
+
 	// Execute handler
 	start := time.Now()
-	err := handler.Handle(taskCtx, envelope.Payload)
+	var (
+		result interface{}
+		err    error
+	)
+	taskCtx, span := tracing.Tracer().Start(taskCtx, envelope.Task)
+	if rh, ok := handler.(ResultTaskHandler); ok {
+		result, err = rh.HandleResult(taskCtx, envelope.Payload)
+	} else {
+		err = handler.Handle(taskCtx, envelope.Payload)
+	}
+	tracing.End(span, err)
 	duration := time.Since(start)
+	interrupted := err != nil && ctx.Err() != nil
+	// The outcome is recorded even when the worker canceled ctx to shut down
+	ctx = context.WithoutCancel(ctx)
 
 	if err != nil {
-		log.Printf("Task %s (id=%s) failed: %v", envelope.Task, envelope.ID, err)
+		logger.Warn("Task failed", logging.Err(err), "duration", duration)
+		if claimOwner != "" {
+			if err := d.Idempotency.Release(ctx, envelope.IdempotencyKey, claimOwner); err != nil {
+				logger.Error("Failed to release idempotency key", "idempotency_key", envelope.IdempotencyKey, logging.Err(err))
+			}
+		}
+
+		if interrupted {
+			// Not the task's fault: run it again elsewhere without using
+			// up an attempt
+			return DispatchResult{Success: false, Interrupted: true, Error: err, TaskID: envelope.ID, Task: envelope.Task, Attempt: envelope.Attempt, Duration: duration}
+		}
 
 		// Check retries
 		if envelope.Attempt < envelope.MaxAttempts-1 {
 			// Retry
+			d.record(ctx, &envelope, results.StatusRetrying, nil, err)
 			return DispatchResult{
 				Success:      false,
 				Retry:        true,
 				RetryAttempt: envelope.Attempt + 1,
 				Error:        err,
+				TaskID:       envelope.ID,
+				Task:         envelope.Task,
+				Attempt:      envelope.Attempt,
+				Duration:     duration,
 			}
 		}
 
 		// Exhausted retries
+		d.record(ctx, &envelope, results.StatusFailed, nil, err)
+		d.completeMember(ctx, &envelope, nil, err)
 		d.notify(ctx, &envelope, "error", nil, err)
-		return DispatchResult{Success: false, Error: err}
+		return DispatchResult{Success: false, Error: err, TaskID: envelope.ID, Task: envelope.Task, Attempt: envelope.Attempt, Duration: duration}
+	}
+
+	logger.Info("Task succeeded", "duration", duration)
+	if claimOwner != "" {
+		if err := d.Idempotency.Complete(ctx, envelope.IdempotencyKey, claimOwner); err != nil {
+			logger.Error("Failed to complete idempotency key", "idempotency_key", envelope.IdempotencyKey, logging.Err(err))
+		}
 	}
 
-	log.Printf("Task %s (id=%s) succeeded in %v", envelope.Task, envelope.ID, duration)
-	d.notify(ctx, &envelope, "success", nil, nil) // Payload result not yet supported in Handle return
-	return DispatchResult{Success: true}
+	// The task has already run, so an unserializable result is logged
+	// rather than failing (and re-running) it
+	var resultJSON json.RawMessage
+	if result != nil {
+		if b, err := json.Marshal(result); err != nil {
+			logger.Error("Task returned a result that cannot be encoded", logging.Err(err))
+		} else {
+			resultJSON = b
+		}
+	}
+
+	d.record(ctx, &envelope, results.StatusSucceeded, resultJSON, nil)
+
+	var notifyResult interface{}
+	if resultJSON != nil {
+		notifyResult = resultJSON
+	}
+	d.notify(ctx, &envelope, "success", notifyResult, nil)
+	next := d.advance(ctx, &envelope, resultJSON)
+	return DispatchResult{Success: true, Result: resultJSON, Next: next, TaskID: envelope.ID, Task: envelope.Task, Attempt: envelope.Attempt, Duration: duration}
+}
+
+// idempotencyLease returns how long an idempotency claim is held: the task
+// timeout plus a margin, or idempotency.DefaultLease.
+func idempotencyLease(envelope *TaskPayload) time.Duration {
+	if envelope.TimeoutSeconds > 0 {
+		return time.Duration(envelope.TimeoutSeconds)*time.Second + time.Minute
+	}
+	return idempotency.DefaultLease
+}
+
+// record stores a state transition in the result backend, if configured.
+// Failures are logged and never affect the task outcome.
+func (d *Dispatcher) record(ctx context.Context, envelope *TaskPayload, status results.Status, result json.RawMessage, err error) {
+	if d.Results == nil || envelope.ID == "" {
+		return
+	}
+	u := results.Update{
+		TaskID:  envelope.ID,
+		Task:    envelope.Task,
+		Status:  status,
+		Attempt: envelope.Attempt,
+		Result:  result,
+	}
+	if err != nil {
+		u.Error = err.Error()
+	}
+	if err := d.Results.Update(ctx, u); err != nil {
+		logging.FromContext(ctx).Error("Failed to record task state", "status", status, logging.Err(err))
+	}
 }
 
 func (d *Dispatcher) notify(ctx context.Context, envelope *TaskPayload, status string, result interface{}, err error) {
@@ -125,20 +364,31 @@ func (d *Dispatcher) notify(ctx context.Context, envelope *TaskPayload, status s
 		notifyPayload["result"] = result
 	}
 
+	// Notifications outlive the task, but keep its logger and trace
+	logger := logging.FromContext(ctx)
+	ctx = context.WithoutCancel(ctx)
+
 	// Sockudo
 	if s := envelope.Notify.Sockudo; s != nil {
 		payloadToSend := notifyPayload
-		if !s.IncludePayload {
-			// Create a copy without result/payload if needed
-			// For now result is separate, but if we added envelope.Payload we'd strip it here
+		if !s.IncludePayload && result != nil {
+			// Sockudo channels may be visible to browsers, so only send the
+			// result when explicitly requested
+			payloadToSend = make(map[string]interface{}, len(notifyPayload))
+			for k, v := range notifyPayload {
+				if k != "result" {
+					payloadToSend[k] = v
+				}
+			}
 		}
-		
+
 		go func() {
 			// Use a detached context for notifications to ensure they run even if task ctx is canceled
-			notifyCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			notifyCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 			defer cancel()
 			if err := d.Broadcaster.Broadcast(notifyCtx, s.Channel, s.Event, payloadToSend); err != nil {
-				log.Printf("Failed to broadcast to Sockudo: %v", err)
+				logger.Error("Failed to broadcast to Sockudo", "channel", s.Channel, logging.Err(err))
+				metrics.NotificationFailures.WithLabelValues("sockudo").Inc()
 			}
 		}()
 	}
@@ -146,20 +396,34 @@ func (d *Dispatcher) notify(ctx context.Context, envelope *TaskPayload, status s
 	// Webhook
 	if w := envelope.Notify.Webhook; w != nil {
 		go func() {
-			notifyCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			notifyCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 			defer cancel()
 			if err := d.WebhookClient.Send(notifyCtx, w.URL, notifyPayload, w.OAuthClientID, w.OAuthScope); err != nil {
-				log.Printf("Failed to send webhook: %v", err)
+				logger.Error("Failed to send webhook", logging.Err(err))
+				metrics.NotificationFailures.WithLabelValues("webhook").Inc()
 			}
 		}()
 	}
 }
 
-// Helper to check if backoff is enabled
+// BackoffEnabled reports whether retries are delayed by GetBackoffDuration.
+// It is off unless BACKOFF_ENABLED is set to "true".
 func BackoffEnabled() bool {
 	return os.Getenv("BACKOFF_ENABLED") == "true"
 }
 
+// DelayStep returns the delay to use for a TTL wait queue when a message
+// must wait for d: the largest power-of-two number of seconds not above d,
+// and at least one second. Messages may thus arrive early, and are delayed
+// again for the remainder; this keeps the number of wait queues small.
+func DelayStep(d time.Duration) time.Duration {
+	step := time.Second
+	for step*2 <= d {
+		step *= 2
+	}
+	return step
+}
+
 func GetBackoffDuration(attempt int) time.Duration {
 	initial := 2
 	if s := os.Getenv("BACKOFF_INITIAL_SECONDS"); s != "" {
@@ -168,9 +432,6 @@ func GetBackoffDuration(attempt int) time.Duration {
 		}
 	}
 	
-	// Simple exponential: initial * 2^attempt
-	delay := time.Duration(initial * (1 << attempt)) * time.Second
-	
 	max := 30 * time.Second
 	if s := os.Getenv("BACKOFF_MAX_SECONDS"); s != "" {
 		if v, err := strconv.Atoi(s); err == nil {
@@ -178,6 +439,17 @@ func GetBackoffDuration(attempt int) time.Duration {
 		}
 	}
 
+	// Avoid overflowing the shift below on very high attempt counts
+	if attempt < 0 {
+		attempt = 0
+	}
+	if attempt > 20 {
+		return max
+	}
+
+	// Simple exponential: initial * 2^attempt
+	delay := time.Duration(initial * (1 << attempt)) * time.Second
+
 	if delay > max {
 		delay = max
 	}
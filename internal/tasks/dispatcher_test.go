@@ -1,13 +1,24 @@
 package tasks
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"log/slog"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"base-go-app/internal/broadcast"
+	"base-go-app/internal/idempotency"
+	"base-go-app/internal/logging"
+	"base-go-app/internal/metrics"
+	"base-go-app/internal/results"
 	"base-go-app/internal/webhook"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 type mockBroadcaster struct {
@@ -103,3 +114,443 @@ type failHandler struct{}
 func (f *failHandler) Handle(ctx context.Context, payload json.RawMessage) error {
 	return errors.New("always fail")
 }
+
+func TestBackoffEnabled(t *testing.T) {
+	t.Setenv("BACKOFF_ENABLED", "")
+	if BackoffEnabled() {
+		t.Fatalf("expected backoff to be disabled by default")
+	}
+	t.Setenv("BACKOFF_ENABLED", "true")
+	if !BackoffEnabled() {
+		t.Fatalf("expected backoff to be enabled")
+	}
+}
+
+func TestGetBackoffDuration(t *testing.T) {
+	t.Setenv("BACKOFF_INITIAL_SECONDS", "2")
+	t.Setenv("BACKOFF_MAX_SECONDS", "30")
+
+	cases := map[int]time.Duration{
+		0:   2 * time.Second,
+		1:   4 * time.Second,
+		3:   16 * time.Second,
+		4:   30 * time.Second,
+		100: 30 * time.Second,
+	}
+	for attempt, want := range cases {
+		if got := GetBackoffDuration(attempt); got != want {
+			t.Fatalf("attempt %d: expected %v, got %v", attempt, want, got)
+		}
+	}
+}
+
+type recordingWebhook struct {
+	mu      sync.Mutex
+	payload interface{}
+	sent    chan struct{}
+}
+
+func (r *recordingWebhook) Send(ctx context.Context, url string, payload interface{}, oauthClientID, oauthScope string) error {
+	r.mu.Lock()
+	r.payload = payload
+	r.mu.Unlock()
+	close(r.sent)
+	return nil
+}
+
+type sumPayload struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func TestDispatcherResultHandler(t *testing.T) {
+	ClearRegistry()
+	RegisterResultTask("sum", TypedHandler[sumPayload, map[string]int](func(ctx context.Context, p sumPayload) (map[string]int, error) {
+		return map[string]int{"sum": p.A + p.B}, nil
+	}))
+
+	wh := &recordingWebhook{sent: make(chan struct{})}
+	d := NewDispatcher(&broadcast.NoOpBroadcaster{}, wh)
+	backend := results.NewMemoryBackend(time.Hour)
+	d.Results = backend
+
+	body, _ := json.Marshal(TaskPayload{
+		Task:    "sum",
+		ID:      "abc",
+		Payload: json.RawMessage(`{"a":2,"b":3}`),
+		Notify:  &NotifyConfig{Webhook: &WebhookConfig{URL: "http://example.com"}},
+	})
+
+	res := d.Dispatch(context.Background(), body)
+	if !res.Success {
+		t.Fatalf("expected success, got error: %v", res.Error)
+	}
+	if string(res.Result) != `{"sum":5}` {
+		t.Fatalf("unexpected result: %s", res.Result)
+	}
+	rec, err := backend.Get(context.Background(), "abc")
+	if err != nil || rec.Status != string(results.StatusSucceeded) || string(rec.Result) != `{"sum":5}` {
+		t.Fatalf("result not stored: %+v (%v)", rec, err)
+	}
+
+	select {
+	case <-wh.sent:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("webhook not sent")
+	}
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	encoded, _ := json.Marshal(wh.payload)
+	var notified map[string]interface{}
+	_ = json.Unmarshal(encoded, &notified)
+	if sum := notified["result"].(map[string]interface{})["sum"]; sum != float64(5) {
+		t.Fatalf("expected result in webhook payload, got %v", notified)
+	}
+}
+
+func TestDispatcherSockudoOmitsResultUnlessRequested(t *testing.T) {
+	ClearRegistry()
+	RegisterResultTask("answer", TypedHandler[struct{}, int](func(ctx context.Context, _ struct{}) (int, error) {
+		return 42, nil
+	}))
+
+	for _, include := range []bool{false, true} {
+		b := &syncBroadcaster{sent: make(chan map[string]interface{}, 1)}
+		d := NewDispatcher(b, nil)
+		body, _ := json.Marshal(TaskPayload{
+			Task:   "answer",
+			ID:     "1",
+			Notify: &NotifyConfig{Sockudo: &SockudoConfig{Channel: "c", Event: "e", IncludePayload: include}},
+		})
+		if res := d.Dispatch(context.Background(), body); !res.Success {
+			t.Fatalf("expected success, got error: %v", res.Error)
+		}
+
+		select {
+		case payload := <-b.sent:
+			_, has := payload["result"]
+			if has != include {
+				t.Fatalf("include_payload=%v: result present=%v", include, has)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("broadcast not sent")
+		}
+	}
+}
+
+func TestTypedHandlerInvalidPayload(t *testing.T) {
+	h := TypedHandler[sumPayload, int](func(ctx context.Context, p sumPayload) (int, error) {
+		return p.A + p.B, nil
+	})
+	if err := h.Handle(context.Background(), json.RawMessage(`{invalid`)); err == nil {
+		t.Fatalf("expected error for invalid payload")
+	}
+}
+
+type syncBroadcaster struct {
+	sent chan map[string]interface{}
+}
+
+func (s *syncBroadcaster) Broadcast(ctx context.Context, channel, event string, payload interface{}) error {
+	s.sent <- payload.(map[string]interface{})
+	return nil
+}
+
+func TestDispatcherRecordsStates(t *testing.T) {
+	ClearRegistry()
+	RegisterTask("fail_task", &failHandler{})
+
+	d := NewDispatcher(nil, nil)
+	backend := results.NewMemoryBackend(time.Hour)
+	d.Results = backend
+
+	for attempt := 0; attempt < 2; attempt++ {
+		body, _ := json.Marshal(TaskPayload{Task: "fail_task", ID: "f1", Attempt: attempt, MaxAttempts: 2})
+		d.Dispatch(context.Background(), body)
+
+		rec, err := backend.Get(context.Background(), "f1")
+		if err != nil {
+			t.Fatalf("expected record: %v", err)
+		}
+		want := results.StatusRetrying
+		if attempt == 1 {
+			want = results.StatusFailed
+		}
+		if rec.Status != string(want) || rec.Attempts != attempt+1 || rec.Error != "always fail" {
+			t.Fatalf("attempt %d: unexpected record %+v", attempt, rec)
+		}
+	}
+
+	body, _ := json.Marshal(TaskPayload{Task: "nope", ID: "u1"})
+	d.Dispatch(context.Background(), body)
+	if rec, err := backend.Get(context.Background(), "u1"); err != nil || rec.Status != string(results.StatusFailed) {
+		t.Fatalf("expected unknown task to be recorded as failed: %+v (%v)", rec, err)
+	}
+}
+
+type countingHandler struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (c *countingHandler) Handle(ctx context.Context, payload json.RawMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	return c.err
+}
+
+func TestDispatcherIdempotency(t *testing.T) {
+	ClearRegistry()
+	h := &countingHandler{}
+	RegisterTask("counted", h)
+
+	d := NewDispatcher(nil, nil)
+	store := idempotency.NewMemoryStore(time.Hour)
+	d.Idempotency = store
+
+	body, _ := json.Marshal(TaskPayload{Task: "counted", ID: "1", IdempotencyKey: "order-1"})
+	res := d.Dispatch(context.Background(), body)
+	if !res.Success || res.Duplicate {
+		t.Fatalf("expected first execution to run: %+v", res)
+	}
+
+	// Double-published task with the same key
+	body, _ = json.Marshal(TaskPayload{Task: "counted", ID: "2", IdempotencyKey: "order-1"})
+	res = d.Dispatch(context.Background(), body)
+	if !res.Success || !res.Duplicate {
+		t.Fatalf("expected duplicate to be skipped: %+v", res)
+	}
+	if h.calls != 1 {
+		t.Fatalf("expected handler to run once, ran %d times", h.calls)
+	}
+
+	// Tasks without a key are never deduplicated
+	body, _ = json.Marshal(TaskPayload{Task: "counted", ID: "3"})
+	d.Dispatch(context.Background(), body)
+	d.Dispatch(context.Background(), body)
+	if h.calls != 3 {
+		t.Fatalf("expected handler to run 3 times, ran %d times", h.calls)
+	}
+}
+
+func TestDispatcherIdempotencyInProgress(t *testing.T) {
+	ClearRegistry()
+	h := &countingHandler{}
+	RegisterTask("counted", h)
+
+	d := NewDispatcher(nil, nil)
+	store := idempotency.NewMemoryStore(time.Hour)
+	d.Idempotency = store
+
+	// Another worker holds the key
+	if _, err := store.Acquire(context.Background(), "order-1", "other-pod", time.Minute); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	body, _ := json.Marshal(TaskPayload{Task: "counted", ID: "1", Attempt: 2, IdempotencyKey: "order-1"})
+	res := d.Dispatch(context.Background(), body)
+	if res.Success || !res.Retry || res.RetryAttempt != 2 || !errors.Is(res.Error, ErrDuplicateInProgress) {
+		t.Fatalf("expected deferred retry without consuming an attempt: %+v", res)
+	}
+	if h.calls != 0 {
+		t.Fatalf("expected handler not to run")
+	}
+}
+
+func TestDispatcherIdempotencyReleasedOnFailure(t *testing.T) {
+	ClearRegistry()
+	h := &countingHandler{err: errors.New("boom")}
+	RegisterTask("counted", h)
+
+	d := NewDispatcher(nil, nil)
+	d.Idempotency = idempotency.NewMemoryStore(time.Hour)
+
+	body, _ := json.Marshal(TaskPayload{Task: "counted", ID: "1", MaxAttempts: 3, IdempotencyKey: "order-1"})
+	if res := d.Dispatch(context.Background(), body); !res.Retry {
+		t.Fatalf("expected retry: %+v", res)
+	}
+
+	// The retry can claim the key again
+	h.err = nil
+	body, _ = json.Marshal(TaskPayload{Task: "counted", ID: "1", Attempt: 1, MaxAttempts: 3, IdempotencyKey: "order-1"})
+	if res := d.Dispatch(context.Background(), body); !res.Success || res.Duplicate {
+		t.Fatalf("expected retry to run: %+v", res)
+	}
+	if h.calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", h.calls)
+	}
+}
+
+func TestDispatcherRejectsIncompatibleVersion(t *testing.T) {
+	ClearRegistry()
+	h := &countingHandler{}
+	RegisterTask("test_task", h)
+
+	d := NewDispatcher(&broadcast.NoOpBroadcaster{}, &webhook.NoOpClient{})
+	d.Results = results.NewMemoryBackend(time.Hour)
+
+	body, _ := json.Marshal(TaskPayload{Version: "2.0", Task: "test_task", ID: "v2", MaxAttempts: 3})
+	res := d.Dispatch(context.Background(), body)
+	if res.Success || res.Retry {
+		t.Fatalf("expected a permanent failure, got %+v", res)
+	}
+	if !errors.Is(res.Error, ErrIncompatibleVersion) {
+		t.Fatalf("expected ErrIncompatibleVersion, got %v", res.Error)
+	}
+	if h.calls != 0 {
+		t.Fatalf("handler must not run")
+	}
+	rec, err := d.Results.Get(context.Background(), "v2")
+	if err != nil || rec.Status != string(results.StatusFailed) {
+		t.Fatalf("expected failed record, got %+v, %v", rec, err)
+	}
+}
+
+func TestDispatcherDefersTasksBeforeETA(t *testing.T) {
+	ClearRegistry()
+	h := &countingHandler{}
+	RegisterTask("test_task", h)
+
+	d := NewDispatcher(&broadcast.NoOpBroadcaster{}, &webhook.NoOpClient{})
+	eta := time.Now().Add(time.Minute).Format(time.RFC3339Nano)
+	body, _ := json.Marshal(TaskPayload{Task: "test_task", ID: "later", Attempt: 2, MaxAttempts: 3, ETA: eta})
+
+	res := d.Dispatch(context.Background(), body)
+	if !res.Retry || !errors.Is(res.Error, ErrNotDue) {
+		t.Fatalf("expected a not-due retry, got %+v", res)
+	}
+	if res.RetryAttempt != 2 {
+		t.Fatalf("waiting must not consume an attempt, got %d", res.RetryAttempt)
+	}
+	if res.RetryDelay <= 50*time.Second || res.RetryDelay > time.Minute {
+		t.Fatalf("unexpected retry delay %v", res.RetryDelay)
+	}
+	if h.calls != 0 {
+		t.Fatalf("handler must not run before the ETA")
+	}
+}
+
+type waitHandler struct{}
+
+func (waitHandler) Handle(ctx context.Context, payload json.RawMessage) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestDispatcherInterruptedByShutdown(t *testing.T) {
+	ClearRegistry()
+	RegisterTask("wait_task", waitHandler{})
+
+	d := NewDispatcher(&broadcast.NoOpBroadcaster{}, &webhook.NoOpClient{})
+	backend := results.NewMemoryBackend(time.Hour)
+	d.Results = backend
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	// Last attempt: a failure would not be retried
+	body, _ := json.Marshal(TaskPayload{Task: "wait_task", ID: "1", Attempt: 2, MaxAttempts: 3, Payload: json.RawMessage(`{}`)})
+
+	res := d.Dispatch(ctx, body)
+	if res.Success || !res.Interrupted || res.Retry {
+		t.Fatalf("expected an interrupted task, got %+v", res)
+	}
+	rec, err := backend.Get(context.Background(), "1")
+	if err != nil || rec.Status == string(results.StatusFailed) {
+		t.Fatalf("interrupted task must not be recorded as failed: %+v (%v)", rec, err)
+	}
+
+	// A timeout is a failure of the task itself
+	body, _ = json.Marshal(TaskPayload{Task: "wait_task", ID: "2", MaxAttempts: 1, TimeoutSeconds: 1, Payload: json.RawMessage(`{}`)})
+	res = d.Dispatch(context.Background(), body)
+	if res.Success || res.Interrupted {
+		t.Fatalf("expected a failed task, got %+v", res)
+	}
+}
+
+type failingBroadcaster struct{ done chan struct{} }
+
+func (f *failingBroadcaster) Broadcast(ctx context.Context, channel, event string, payload interface{}) error {
+	defer close(f.done)
+	return errors.New("sockudo down")
+}
+
+func TestDispatcherCountsNotificationFailures(t *testing.T) {
+	ClearRegistry()
+	RegisterTask("test_task", &mockHandler{})
+
+	failures := metrics.NotificationFailures.WithLabelValues("sockudo")
+	before := testutil.ToFloat64(failures)
+
+	b := &failingBroadcaster{done: make(chan struct{})}
+	d := NewDispatcher(b, &webhook.NoOpClient{})
+	body, _ := json.Marshal(TaskPayload{
+		Task:    "test_task",
+		ID:      "1",
+		Payload: json.RawMessage(`{}`),
+		Notify:  &NotifyConfig{Sockudo: &SockudoConfig{Channel: "c", Event: "e"}},
+	})
+	if res := d.Dispatch(context.Background(), body); !res.Success {
+		t.Fatalf("expected success, got %v", res.Error)
+	}
+
+	<-b.done
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if testutil.ToFloat64(failures) == before+1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("notification failure not counted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type loggingHandler struct{}
+
+func (loggingHandler) Handle(ctx context.Context, payload json.RawMessage) error {
+	logging.FromContext(ctx).Info("from handler")
+	return nil
+}
+
+func TestDispatcherLogsTaskAttributes(t *testing.T) {
+	ClearRegistry()
+	RegisterTask("log_task", loggingHandler{})
+
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "info", logging.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := logging.NewContext(context.Background(), logger.With(logging.KeyQueue, "q1"))
+
+	d := NewDispatcher(&broadcast.NoOpBroadcaster{}, &webhook.NoOpClient{})
+	body, _ := json.Marshal(TaskPayload{Task: "log_task", ID: "42", Attempt: 1, MaxAttempts: 3, Payload: json.RawMessage(`{}`)})
+	if res := d.Dispatch(ctx, body); !res.Success {
+		t.Fatalf("expected success, got %v", res.Error)
+	}
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid JSON log line %q: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	var fromHandler bool
+	for _, rec := range records {
+		if rec[logging.KeyTaskID] != "42" || rec[logging.KeyTask] != "log_task" ||
+			rec[logging.KeyAttempt] != float64(1) || rec[logging.KeyQueue] != "q1" {
+			t.Fatalf("record without the task attributes: %v", rec)
+		}
+		if rec[slog.MessageKey] == "from handler" {
+			fromHandler = true
+		}
+	}
+	if !fromHandler {
+		t.Fatalf("handler did not log through the task logger: %v", records)
+	}
+}
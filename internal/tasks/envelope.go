@@ -0,0 +1,99 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvelopeVersion is the Go envelope version produced by the publisher.
+// Versions are "major.minor": a worker accepts any envelope with its own
+// major version, so minor versions may only add optional fields.
+//
+// 1.1 added eta, chain, parent_result and group.
+const EnvelopeVersion = "1.1"
+
+// ErrIncompatibleVersion is returned for envelopes whose major version this
+// worker does not understand. Retrying such a task cannot help.
+var ErrIncompatibleVersion = errors.New("incompatible task envelope version")
+
+// CheckEnvelopeVersion reports whether an envelope of version v can be
+// handled by this build. An empty version is treated as 1.0, which is what
+// envelopes without a version field have always meant.
+func CheckEnvelopeVersion(v string) error {
+	if v == "" {
+		return nil
+	}
+	major, err := majorVersion(v)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrIncompatibleVersion, v)
+	}
+	want, _ := majorVersion(EnvelopeVersion)
+	if major != want {
+		return fmt.Errorf("%w: %q (supported: %d.x)", ErrIncompatibleVersion, v, want)
+	}
+	return nil
+}
+
+func majorVersion(v string) (int, error) {
+	major, _, _ := strings.Cut(v, ".")
+	return strconv.Atoi(major)
+}
+
+// Identify returns the ID and name of the task carried by a message,
+// without decoding its payload. They are empty when the message is not a
+// task.
+func Identify(body []byte, headers map[string]interface{}) (id, task string) {
+	if IsCeleryMessage(headers) {
+		return headers["id"].(string), headers["task"].(string)
+	}
+	var envelope struct {
+		ID   string `json:"id"`
+		Task string `json:"task"`
+	}
+	_ = json.Unmarshal(body, &envelope)
+	return envelope.ID, envelope.Task
+}
+
+// Validate checks that an envelope is complete and of a compatible
+// version. The publisher validates envelopes before sending them; the
+// dispatcher only checks the version, so older envelopes keep working.
+func (p *TaskPayload) Validate() error {
+	if err := CheckEnvelopeVersion(p.Version); err != nil {
+		return err
+	}
+	if p.Task == "" {
+		return errors.New("task name is required")
+	}
+	if p.ID == "" {
+		return errors.New("task id is required")
+	}
+	if p.Attempt < 0 || p.MaxAttempts < 0 || p.TimeoutSeconds < 0 {
+		return errors.New("attempt, max_attempts and timeout_seconds must not be negative")
+	}
+	if p.ETA != "" {
+		if _, err := time.Parse(time.RFC3339Nano, p.ETA); err != nil {
+			return fmt.Errorf("invalid eta %q: %w", p.ETA, err)
+		}
+	}
+	for _, step := range p.Chain {
+		if step.Task == "" {
+			return errors.New("chain steps require a task name")
+		}
+	}
+	if p.Group != nil && (p.Group.ID == "" || p.Group.Index < 0) {
+		return errors.New("group requires an id and a non-negative index")
+	}
+	if n := p.Notify; n != nil {
+		if n.Sockudo != nil && (n.Sockudo.Channel == "" || n.Sockudo.Event == "") {
+			return errors.New("notify.sockudo requires channel and event")
+		}
+		if n.Webhook != nil && n.Webhook.URL == "" {
+			return errors.New("notify.webhook requires url")
+		}
+	}
+	return nil
+}
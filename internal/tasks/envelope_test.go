@@ -0,0 +1,79 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckEnvelopeVersion(t *testing.T) {
+	for _, v := range []string{"", "1", "1.0", "1.7"} {
+		assert.NoError(t, CheckEnvelopeVersion(v), v)
+	}
+	for _, v := range []string{"2.0", "0.9", "v1", "celery/2"} {
+		assert.ErrorIs(t, CheckEnvelopeVersion(v), ErrIncompatibleVersion, v)
+	}
+}
+
+func TestTaskPayloadValidate(t *testing.T) {
+	valid := func() TaskPayload {
+		return TaskPayload{Version: EnvelopeVersion, ID: "id-1", Task: "logger", MaxAttempts: 5}
+	}
+
+	p := valid()
+	assert.NoError(t, p.Validate())
+
+	p = valid()
+	p.Version = "2.0"
+	assert.ErrorIs(t, p.Validate(), ErrIncompatibleVersion)
+
+	p = valid()
+	p.Task = ""
+	assert.Error(t, p.Validate())
+
+	p = valid()
+	p.ID = ""
+	assert.Error(t, p.Validate())
+
+	p = valid()
+	p.TimeoutSeconds = -1
+	assert.Error(t, p.Validate())
+
+	p = valid()
+	p.Notify = &NotifyConfig{Webhook: &WebhookConfig{}}
+	assert.Error(t, p.Validate())
+
+	p = valid()
+	p.Notify = &NotifyConfig{Sockudo: &SockudoConfig{Channel: "c"}}
+	assert.Error(t, p.Validate())
+
+	p.Notify.Sockudo.Event = "e"
+	assert.NoError(t, p.Validate())
+
+	p = valid()
+	p.ETA = "tomorrow"
+	assert.Error(t, p.Validate())
+}
+
+func TestDelayStep(t *testing.T) {
+	assert.Equal(t, time.Second, DelayStep(0))
+	assert.Equal(t, time.Second, DelayStep(1500*time.Millisecond))
+	assert.Equal(t, 2*time.Second, DelayStep(2*time.Second))
+	assert.Equal(t, 64*time.Second, DelayStep(100*time.Second))
+	assert.Equal(t, 65536*time.Second, DelayStep(24*time.Hour))
+}
+
+func TestIdentify(t *testing.T) {
+	id, task := Identify([]byte(`{"id":"abc","task":"logger","payload":{"x":1}}`), nil)
+	assert.Equal(t, "abc", id)
+	assert.Equal(t, "logger", task)
+
+	id, task = Identify([]byte(`[[], {}, {}]`), map[string]interface{}{"id": "c1", "task": "celery.task"})
+	assert.Equal(t, "c1", id)
+	assert.Equal(t, "celery.task", task)
+
+	id, task = Identify([]byte(`not json`), nil)
+	assert.Empty(t, id)
+	assert.Empty(t, task)
+}
@@ -3,6 +3,7 @@ package tasks
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 )
 
 // TaskHandler is the interface that all task handlers must implement.
@@ -12,6 +13,36 @@ type TaskHandler interface {
 	Handle(ctx context.Context, payload json.RawMessage) error
 }
 
+// ResultTaskHandler is implemented by handlers that produce a result.
+// The result must be JSON-serializable; the dispatcher includes it in the
+// completion notification and hands it to the result backend.
+type ResultTaskHandler interface {
+	// HandleResult processes the task payload and returns its result.
+	// It returns an error if the task failed.
+	HandleResult(ctx context.Context, payload json.RawMessage) (interface{}, error)
+}
+
+// TypedHandler adapts a function taking a decoded payload of type P and
+// returning a result of type R into a ResultTaskHandler.
+type TypedHandler[P any, R any] func(ctx context.Context, payload P) (R, error)
+
+// HandleResult decodes the payload into P and calls the function.
+func (f TypedHandler[P, R]) HandleResult(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	var p P
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+		}
+	}
+	return f(ctx, p)
+}
+
+// Handle implements TaskHandler by discarding the result.
+func (f TypedHandler[P, R]) Handle(ctx context.Context, payload json.RawMessage) error {
+	_, err := f.HandleResult(ctx, payload)
+	return err
+}
+
 // TaskPayload represents the standard envelope for tasks.
 type TaskPayload struct {
 	Version        string          `json:"version"`
@@ -23,8 +54,20 @@ type TaskPayload struct {
 	MaxAttempts    int             `json:"max_attempts"`
 	TimeoutSeconds int             `json:"timeout_seconds,omitempty"`
 	IdempotencyKey string          `json:"idempotency_key,omitempty"`
-	Meta           json.RawMessage `json:"meta,omitempty"`
-	Notify         *NotifyConfig   `json:"notify,omitempty"`
+	// ETA is the earliest time (RFC 3339) the task may run. Workers re-delay
+	// messages that arrive before it.
+	ETA    string          `json:"eta,omitempty"`
+	Meta   json.RawMessage `json:"meta,omitempty"`
+	Notify *NotifyConfig   `json:"notify,omitempty"`
+
+	// Chain lists the tasks to run one after another once this task
+	// succeeds; each receives the result of the previous one.
+	Chain []Signature `json:"chain,omitempty"`
+	// ParentResult is the result of the previous chain step, or the member
+	// results of a chord.
+	ParentResult json.RawMessage `json:"parent_result,omitempty"`
+	// Group is set for members of a group or chord.
+	Group *GroupRef `json:"group,omitempty"`
 }
 
 // NotifyConfig defines notification preferences for task completion.
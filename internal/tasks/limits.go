@@ -0,0 +1,112 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"base-go-app/internal/logging"
+	"base-go-app/internal/ratelimit"
+)
+
+// ErrConcurrencyLimited and ErrRateLimited are returned for tasks held
+// back by the options of their type; they are re-delayed without
+// consuming an attempt.
+var (
+	ErrConcurrencyLimited = errors.New("task type is at its concurrency limit")
+	ErrRateLimited        = errors.New("task type is over its rate limit")
+)
+
+const (
+	// limitWait is how long a task over its limits may hold a worker
+	// waiting for a slot or token before it is re-delayed instead.
+	limitWait = time.Second
+	// concurrencyRetryDelay is how long a task is re-delayed when every
+	// execution slot of its type stays busy.
+	concurrencyRetryDelay = 2 * time.Second
+)
+
+// throttle enforces the options of the task's type. It returns a function
+// releasing the task's execution slot or, when the task is over a limit
+// for longer than limitWait, the result re-delaying it.
+func (d *Dispatcher) throttle(ctx context.Context, envelope *TaskPayload) (func(), *DispatchResult) {
+	opts := LookupTaskOptions(envelope.Task)
+	release := func() {}
+
+	if opts.MaxConcurrency > 0 {
+		slot := d.slot(envelope.Task, opts.MaxConcurrency)
+		select {
+		case slot <- struct{}{}:
+		default:
+			timer := time.NewTimer(limitWait)
+			defer timer.Stop()
+			select {
+			case slot <- struct{}{}:
+			case <-timer.C:
+				return nil, throttled(envelope, ErrConcurrencyLimited, concurrencyRetryDelay)
+			case <-ctx.Done():
+				return nil, interruptedWaiting(ctx, envelope)
+			}
+		}
+		release = func() { <-slot }
+	}
+
+	if opts.RateLimit > 0 && d.RateLimiter != nil {
+		limit := ratelimit.Limit{Rate: opts.RateLimit, Burst: opts.Burst}
+		wait, ok, err := d.RateLimiter.Reserve(ctx, envelope.Task, limit, limitWait)
+		switch {
+		case err != nil:
+			// Fail open: limits protect downstream services, they are
+			// not worth dropping work over
+			logging.FromContext(ctx).Warn("Rate limiter failed, executing anyway", logging.Err(err))
+		case !ok:
+			release()
+			return nil, throttled(envelope, ErrRateLimited, wait)
+		case wait > 0:
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				release()
+				return nil, interruptedWaiting(ctx, envelope)
+			}
+		}
+	}
+	return release, nil
+}
+
+// slot returns the semaphore bounding the executions of task to max.
+func (d *Dispatcher) slot(task string, max int) chan struct{} {
+	d.slotsMu.Lock()
+	defer d.slotsMu.Unlock()
+	if d.slots == nil {
+		d.slots = make(map[string]chan struct{})
+	}
+	s, ok := d.slots[task]
+	if !ok || cap(s) != max {
+		s = make(chan struct{}, max)
+		d.slots[task] = s
+	}
+	return s
+}
+
+// throttled re-delays a task by delay without consuming an attempt.
+func throttled(envelope *TaskPayload, err error, delay time.Duration) *DispatchResult {
+	return &DispatchResult{
+		Success:      false,
+		Retry:        true,
+		RetryAttempt: envelope.Attempt,
+		RetryDelay:   delay,
+		Error:        err,
+		TaskID:       envelope.ID,
+		Task:         envelope.Task,
+		Attempt:      envelope.Attempt,
+	}
+}
+
+// interruptedWaiting is the result of a task whose wait for a slot or
+// token was cut short by shutdown.
+func interruptedWaiting(ctx context.Context, envelope *TaskPayload) *DispatchResult {
+	return &DispatchResult{Success: false, Interrupted: true, Error: ctx.Err(), TaskID: envelope.ID, Task: envelope.Task, Attempt: envelope.Attempt}
+}
@@ -0,0 +1,187 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"base-go-app/internal/idempotency"
+	"base-go-app/internal/ratelimit"
+)
+
+// gateHandler signals when it starts and runs until released.
+type gateHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g *gateHandler) Handle(ctx context.Context, payload json.RawMessage) error {
+	g.started <- struct{}{}
+	<-g.release
+	return nil
+}
+
+func limitedTask(id string, attempt int) []byte {
+	body, _ := json.Marshal(TaskPayload{Task: "limited", ID: id, Attempt: attempt, MaxAttempts: 5, Payload: json.RawMessage(`{}`)})
+	return body
+}
+
+func TestDispatcherConcurrencyLimit(t *testing.T) {
+	ClearRegistry()
+	defer ClearRegistry()
+	h := &gateHandler{started: make(chan struct{}, 3), release: make(chan struct{})}
+	RegisterTask("limited", h, WithMaxConcurrency(1))
+	d := NewDispatcher(nil, nil)
+
+	first := make(chan DispatchResult, 1)
+	go func() { first <- d.Dispatch(context.Background(), limitedTask("1", 0)) }()
+	<-h.started
+
+	// The slot stays busy: re-delayed after waiting, without using up an attempt
+	res := d.Dispatch(context.Background(), limitedTask("2", 3))
+	if !res.Retry || !errors.Is(res.Error, ErrConcurrencyLimited) {
+		t.Fatalf("expected a concurrency-limited retry, got %+v", res)
+	}
+	if res.RetryAttempt != 3 || res.RetryDelay != concurrencyRetryDelay {
+		t.Fatalf("unexpected retry attempt %d / delay %v", res.RetryAttempt, res.RetryDelay)
+	}
+
+	// The slot frees up while waiting: the task runs
+	second := make(chan DispatchResult, 1)
+	go func() { second <- d.Dispatch(context.Background(), limitedTask("3", 0)) }()
+	time.Sleep(100 * time.Millisecond)
+	h.release <- struct{}{}
+	if res := <-first; !res.Success {
+		t.Fatalf("expected the first task to succeed, got %+v", res)
+	}
+	<-h.started
+	h.release <- struct{}{}
+	if res := <-second; !res.Success {
+		t.Fatalf("expected the waiting task to run, got %+v", res)
+	}
+}
+
+func TestDispatcherConcurrencyLimitInterrupted(t *testing.T) {
+	ClearRegistry()
+	defer ClearRegistry()
+	h := &gateHandler{started: make(chan struct{}, 1), release: make(chan struct{})}
+	RegisterTask("limited", h, WithMaxConcurrency(1))
+	d := NewDispatcher(nil, nil)
+
+	go d.Dispatch(context.Background(), limitedTask("1", 0))
+	<-h.started
+	defer close(h.release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	res := d.Dispatch(ctx, limitedTask("2", 0))
+	if !res.Interrupted || res.Retry {
+		t.Fatalf("expected an interrupted task, got %+v", res)
+	}
+}
+
+func TestDispatcherRateLimit(t *testing.T) {
+	ClearRegistry()
+	defer ClearRegistry()
+	h := &countingHandler{}
+	RegisterTask("limited", h, WithRateLimit(0.5, 1))
+	d := NewDispatcher(nil, nil)
+
+	if res := d.Dispatch(context.Background(), limitedTask("1", 0)); !res.Success {
+		t.Fatalf("expected the first task to run, got %+v", res)
+	}
+	// The next token is two seconds away: longer than a task may wait
+	res := d.Dispatch(context.Background(), limitedTask("2", 1))
+	if !res.Retry || !errors.Is(res.Error, ErrRateLimited) || res.RetryAttempt != 1 {
+		t.Fatalf("expected a rate-limited retry, got %+v", res)
+	}
+	if res.RetryDelay < time.Second || res.RetryDelay > 2*time.Second {
+		t.Fatalf("expected a delay until the next token, got %v", res.RetryDelay)
+	}
+	if h.calls != 1 {
+		t.Fatalf("expected one execution, got %d", h.calls)
+	}
+}
+
+func TestDispatcherRateLimitSkipsDuplicates(t *testing.T) {
+	ClearRegistry()
+	defer ClearRegistry()
+	h := &countingHandler{}
+	RegisterTask("limited", h, WithRateLimit(0.5, 1))
+	d := NewDispatcher(nil, nil)
+	store := idempotency.NewMemoryStore(time.Hour)
+	d.Idempotency = store
+
+	keyed := func(id, key string) []byte {
+		body, _ := json.Marshal(TaskPayload{Task: "limited", ID: id, MaxAttempts: 5, IdempotencyKey: key, Payload: json.RawMessage(`{}`)})
+		return body
+	}
+	if _, err := store.Acquire(context.Background(), "order-1", "other-pod", time.Minute); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if err := store.Complete(context.Background(), "order-1", "other-pod"); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if _, err := store.Acquire(context.Background(), "order-2", "other-pod", time.Minute); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	// Duplicates are settled without taking the only token
+	if res := d.Dispatch(context.Background(), keyed("1", "order-1")); !res.Duplicate {
+		t.Fatalf("expected a duplicate, got %+v", res)
+	}
+	if res := d.Dispatch(context.Background(), keyed("2", "order-2")); !errors.Is(res.Error, ErrDuplicateInProgress) {
+		t.Fatalf("expected a duplicate in progress, got %+v", res)
+	}
+	if res := d.Dispatch(context.Background(), keyed("3", "order-3")); !res.Success || res.Duplicate {
+		t.Fatalf("expected the task to run, got %+v", res)
+	}
+
+	// A throttled task gives its key back for when it comes back
+	if res := d.Dispatch(context.Background(), keyed("4", "order-4")); !errors.Is(res.Error, ErrRateLimited) {
+		t.Fatalf("expected a rate-limited retry, got %+v", res)
+	}
+	if state, err := store.Acquire(context.Background(), "order-4", "other-pod", time.Minute); err != nil || state != idempotency.Acquired {
+		t.Fatalf("expected the key to be free, got %v, %v", state, err)
+	}
+	if h.calls != 1 {
+		t.Fatalf("expected one execution, got %d", h.calls)
+	}
+}
+
+func TestDispatcherRateLimitWaits(t *testing.T) {
+	ClearRegistry()
+	defer ClearRegistry()
+	RegisterTask("limited", &countingHandler{}, WithRateLimit(10, 1))
+	d := NewDispatcher(nil, nil)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if res := d.Dispatch(context.Background(), limitedTask(string(rune('a'+i)), 0)); !res.Success {
+			t.Fatalf("expected task %d to run, got %+v", i, res)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected the tasks to be spaced by the rate, took %v", elapsed)
+	}
+}
+
+type brokenLimiter struct{}
+
+func (brokenLimiter) Reserve(context.Context, string, ratelimit.Limit, time.Duration) (time.Duration, bool, error) {
+	return 0, false, errors.New("database down")
+}
+
+func TestDispatcherRateLimiterFailsOpen(t *testing.T) {
+	ClearRegistry()
+	defer ClearRegistry()
+	RegisterTask("limited", &countingHandler{}, WithRateLimit(1, 1))
+	d := NewDispatcher(nil, nil)
+	d.RateLimiter = brokenLimiter{}
+
+	if res := d.Dispatch(context.Background(), limitedTask("1", 0)); !res.Success {
+		t.Fatalf("expected the task to run when the limiter fails, got %+v", res)
+	}
+}
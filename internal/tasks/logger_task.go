@@ -2,11 +2,12 @@ package tasks
 
 import (
 	"base-go-app/internal/database"
+	"base-go-app/internal/logging"
+	"base-go-app/internal/metrics"
 	"base-go-app/internal/models"
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"strconv"
 	"time"
 
@@ -34,10 +35,12 @@ func (h *LoggerTaskHandler) Handle(ctx context.Context, args json.RawMessage) er
 		return fmt.Errorf("failed to unmarshal logger payload: %w", err)
 	}
 
-	return processLoggerPayload(payload)
+	return processLoggerPayload(ctx, payload)
 }
 
-func processLoggerPayload(payload LoggerTaskPayload) error {
+func processLoggerPayload(ctx context.Context, payload LoggerTaskPayload) error {
+	logger := logging.FromContext(ctx)
+
 	// Convert Level to int
 	var levelInt int
 	var err error
@@ -51,11 +54,11 @@ func processLoggerPayload(payload LoggerTaskPayload) error {
 		levelInt, err = strconv.Atoi(v)
 		if err != nil {
 			levelInt = 0
-			log.Printf("Warning: invalid level string %s, defaulting to 0", v)
+			logger.Warn("Invalid level string, defaulting to 0", "level", v)
 		}
 	default:
 		levelInt = 0
-		log.Printf("Warning: invalid level type %T, defaulting to 0", v)
+		logger.Warn("Invalid level type, defaulting to 0", "type", fmt.Sprintf("%T", v))
 	}
 
 	// Normalize Context and Extra to map[string]interface{}
@@ -73,7 +76,7 @@ func processLoggerPayload(payload LoggerTaskPayload) error {
 			logDate, err = time.Parse("2006-01-02 15:04:05.000", payload.Datetime)
 			if err != nil {
 				logDate = time.Now()
-				log.Printf("Warning: invalid datetime %s, defaulting to now", payload.Datetime)
+				logger.Warn("Invalid datetime, defaulting to now", "datetime", payload.Datetime)
 			}
 		}
 	}
@@ -99,16 +102,19 @@ func processLoggerPayload(payload LoggerTaskPayload) error {
 	// If DB is not connected, skip persisting logs to avoid panics and
 	// allow the worker to continue processing other tasks.
 	if !database.Connected() || database.DB == nil {
-		log.Printf("Database not connected; skipping saving log: %s", serverLog.ID)
+		logger.Warn("Database not connected; skipping saving log", "log_id", serverLog.ID)
 		return nil
 	}
 
+	start := time.Now()
 	if err := database.DB.Create(&serverLog).Error; err != nil {
-		log.Printf("Failed to save log to DB: %v", err)
+		metrics.LoggerDBWriteDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
+		logger.Error("Failed to save log to DB", "log_id", serverLog.ID, logging.Err(err))
 		return err
 	}
+	metrics.LoggerDBWriteDuration.WithLabelValues("ok").Observe(time.Since(start).Seconds())
 
-	log.Printf("Successfully saved log: %s", serverLog.ID)
+	logger.Debug("Saved log", "log_id", serverLog.ID)
 	return nil
 }
 
@@ -1,18 +1,51 @@
 package tasks
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
 )
 
 var (
 	registry = make(map[string]TaskHandler)
+	options  = make(map[string]TaskOptions)
 	mu       sync.RWMutex
 )
 
+// TaskOptions limit how a task type is executed. The dispatcher holds back
+// tasks over their limits; they wait or are re-delayed, not failed.
+type TaskOptions struct {
+	// MaxConcurrency caps how many tasks of the type run at once in the
+	// worker process (0: no limit).
+	MaxConcurrency int
+	// RateLimit caps how many tasks of the type start per second (0: no
+	// limit), with bursts of up to Burst tasks (default 1).
+	RateLimit float64
+	Burst     int
+}
+
+// TaskOption sets an option of a task type at registration.
+type TaskOption func(*TaskOptions)
+
+// WithMaxConcurrency limits a task type to n concurrent executions.
+func WithMaxConcurrency(n int) TaskOption {
+	return func(o *TaskOptions) { o.MaxConcurrency = n }
+}
+
+// WithRateLimit limits a task type to perSecond executions per second,
+// allowing bursts of burst tasks.
+func WithRateLimit(perSecond float64, burst int) TaskOption {
+	return func(o *TaskOptions) {
+		o.RateLimit = perSecond
+		o.Burst = burst
+	}
+}
+
 // RegisterTask registers a handler for a given task name.
 // It panics if a handler is already registered for the name.
-func RegisterTask(name string, h TaskHandler) {
+func RegisterTask(name string, h TaskHandler, opts ...TaskOption) {
 	mu.Lock()
 	defer mu.Unlock()
 
@@ -20,6 +53,11 @@ func RegisterTask(name string, h TaskHandler) {
 		panic(fmt.Sprintf("task handler already registered for %s", name))
 	}
 	registry[name] = h
+	var o TaskOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	options[name] = o
 }
 
 // LookupTask returns the handler for the given task name.
@@ -31,9 +69,46 @@ func LookupTask(name string) (TaskHandler, bool) {
 	return h, ok
 }
 
+// LookupTaskOptions returns the options the task was registered with.
+func LookupTaskOptions(name string) TaskOptions {
+	mu.RLock()
+	defer mu.RUnlock()
+	return options[name]
+}
+
+// RegisteredTasks returns the names of the registered tasks, sorted.
+func RegisteredTasks() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // ClearRegistry clears all registered tasks (useful for tests).
 func ClearRegistry() {
 	mu.Lock()
 	defer mu.Unlock()
 	registry = make(map[string]TaskHandler)
+	options = make(map[string]TaskOptions)
+}
+
+// RegisterResultTask registers a handler that returns a result.
+// It panics if a handler is already registered for the name.
+func RegisterResultTask(name string, h ResultTaskHandler, opts ...TaskOption) {
+	RegisterTask(name, resultHandlerAdapter{h}, opts...)
+}
+
+// resultHandlerAdapter lets a ResultTaskHandler live in the registry.
+type resultHandlerAdapter struct {
+	ResultTaskHandler
+}
+
+func (a resultHandlerAdapter) Handle(ctx context.Context, payload json.RawMessage) error {
+	_, err := a.HandleResult(ctx, payload)
+	return err
 }
@@ -51,3 +51,40 @@ func TestRegisterDuplicatePanics(t *testing.T) {
 
 	RegisterTask(name, &mockHandler{})
 }
+
+func TestRegisteredTasks(t *testing.T) {
+	ClearRegistry()
+	defer ClearRegistry()
+
+	if names := RegisteredTasks(); len(names) != 0 {
+		t.Fatalf("expected no tasks, got %v", names)
+	}
+	RegisterTask("b_task", &mockHandler{})
+	RegisterTask("a_task", &mockHandler{})
+
+	names := RegisteredTasks()
+	if len(names) != 2 || names[0] != "a_task" || names[1] != "b_task" {
+		t.Fatalf("expected sorted task names, got %v", names)
+	}
+}
+
+func TestTaskOptions(t *testing.T) {
+	ClearRegistry()
+	defer ClearRegistry()
+
+	RegisterTask("plain", &mockHandler{})
+	RegisterTask("limited", &mockHandler{}, WithMaxConcurrency(2), WithRateLimit(5, 10))
+
+	if o := LookupTaskOptions("plain"); o != (TaskOptions{}) {
+		t.Fatalf("expected no limits, got %+v", o)
+	}
+	want := TaskOptions{MaxConcurrency: 2, RateLimit: 5, Burst: 10}
+	if o := LookupTaskOptions("limited"); o != want {
+		t.Fatalf("expected %+v, got %+v", want, o)
+	}
+
+	ClearRegistry()
+	if o := LookupTaskOptions("limited"); o != (TaskOptions{}) {
+		t.Fatalf("expected options to be cleared, got %+v", o)
+	}
+}
@@ -0,0 +1,144 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"base-go-app/internal/logging"
+	"base-go-app/internal/results"
+	"base-go-app/internal/workflow"
+
+	"github.com/google/uuid"
+)
+
+// Signature describes a task published later by the worker as part of a
+// workflow: a step of a chain or the callback of a chord.
+type Signature struct {
+	Task string `json:"task"`
+	// Queue defaults to the queue of the task publishing it
+	Queue string `json:"queue,omitempty"`
+	// Payload defaults to the result of the previous step, so chained
+	// tasks can consume each other's results directly
+	Payload        json.RawMessage `json:"payload,omitempty"`
+	MaxAttempts    int             `json:"max_attempts,omitempty"`
+	TimeoutSeconds int             `json:"timeout_seconds,omitempty"`
+	IdempotencyKey string          `json:"idempotency_key,omitempty"`
+	Meta           json.RawMessage `json:"meta,omitempty"`
+	Notify         *NotifyConfig   `json:"notify,omitempty"`
+}
+
+// GroupRef places a task in a group (see the workflow package).
+type GroupRef struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+}
+
+// NextTask is a task the worker publishes after a successful dispatch.
+type NextTask struct {
+	// Queue is empty for the queue of the task that produced it
+	Queue    string
+	Envelope TaskPayload
+}
+
+// Envelope builds a new envelope running s after a step that returned
+// parent.
+func (s Signature) Envelope(parent json.RawMessage) TaskPayload {
+	env := TaskPayload{
+		Version:        EnvelopeVersion,
+		ID:             uuid.New().String(),
+		Task:           s.Task,
+		Payload:        s.Payload,
+		CreatedAt:      time.Now().Format(time.RFC3339),
+		MaxAttempts:    s.MaxAttempts,
+		TimeoutSeconds: s.TimeoutSeconds,
+		IdempotencyKey: s.IdempotencyKey,
+		Meta:           s.Meta,
+		Notify:         s.Notify,
+		ParentResult:   parent,
+	}
+	if env.MaxAttempts <= 0 {
+		env.MaxAttempts = DefaultMaxAttempts
+	}
+	if len(env.Payload) == 0 {
+		env.Payload = parent
+	}
+	if len(env.Payload) == 0 {
+		env.Payload = json.RawMessage(`{}`)
+	}
+	return env
+}
+
+type parentResultKey struct{}
+
+// ParentResult returns the result of the previous chain step, or the
+// results of the chord members as a JSON array, in a handler's context.
+func ParentResult(ctx context.Context) json.RawMessage {
+	r, _ := ctx.Value(parentResultKey{}).(json.RawMessage)
+	return r
+}
+
+// advance returns the tasks following a task that succeeded with result:
+// the next step of its chain and, if it finished a chord, the callback.
+func (d *Dispatcher) advance(ctx context.Context, envelope *TaskPayload, result json.RawMessage) []NextTask {
+	var next []NextTask
+	if len(envelope.Chain) > 0 {
+		step := envelope.Chain[0]
+		env := step.Envelope(result)
+		env.Chain = envelope.Chain[1:]
+		next = append(next, NextTask{Queue: step.Queue, Envelope: env})
+	}
+	if cb := d.completeMember(ctx, envelope, result, nil); cb != nil {
+		next = append(next, *cb)
+	}
+	for i := range next {
+		d.record(ctx, &next[i].Envelope, results.StatusPending, nil, nil)
+	}
+	return next
+}
+
+// completeMember records the outcome of a group member and returns the
+// chord callback if this member finished the group and all members
+// succeeded.
+func (d *Dispatcher) completeMember(ctx context.Context, envelope *TaskPayload, result json.RawMessage, taskErr error) *NextTask {
+	g := envelope.Group
+	if g == nil {
+		return nil
+	}
+	if d.Workflows == nil {
+		logging.FromContext(ctx).Error("Task is in a group, but no workflow store is configured", "group", g.ID)
+		return nil
+	}
+
+	c, err := d.Workflows.CompleteMember(ctx, g.ID, g.Index, result, taskErr)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to record group member", "group", g.ID, logging.Err(err))
+		return nil
+	}
+	if !c.Trigger || len(c.Callback) == 0 {
+		return nil
+	}
+	if c.Status != workflow.StatusSucceeded {
+		logging.FromContext(ctx).Info("Group failed, skipping its callback", "group", g.ID)
+		return nil
+	}
+
+	var sig Signature
+	if err := json.Unmarshal(c.Callback, &sig); err != nil {
+		logging.FromContext(ctx).Error("Group has an invalid callback", "group", g.ID, logging.Err(err))
+		return nil
+	}
+	memberResults, err := json.Marshal(c.Results)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to encode the group results", "group", g.ID, logging.Err(err))
+		return nil
+	}
+	env := sig.Envelope(memberResults)
+	if env.IdempotencyKey == "" {
+		// The last member may be redelivered after publishing the
+		// callback; with an idempotency store the callback then runs once
+		env.IdempotencyKey = "chord:" + g.ID
+	}
+	logging.FromContext(ctx).Info("Group finished, publishing callback", "group", g.ID, "callback", env.Task, "callback_id", env.ID)
+	return &NextTask{Queue: sig.Queue, Envelope: env}
+}
@@ -0,0 +1,202 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"base-go-app/internal/broadcast"
+	"base-go-app/internal/webhook"
+	"base-go-app/internal/workflow"
+)
+
+func TestDispatcherAdvancesChain(t *testing.T) {
+	ClearRegistry()
+	var parent json.RawMessage
+	RegisterResultTask("sum", TypedHandler[sumPayload, int](func(ctx context.Context, p sumPayload) (int, error) {
+		parent = ParentResult(ctx)
+		return p.A + p.B, nil
+	}))
+
+	d := NewDispatcher(&broadcast.NoOpBroadcaster{}, &webhook.NoOpClient{})
+	body, _ := json.Marshal(TaskPayload{
+		Task:         "sum",
+		ID:           "1",
+		Payload:      json.RawMessage(`{"a":2,"b":3}`),
+		ParentResult: json.RawMessage(`"previous"`),
+		Chain: []Signature{
+			{Task: "double", Queue: "go.math"},
+			{Task: "store", Payload: json.RawMessage(`{"table":"sums"}`)},
+		},
+	})
+
+	res := d.Dispatch(context.Background(), body)
+	if !res.Success {
+		t.Fatalf("expected success, got error: %v", res.Error)
+	}
+	if string(parent) != `"previous"` {
+		t.Fatalf("expected parent result in context, got %s", parent)
+	}
+	if len(res.Next) != 1 {
+		t.Fatalf("expected the next chain step, got %+v", res.Next)
+	}
+	next := res.Next[0]
+	if next.Queue != "go.math" || next.Envelope.Task != "double" {
+		t.Fatalf("unexpected next task: %+v", next)
+	}
+	if string(next.Envelope.Payload) != "5" || string(next.Envelope.ParentResult) != "5" {
+		t.Fatalf("expected the result as payload, got %s / %s", next.Envelope.Payload, next.Envelope.ParentResult)
+	}
+	if len(next.Envelope.Chain) != 1 || next.Envelope.Chain[0].Task != "store" {
+		t.Fatalf("expected the rest of the chain, got %+v", next.Envelope.Chain)
+	}
+	if next.Envelope.MaxAttempts != DefaultMaxAttempts || next.Envelope.ID == "" {
+		t.Fatalf("unexpected envelope: %+v", next.Envelope)
+	}
+	if err := next.Envelope.Validate(); err != nil {
+		t.Fatalf("invalid next envelope: %v", err)
+	}
+
+	// The last step keeps its own payload
+	parent = nil
+	body, _ = json.Marshal(next.Envelope)
+	RegisterResultTask("double", TypedHandler[int, int](func(ctx context.Context, n int) (int, error) {
+		return 2 * n, nil
+	}))
+	res = d.Dispatch(context.Background(), body)
+	if !res.Success || len(res.Next) != 1 {
+		t.Fatalf("expected the last step, got %+v", res)
+	}
+	last := res.Next[0].Envelope
+	if string(last.Payload) != `{"table":"sums"}` || string(last.ParentResult) != "10" || len(last.Chain) != 0 {
+		t.Fatalf("unexpected last step: %+v", last)
+	}
+}
+
+func TestDispatcherStopsChainOnFailure(t *testing.T) {
+	ClearRegistry()
+	RegisterTask("fail_task", &failHandler{})
+
+	d := NewDispatcher(&broadcast.NoOpBroadcaster{}, &webhook.NoOpClient{})
+	body, _ := json.Marshal(TaskPayload{
+		Task:        "fail_task",
+		ID:          "1",
+		MaxAttempts: 1,
+		Payload:     json.RawMessage(`{}`),
+		Chain:       []Signature{{Task: "next"}},
+	})
+
+	res := d.Dispatch(context.Background(), body)
+	if res.Success || len(res.Next) != 0 {
+		t.Fatalf("expected failure without next tasks, got %+v", res)
+	}
+}
+
+func TestDispatcherTriggersChordCallback(t *testing.T) {
+	ClearRegistry()
+	RegisterResultTask("square", TypedHandler[int, int](func(ctx context.Context, n int) (int, error) {
+		return n * n, nil
+	}))
+
+	store := workflow.NewMemoryStore(0)
+	callback, _ := json.Marshal(Signature{Task: "total", Queue: "go.sum"})
+	if err := store.CreateGroup(context.Background(), "g1", []string{"a", "b"}, callback); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(&broadcast.NoOpBroadcaster{}, &webhook.NoOpClient{})
+	d.Workflows = store
+	member := func(index int, n string) []byte {
+		body, _ := json.Marshal(TaskPayload{
+			Task:    "square",
+			ID:      string(rune('a' + index)),
+			Payload: json.RawMessage(n),
+			Group:   &GroupRef{ID: "g1", Index: index},
+		})
+		return body
+	}
+
+	res := d.Dispatch(context.Background(), member(1, "3"))
+	if !res.Success || len(res.Next) != 0 {
+		t.Fatalf("expected no callback before the group finished, got %+v", res)
+	}
+	res = d.Dispatch(context.Background(), member(0, "2"))
+	if !res.Success || len(res.Next) != 1 {
+		t.Fatalf("expected the callback, got %+v", res)
+	}
+	cb := res.Next[0]
+	if cb.Queue != "go.sum" || cb.Envelope.Task != "total" {
+		t.Fatalf("unexpected callback: %+v", cb)
+	}
+	if string(cb.Envelope.Payload) != "[4,9]" {
+		t.Fatalf("expected member results in order, got %s", cb.Envelope.Payload)
+	}
+	if cb.Envelope.IdempotencyKey != "chord:g1" {
+		t.Fatalf("expected chord idempotency key, got %q", cb.Envelope.IdempotencyKey)
+	}
+
+	// A redelivered last member publishes the callback again
+	res = d.Dispatch(context.Background(), member(0, "2"))
+	if len(res.Next) != 1 || res.Next[0].Envelope.IdempotencyKey != "chord:g1" {
+		t.Fatalf("expected the callback again, got %+v", res)
+	}
+}
+
+func TestDispatcherSkipsCallbackOfFailedChord(t *testing.T) {
+	ClearRegistry()
+	RegisterTask("test_task", &mockHandler{})
+	RegisterTask("fail_task", &failHandler{})
+
+	store := workflow.NewMemoryStore(0)
+	callback, _ := json.Marshal(Signature{Task: "total"})
+	if err := store.CreateGroup(context.Background(), "g1", []string{"a", "b"}, callback); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(&broadcast.NoOpBroadcaster{}, &webhook.NoOpClient{})
+	d.Workflows = store
+
+	body, _ := json.Marshal(TaskPayload{Task: "fail_task", ID: "a", MaxAttempts: 1, Payload: json.RawMessage(`{}`), Group: &GroupRef{ID: "g1", Index: 0}})
+	if res := d.Dispatch(context.Background(), body); res.Success {
+		t.Fatalf("expected failure")
+	}
+	body, _ = json.Marshal(TaskPayload{Task: "test_task", ID: "b", Payload: json.RawMessage(`{}`), Group: &GroupRef{ID: "g1", Index: 1}})
+	res := d.Dispatch(context.Background(), body)
+	if !res.Success || len(res.Next) != 0 {
+		t.Fatalf("expected no callback for a failed group, got %+v", res)
+	}
+
+	g, err := store.Get(context.Background(), "g1")
+	if err != nil || g.Status != workflow.StatusFailed || g.FinishedAt == nil {
+		t.Fatalf("expected a finished failed group, got %+v (%v)", g, err)
+	}
+}
+
+func TestDispatcherFinishesGroupOfRejectedMember(t *testing.T) {
+	ClearRegistry()
+	RegisterTask("test_task", &mockHandler{})
+
+	store := workflow.NewMemoryStore(0)
+	callback, _ := json.Marshal(Signature{Task: "total"})
+	if err := store.CreateGroup(context.Background(), "g1", []string{"a", "b"}, callback); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(&broadcast.NoOpBroadcaster{}, &webhook.NoOpClient{})
+	d.Workflows = store
+
+	// Published by an incompatible publisher: fails without running
+	body, _ := json.Marshal(TaskPayload{Task: "test_task", ID: "a", Version: "2.0", Payload: json.RawMessage(`{}`), Group: &GroupRef{ID: "g1", Index: 0}})
+	if res := d.Dispatch(context.Background(), body); res.Success || res.Retry {
+		t.Fatalf("expected a rejected task, got %+v", res)
+	}
+	body, _ = json.Marshal(TaskPayload{Task: "test_task", ID: "b", Payload: json.RawMessage(`{}`), Group: &GroupRef{ID: "g1", Index: 1}})
+	if res := d.Dispatch(context.Background(), body); !res.Success || len(res.Next) != 0 {
+		t.Fatalf("expected no callback for a failed group, got %+v", res)
+	}
+
+	g, err := store.Get(context.Background(), "g1")
+	if err != nil || g.Status != workflow.StatusFailed || g.FinishedAt == nil {
+		t.Fatalf("expected a finished failed group, got %+v (%v)", g, err)
+	}
+}
@@ -0,0 +1,205 @@
+// Package tracing configures OpenTelemetry tracing and propagates W3C trace
+// context through AMQP message headers and outbound HTTP requests.
+//
+// Until Setup installs an exporter the global tracer provider is a no-op:
+// spans cost next to nothing, but trace context received from publishers
+// is still passed on, so traces started upstream stay connected.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters (OTEL_TRACES_EXPORTER).
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+)
+
+// tracerName names the tracer of this module.
+const tracerName = "base-go-app"
+
+func init() {
+	// Propagate W3C trace context even when nothing is exported
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+}
+
+// Setup installs the tracer provider for exporter (ExporterOTLP, or
+// ExporterNone / empty for the no-op default) and returns a function that
+// flushes and stops it. The OTLP exporter is configured by the standard
+// OTEL_EXPORTER_OTLP_* variables (see newOTLPExporter); OTEL_SERVICE_NAME
+// and OTEL_RESOURCE_ATTRIBUTES override service.
+func Setup(ctx context.Context, exporter, service string) (func(context.Context) error, error) {
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+	default:
+		return nil, fmt.Errorf("unknown traces exporter %q", exporter)
+	}
+
+	exp, err := newOTLPExporter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", service)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// OTLP protocols (OTEL_EXPORTER_OTLP_PROTOCOL).
+const (
+	ProtocolHTTP = "http/protobuf"
+	ProtocolGRPC = "grpc"
+)
+
+// otlpProtocol returns the protocol set by OTEL_EXPORTER_OTLP_TRACES_PROTOCOL
+// or OTEL_EXPORTER_OTLP_PROTOCOL, http/protobuf by default.
+func otlpProtocol() string {
+	for _, key := range []string{"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"} {
+		if p := os.Getenv(key); p != "" {
+			return p
+		}
+	}
+	return ProtocolHTTP
+}
+
+// newOTLPExporter creates the OTLP exporter for the configured protocol.
+// The exporters read the other OTEL_EXPORTER_OTLP_* variables themselves:
+// endpoint, headers, timeout, compression and TLS.
+func newOTLPExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+	switch p := otlpProtocol(); p {
+	case ProtocolHTTP:
+		exp, err = otlptracehttp.New(ctx)
+	case ProtocolGRPC:
+		exp, err = otlptracegrpc.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q", p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	return exp, nil
+}
+
+// Tracer returns the tracer used throughout the worker and publisher.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// HeaderCarrier adapts AMQP message headers to a TextMapCarrier. Only
+// string values are read back.
+type HeaderCarrier map[string]interface{}
+
+// Get returns the value of key, if it is a string.
+func (c HeaderCarrier) Get(key string) string {
+	switch v := c[key].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// Set stores value under key.
+func (c HeaderCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys lists the header names.
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Inject writes the trace context of ctx into headers, allocating them if
+// needed, and returns them.
+func Inject(ctx context.Context, headers map[string]interface{}) map[string]interface{} {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return headers
+	}
+	if headers == nil {
+		headers = map[string]interface{}{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, HeaderCarrier(headers))
+	return headers
+}
+
+// Extract returns ctx with the trace context carried by headers, if any.
+func Extract(ctx context.Context, headers map[string]interface{}) context.Context {
+	if headers == nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, HeaderCarrier(headers))
+}
+
+// Do sends req with client inside a client span, passing the trace context
+// on in the request headers.
+func Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	ctx, span := Tracer().Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Hostname()),
+			attribute.String("url.path", req.URL.Path),
+		),
+	)
+	defer span.End()
+
+	req = req.WithContext(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, strconv.Itoa(resp.StatusCode))
+	}
+	return resp, nil
+}
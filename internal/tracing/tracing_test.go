@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider recording spans for the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func TestInjectExtract(t *testing.T) {
+	recordSpans(t)
+	ctx, span := Tracer().Start(context.Background(), "publish")
+	defer span.End()
+
+	headers := Inject(ctx, nil)
+	require.Contains(t, headers, "traceparent")
+
+	got := trace.SpanContextFromContext(Extract(context.Background(), headers))
+	assert.True(t, got.IsRemote())
+	assert.Equal(t, span.SpanContext().TraceID(), got.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), got.SpanID())
+}
+
+func TestInjectWithoutTrace(t *testing.T) {
+	assert.Nil(t, Inject(context.Background(), nil))
+
+	headers := map[string]interface{}{"task": "logger"}
+	assert.Equal(t, map[string]interface{}{"task": "logger"}, Inject(context.Background(), headers))
+}
+
+func TestExtractBytes(t *testing.T) {
+	headers := map[string]interface{}{"traceparent": []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")}
+	sc := trace.SpanContextFromContext(Extract(context.Background(), headers))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+}
+
+func TestDo(t *testing.T) {
+	rec := recordSpans(t)
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	ctx, parent := Tracer().Start(context.Background(), "task")
+	req, err := http.NewRequestWithContext(ctx, "POST", srv.URL+"/hook", nil)
+	require.NoError(t, err)
+	resp, err := Do(srv.Client(), req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	parent.End()
+
+	require.NotEmpty(t, traceparent)
+	assert.Contains(t, traceparent, parent.SpanContext().TraceID().String())
+
+	spans := rec.Ended()
+	require.Len(t, spans, 2)
+	client := spans[0]
+	assert.Equal(t, "HTTP POST", client.Name())
+	assert.Equal(t, trace.SpanKindClient, client.SpanKind())
+	assert.Equal(t, parent.SpanContext().SpanID(), client.Parent().SpanID())
+	assert.Equal(t, codes.Error, client.Status().Code)
+}
+
+func TestEnd(t *testing.T) {
+	rec := recordSpans(t)
+	_, span := Tracer().Start(context.Background(), "ok")
+	End(span, nil)
+	_, span = Tracer().Start(context.Background(), "failed")
+	End(span, errors.New("boom"))
+
+	spans := rec.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, "boom", spans[1].Status().Description)
+}
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), "", "test")
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = Setup(context.Background(), "zipkin", "test")
+	assert.Error(t, err)
+}
+
+func TestSetupOTLP(t *testing.T) {
+	type export struct{ path, contentType, auth string }
+	got := make(chan export, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- export{r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Authorization")}
+	}))
+	defer srv.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", srv.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer%20t")
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	shutdown, err := Setup(context.Background(), ExporterOTLP, "test")
+	require.NoError(t, err)
+	_, span := Tracer().Start(context.Background(), "logger")
+	span.End()
+	// Flushes the batch
+	require.NoError(t, shutdown(context.Background()))
+
+	e := <-got
+	assert.Equal(t, "/v1/traces", e.path)
+	assert.Equal(t, "application/x-protobuf", e.contentType)
+	assert.Equal(t, "Bearer t", e.auth)
+}
+
+func TestSetupOTLPProtocol(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")
+	_, err := Setup(context.Background(), ExporterOTLP, "test")
+	assert.ErrorContains(t, err, `unsupported OTLP protocol "http/json"`)
+
+	// The traces variable wins
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", ProtocolGRPC)
+	shutdown, err := Setup(context.Background(), ExporterOTLP, "test")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_ = shutdown(ctx)
+}
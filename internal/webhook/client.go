@@ -9,6 +9,9 @@ import (
 	"net/url"
 	"sync"
 	"time"
+
+	"base-go-app/internal/logging"
+	"base-go-app/internal/tracing"
 )
 
 type OAuthClient struct {
@@ -55,7 +58,7 @@ func (c *OAuthClient) Send(ctx context.Context, targetURL string, payload interf
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := tracing.Do(c.HTTPClient, req)
 	if err != nil {
 		return err
 	}
@@ -65,6 +68,7 @@ func (c *OAuthClient) Send(ctx context.Context, targetURL string, payload interf
 		return fmt.Errorf("webhook failed with status: %d", resp.StatusCode)
 	}
 
+	logging.FromContext(ctx).Debug("Webhook sent", "url", targetURL, "status", resp.StatusCode)
 	return nil
 }
 
@@ -123,7 +127,7 @@ func (c *OAuthClient) fetchToken(ctx context.Context, overrideID, overrideScope
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := tracing.Do(c.HTTPClient, req)
 	if err != nil {
 		return "", err
 	}
@@ -132,6 +136,7 @@ func (c *OAuthClient) fetchToken(ctx context.Context, overrideID, overrideScope
 	if resp.StatusCode != 200 {
 		return "", fmt.Errorf("token fetch failed: %d", resp.StatusCode)
 	}
+	logging.FromContext(ctx).Debug("Fetched OAuth token", "client_id", clientID, "scope", scope)
 
 	var res struct {
 		AccessToken string `json:"access_token"`
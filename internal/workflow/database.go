@@ -0,0 +1,120 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"base-go-app/internal/database"
+	"base-go-app/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DBStore keeps groups in the task_groups and task_group_members tables so
+// every worker pod sees the same state. The tables are created on first use.
+type DBStore struct {
+	ttl time.Duration
+}
+
+// NewDBStore creates a database store whose finished groups expire after
+// ttl.
+func NewDBStore(ttl time.Duration) *DBStore {
+	return &DBStore{ttl: ttl}
+}
+
+func (s *DBStore) CreateGroup(ctx context.Context, id string, taskIDs []string, callback json.RawMessage) error {
+	db, err := database.Migrated(ctx, &models.TaskGroup{}, &models.TaskGroupMember{})
+	if err != nil {
+		return err
+	}
+	g := newGroup(id, taskIDs, callback, time.Now())
+	// Creates the members too
+	return db.Create(&g).Error
+}
+
+func (s *DBStore) CompleteMember(ctx context.Context, id string, index int, result json.RawMessage, taskErr error) (*Completion, error) {
+	db, err := database.Migrated(ctx, &models.TaskGroup{}, &models.TaskGroupMember{})
+	if err != nil {
+		return nil, err
+	}
+
+	var c *Completion
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Lock the group so concurrent members are counted one at a time
+		var g models.TaskGroup
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", id).
+			Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+			Take(&g).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", id).Order("\"index\"").Find(&g.Members).Error; err != nil {
+			return err
+		}
+
+		var changed bool
+		c, changed, err = complete(&g, index, result, taskErr, s.ttl, time.Now())
+		if err != nil || !changed {
+			return err
+		}
+		m := g.Members[index]
+		err = tx.Model(&models.TaskGroupMember{}).
+			Where("group_id = ? AND \"index\" = ?", id, index).
+			Updates(map[string]interface{}{"status": m.Status, "result": m.Result, "error": m.Error}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Omit(clause.Associations).Save(&g).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *DBStore) Get(ctx context.Context, id string) (*models.TaskGroup, error) {
+	db, err := database.Migrated(ctx, &models.TaskGroup{}, &models.TaskGroupMember{})
+	if err != nil {
+		return nil, err
+	}
+
+	var g models.TaskGroup
+	err = db.Preload("Members", func(db *gorm.DB) *gorm.DB { return db.Order("\"index\"") }).
+		Where("id = ?", id).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Take(&g).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func (s *DBStore) DeleteExpired(ctx context.Context) (int64, error) {
+	db, err := database.Migrated(ctx, &models.TaskGroup{}, &models.TaskGroupMember{})
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(&models.TaskGroup{}).Select("id").Where("expires_at IS NOT NULL AND expires_at <= ?", now)
+		if err := tx.Where("group_id IN (?)", expired).Delete(&models.TaskGroupMember{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("expires_at IS NOT NULL AND expires_at <= ?", now).Delete(&models.TaskGroup{})
+		n = res.RowsAffected
+		return res.Error
+	})
+	return n, err
+}
@@ -0,0 +1,76 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"base-go-app/internal/models"
+)
+
+// MemoryStore is an in-process Store, mainly for tests and local runs.
+type MemoryStore struct {
+	ttl    time.Duration
+	mu     sync.Mutex
+	groups map[string]*models.TaskGroup
+}
+
+// NewMemoryStore creates an in-memory store whose finished groups expire
+// after ttl.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{ttl: ttl, groups: make(map[string]*models.TaskGroup)}
+}
+
+func (m *MemoryStore) CreateGroup(ctx context.Context, id string, taskIDs []string, callback json.RawMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.groups[id]; ok {
+		return fmt.Errorf("task group %s already exists", id)
+	}
+	g := newGroup(id, taskIDs, callback, time.Now())
+	m.groups[id] = &g
+	return nil
+}
+
+func (m *MemoryStore) CompleteMember(ctx context.Context, id string, index int, result json.RawMessage, taskErr error) (*Completion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, ok := m.groups[id]
+	if !ok || expired(g, time.Now()) {
+		return nil, ErrNotFound
+	}
+	c, _, err := complete(g, index, result, taskErr, m.ttl, time.Now())
+	return c, err
+}
+
+func (m *MemoryStore) Get(ctx context.Context, id string) (*models.TaskGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, ok := m.groups[id]
+	if !ok || expired(g, time.Now()) {
+		return nil, ErrNotFound
+	}
+	out := *g
+	out.Members = append([]models.TaskGroupMember(nil), g.Members...)
+	return &out, nil
+}
+
+func (m *MemoryStore) DeleteExpired(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var n int64
+	for id, g := range m.groups {
+		if expired(g, now) {
+			delete(m.groups, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func expired(g *models.TaskGroup, now time.Time) bool {
+	return g.ExpiresAt != nil && !now.Before(*g.ExpiresAt)
+}
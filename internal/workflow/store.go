@@ -0,0 +1,142 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"base-go-app/internal/logging"
+	"base-go-app/internal/models"
+)
+
+// Group and member statuses.
+const (
+	StatusRunning   = "running"
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// DefaultExpiry is how long finished groups are kept when no TTL is given.
+const DefaultExpiry = 24 * time.Hour
+
+// ErrNotFound is returned for unknown or expired groups.
+var ErrNotFound = errors.New("task group not found")
+
+// Completion is the state of a group after one of its members finished.
+type Completion struct {
+	// Status is the group status: running, succeeded or failed. A group
+	// fails as soon as one member fails.
+	Status string
+	// Finished is set once every member has finished.
+	Finished bool
+	// Trigger is set for the member whose completion finished the group,
+	// also when its message is redelivered. It publishes the callback.
+	Trigger bool
+	// Results are the member results in order, once finished.
+	Results []json.RawMessage
+	// Callback is the encoded chord callback, if any.
+	Callback json.RawMessage
+}
+
+// Store tracks the members of task groups and chords.
+type Store interface {
+	// CreateGroup records a group with one member per task ID, before the
+	// members are published. callback is the encoded chord callback, if any.
+	CreateGroup(ctx context.Context, id string, taskIDs []string, callback json.RawMessage) error
+	// CompleteMember records the outcome of member index (taskErr is nil on
+	// success) and returns the state of the group. Recording a member twice
+	// keeps the first outcome.
+	CompleteMember(ctx context.Context, id string, index int, result json.RawMessage, taskErr error) (*Completion, error)
+	// Get returns a group with its members, or ErrNotFound.
+	Get(ctx context.Context, id string) (*models.TaskGroup, error)
+	// DeleteExpired removes finished groups whose expiry has passed and
+	// returns how many were removed.
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// newGroup builds the record of a new group.
+func newGroup(id string, taskIDs []string, callback json.RawMessage, now time.Time) models.TaskGroup {
+	g := models.TaskGroup{
+		ID:        id,
+		Size:      len(taskIDs),
+		Status:    StatusRunning,
+		Callback:  callback,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for i, taskID := range taskIDs {
+		g.Members = append(g.Members, models.TaskGroupMember{GroupID: id, Index: i, TaskID: taskID, Status: StatusPending})
+	}
+	return g
+}
+
+// complete folds the outcome of member index into g, whose members must
+// be sorted by index, and reports whether g changed. Finished groups
+// expire after ttl (never when ttl <= 0).
+func complete(g *models.TaskGroup, index int, result json.RawMessage, taskErr error, ttl time.Duration, now time.Time) (*Completion, bool, error) {
+	if index < 0 || index >= len(g.Members) {
+		return nil, false, fmt.Errorf("task group %s has no member %d", g.ID, index)
+	}
+
+	m := &g.Members[index]
+	changed := m.Status == StatusPending
+	if changed {
+		if taskErr != nil {
+			m.Status = StatusFailed
+			m.Error = taskErr.Error()
+			g.Failed++
+			g.Status = StatusFailed
+		} else {
+			m.Status = StatusSucceeded
+			m.Result = result
+			g.Succeeded++
+		}
+		g.UpdatedAt = now
+		if g.Succeeded+g.Failed == g.Size {
+			if g.Status == StatusRunning {
+				g.Status = StatusSucceeded
+			}
+			g.FinishedAt = &now
+			g.FinishedBy = &index
+			if ttl > 0 {
+				expires := now.Add(ttl)
+				g.ExpiresAt = &expires
+			}
+		}
+	}
+
+	c := &Completion{Status: g.Status, Finished: g.FinishedAt != nil, Callback: g.Callback}
+	c.Trigger = c.Finished && g.FinishedBy != nil && *g.FinishedBy == index
+	if c.Finished {
+		c.Results = make([]json.RawMessage, len(g.Members))
+		for i, m := range g.Members {
+			c.Results[i] = m.Result
+		}
+	}
+	return c, changed, nil
+}
+
+// StartJanitor deletes expired groups every interval until ctx is canceled.
+func StartJanitor(ctx context.Context, s Store, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := s.DeleteExpired(ctx)
+				if err != nil {
+					slog.Warn("Failed to delete expired task groups", logging.Err(err))
+				} else if n > 0 {
+					slog.Debug("Deleted expired task groups", "count", n)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,161 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"base-go-app/internal/database/databasetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStores runs fn against every Store implementation.
+func testStores(t *testing.T, ttl time.Duration, fn func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemoryStore(ttl))
+	})
+	t.Run("database", func(t *testing.T) {
+		databasetest.Use(t)
+		fn(t, NewDBStore(ttl))
+	})
+}
+
+func TestGroupSucceeds(t *testing.T) {
+	testStores(t, time.Hour, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		require.NoError(t, s.CreateGroup(ctx, "g1", []string{"a", "b", "c"}, json.RawMessage(`{"task":"sum"}`)))
+
+		c, err := s.CompleteMember(ctx, "g1", 2, json.RawMessage(`3`), nil)
+		require.NoError(t, err)
+		assert.Equal(t, StatusRunning, c.Status)
+		assert.False(t, c.Finished)
+		assert.False(t, c.Trigger)
+
+		_, err = s.CompleteMember(ctx, "g1", 0, json.RawMessage(`1`), nil)
+		require.NoError(t, err)
+		c, err = s.CompleteMember(ctx, "g1", 1, json.RawMessage(`2`), nil)
+		require.NoError(t, err)
+		assert.Equal(t, StatusSucceeded, c.Status)
+		assert.True(t, c.Finished)
+		assert.True(t, c.Trigger)
+		assert.JSONEq(t, `{"task":"sum"}`, string(c.Callback))
+		require.Len(t, c.Results, 3)
+		assert.JSONEq(t, `1`, string(c.Results[0]))
+		assert.JSONEq(t, `3`, string(c.Results[2]))
+
+		// A redelivered member keeps its first outcome; only the member
+		// that finished the group triggers the callback
+		c, err = s.CompleteMember(ctx, "g1", 0, nil, errors.New("late"))
+		require.NoError(t, err)
+		assert.Equal(t, StatusSucceeded, c.Status)
+		assert.False(t, c.Trigger)
+		c, err = s.CompleteMember(ctx, "g1", 1, json.RawMessage(`2`), nil)
+		require.NoError(t, err)
+		assert.True(t, c.Trigger)
+
+		g, err := s.Get(ctx, "g1")
+		require.NoError(t, err)
+		assert.Equal(t, 3, g.Size)
+		assert.Equal(t, 3, g.Succeeded)
+		assert.NotNil(t, g.FinishedAt)
+		assert.NotNil(t, g.ExpiresAt)
+		require.Len(t, g.Members, 3)
+		assert.Equal(t, "b", g.Members[1].TaskID)
+		assert.Equal(t, StatusSucceeded, g.Members[1].Status)
+		assert.JSONEq(t, `2`, string(g.Members[1].Result))
+	})
+}
+
+func TestGroupFails(t *testing.T) {
+	testStores(t, time.Hour, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		require.NoError(t, s.CreateGroup(ctx, "g2", []string{"a", "b"}, nil))
+
+		c, err := s.CompleteMember(ctx, "g2", 0, nil, errors.New("boom"))
+		require.NoError(t, err)
+		assert.Equal(t, StatusFailed, c.Status)
+		assert.False(t, c.Finished)
+
+		c, err = s.CompleteMember(ctx, "g2", 1, json.RawMessage(`"ok"`), nil)
+		require.NoError(t, err)
+		assert.Equal(t, StatusFailed, c.Status)
+		assert.True(t, c.Finished)
+
+		g, err := s.Get(ctx, "g2")
+		require.NoError(t, err)
+		assert.Equal(t, 1, g.Failed)
+		assert.Equal(t, "boom", g.Members[0].Error)
+	})
+}
+
+func TestGroupErrors(t *testing.T) {
+	testStores(t, time.Hour, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		_, err := s.CompleteMember(ctx, "missing", 0, nil, nil)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = s.Get(ctx, "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, s.CreateGroup(ctx, "g3", []string{"a"}, nil))
+		assert.Error(t, s.CreateGroup(ctx, "g3", []string{"a"}, nil))
+		_, err = s.CompleteMember(ctx, "g3", 1, nil, nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestGroupConcurrentMembers(t *testing.T) {
+	testStores(t, time.Hour, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		ids := make([]string, 10)
+		for i := range ids {
+			ids[i] = string(rune('a' + i))
+		}
+		require.NoError(t, s.CreateGroup(ctx, "g4", ids, nil))
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		triggers := 0
+		for i := range ids {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				c, err := s.CompleteMember(ctx, "g4", i, json.RawMessage(`1`), nil)
+				if !assert.NoError(t, err) {
+					return
+				}
+				if c.Trigger {
+					mu.Lock()
+					triggers++
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.Equal(t, 1, triggers)
+	})
+}
+
+func TestGroupExpiry(t *testing.T) {
+	testStores(t, time.Millisecond, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		require.NoError(t, s.CreateGroup(ctx, "g5", []string{"a"}, nil))
+		require.NoError(t, s.CreateGroup(ctx, "g6", []string{"a"}, nil))
+		_, err := s.CompleteMember(ctx, "g5", 0, nil, nil)
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+
+		n, err := s.DeleteExpired(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+		_, err = s.Get(ctx, "g5")
+		assert.ErrorIs(t, err, ErrNotFound)
+		// Running groups never expire
+		_, err = s.Get(ctx, "g6")
+		assert.NoError(t, err)
+	})
+}